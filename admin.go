@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// adminCommands 管理员可以在聊天中使用的命令
+var adminCommands = map[string]bool{
+	"/reply":     true,
+	"/broadcast": true,
+	"/ban":       true,
+	"/unban":     true,
+	"/users":     true,
+	"/stats":     true,
+	"/log":       true,
+	"/photourl":  true,
+	"/docurl":    true,
+	"/at":        true,
+	"/pin":       true,
+	"/unpin":     true,
+}
+
+// isAdminCommand 判断是否为管理员命令
+func isAdminCommand(cmd string) bool {
+	return adminCommands[cmd]
+}
+
+// adminCommand 处理管理员在聊天中发送的命令，参数解析方式与命令行一致
+func adminCommand(msg SimpleMsg, cmd string, args []string) {
+	switch cmd {
+	case "/reply":
+		if len(args) < 2 || !isNumber(args[0]) {
+			SendPlain(msg.ChatId, "usage: /reply <chatid> <text>")
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		text := strings.Join(args[1:], " ")
+		err := sendText(chatid, text)
+		recordAudit(msg.FromID, auditReply, chatid, auditResult(text, err))
+		SendPlain(msg.ChatId, deliveryStatus(chatid, err))
+	case "/broadcast":
+		if len(args) == 0 {
+			SendPlain(msg.ChatId, "usage: /broadcast <text>")
+			return
+		}
+		if !allowed(msg.FromID, auditBroadcast, 0) {
+			SendPlain(msg.ChatId, permissionDenied)
+			return
+		}
+		text := strings.Join(args, " ")
+		SendPlain(msg.ChatId, "开始广播...")
+		// 广播耗时较长，放到后台执行，避免阻塞后续消息的处理
+		go func() {
+			sent, skipped := broadcast(text)
+			recordAudit(msg.FromID, auditBroadcast, 0, fmt.Sprintf("sent=%d skipped=%d %s", sent, skipped, text))
+			SendPlain(msg.ChatId, fmt.Sprintf("广播完成: 发送 %d, 跳过 %d", sent, skipped))
+		}()
+	case "/ban", "/unban":
+		// 可以指定聊天ID，也可以在 owner 的聊天中回复转发的消息
+		var chatid int64
+		if len(args) > 0 && isNumber(args[0]) {
+			chatid, _ = strconv.ParseInt(args[0], 10, 64)
+		} else if msg.ChatId == getConfig().Account.Owner {
+			chatid = int64(lookupChatID(msg.ReplyID))
+		}
+		if chatid == 0 {
+			SendPlain(msg.ChatId, fmt.Sprintf("usage: %s <chatid>, or reply %s to a forwarded message", cmd, cmd))
+			return
+		}
+		if !allowed(msg.FromID, cmd[1:], chatid) {
+			SendPlain(msg.ChatId, permissionDenied)
+			return
+		}
+		if cmd == "/ban" {
+			if err := banUser(chatid); err != nil {
+				SendPlain(msg.ChatId, fmt.Sprintf("拉黑失败: %v", err))
+				return
+			}
+			recordAudit(msg.FromID, auditBan, chatid, "")
+			SendPlain(msg.ChatId, fmt.Sprintf("已拉黑用户 %d", chatid))
+		} else {
+			if err := unbanUser(chatid); err != nil {
+				SendPlain(msg.ChatId, fmt.Sprintf("解除拉黑失败: %v", err))
+				return
+			}
+			recordAudit(msg.FromID, auditUnban, chatid, "")
+			SendPlain(msg.ChatId, fmt.Sprintf("已解除拉黑用户 %d", chatid))
+		}
+	case "/photourl", "/docurl":
+		if len(args) != 2 || !isNumber(args[0]) {
+			SendPlain(msg.ChatId, fmt.Sprintf("usage: %s <chatid> <url>", cmd))
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		_, err := sendURLFile(chatid, strings.TrimSuffix(cmd[1:], "url"), args[1])
+		recordAudit(msg.FromID, auditReply, chatid, auditResult(cmd[1:]+" "+args[1], err))
+		if err != nil {
+			SendPlain(msg.ChatId, err.Error())
+			return
+		}
+		SendPlain(msg.ChatId, fmt.Sprintf("已发送给 %d", chatid))
+	case "/pin", "/unpin":
+		chatid, msgid, ok := parsePinArgs(args, cmd == "/pin")
+		if !ok {
+			SendPlain(msg.ChatId, "usage: /pin <chatid> <message_id> | /unpin <chatid> [message_id]")
+			return
+		}
+		if !allowed(msg.FromID, cmd[1:], chatid) {
+			SendPlain(msg.ChatId, permissionDenied)
+			return
+		}
+		err := pinMessage(chatid, msgid, cmd == "/pin")
+		recordAudit(msg.FromID, cmd[1:], chatid, auditResult(fmt.Sprintf("message #%d", msgid), err))
+		if err != nil {
+			SendPlain(msg.ChatId, fmt.Sprintf("✗ %s 失败: %v", cmd, err))
+			return
+		}
+		SendPlain(msg.ChatId, fmt.Sprintf("✓ %s 完成: %d", cmd, chatid))
+	case "/users":
+		SendPlain(msg.ChatId, formatUserCounts())
+	case "/stats":
+		entries, chats := mappingStats()
+		stats := loadDailyStats(time.Now().Format("2006-01-02"))
+		SendPlain(msg.ChatId, fmt.Sprintf("%s\n消息映射: %d 条, 涉及 %d 个用户", formatDailyStats(stats), entries, chats))
+	case "/at":
+		// 发送媒体时说明写 /at <时间> <chatid>，定时发送该媒体
+		item := outboxItem{}
+		switch {
+		case msg.PhotoID != "":
+			item.Kind, item.FileID = outboxPhoto, msg.PhotoID
+		case msg.VideoID != "":
+			item.Kind, item.FileID = outboxVideo, msg.VideoID
+		case msg.FileID != "":
+			item.Kind, item.FileID, item.FileName = outboxFile, msg.FileID, msg.FileName
+		case len(args) >= 3:
+			item.Kind, item.Text, item.ParseMode = outboxText, strings.Join(args[2:], " "), getConfig().ParseMode
+		}
+		if len(args) < 2 || item.Kind == "" {
+			SendPlain(msg.ChatId, "usage: /at <+30m|15:04|2006-01-02T15:04> <chatid> <text>，或发送图片、视频、文件时在说明中写 /at <时间> <chatid>")
+			return
+		}
+		at, err := parseScheduleTime(args[0], time.Now())
+		if err != nil {
+			SendPlain(msg.ChatId, err.Error())
+			return
+		}
+		if item.ChatID, err = parseScheduleChatID(args[1]); err != nil {
+			SendPlain(msg.ChatId, err.Error())
+			return
+		}
+		id, err := scheduleMessage(at, item)
+		recordAudit(msg.FromID, auditReply, item.ChatID, auditResult(scheduledDetail(id, at, item), err))
+		if err != nil {
+			SendPlain(msg.ChatId, fmt.Sprintf("创建定时消息失败: %v", err))
+			return
+		}
+		SendPlain(msg.ChatId, fmt.Sprintf("定时消息 #%d 将于 %s 发送给 %d", id, at.Format("2006-01-02 15:04 MST"), item.ChatID))
+	case "/log":
+		// 日志中有所有用户的消息和内部信息，只有 superadmin 可以查看
+		if !allowed(msg.FromID, auditLog, 0) {
+			SendPlain(msg.ChatId, permissionDenied)
+			return
+		}
+		n := defaultLogTailLines
+		if len(args) > 0 {
+			if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
+				n = v
+			}
+		}
+		if n > maxLogTailLines {
+			n = maxLogTailLines
+		}
+		lines, err := tailFile(logPath(), n)
+		if err != nil {
+			SendPlain(msg.ChatId, fmt.Sprintf("读取日志失败: %v", err))
+			return
+		}
+		SendPlain(msg.ChatId, redactSecrets(strings.Join(lines, "\n")))
+	}
+	logDebugf("管理员执行命令 %s %v", cmd, args)
+}
+
+// formatUserCounts 统计各类用户数量
+func formatUserCounts() string {
+	var total, subscribed, optout, inactive, banned int
+	db.View(func(tx *bolt.Tx) error {
+		total = countKeys(tx.Bucket(usersBucket))
+		subscribed = countKeys(tx.Bucket(subscribedBucket))
+		optout = countKeys(tx.Bucket(optoutBucket))
+		inactive = countKeys(tx.Bucket(inactiveBucket))
+		banned = countKeys(tx.Bucket(bannedBucket))
+		return nil
+	})
+	return fmt.Sprintf("用户总数: %d\n订阅广播: %d\n退订广播: %d\n已屏蔽机器人: %d\n已拉黑: %d", total, subscribed, optout, inactive, banned)
+}
+
+// countKeys 统计 bucket 中的键数量
+func countKeys(b *bolt.Bucket) int {
+	n := 0
+	b.ForEach(func(k, v []byte) error {
+		n++
+		return nil
+	})
+	return n
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// testOperator 测试中 operator 角色的管理员ID
+const testOperator = 1001
+
+func TestOperatorDeniedSuperadminCommands(t *testing.T) {
+	for _, text := range []string{"/log", "/log 10", "/pin 3300 5", "/unpin 3300"} {
+		t.Run(text, func(t *testing.T) {
+			setupTestDB(t)
+			tg := newFakeTelegram(t)
+			getConfig().Admins = []AdminConfig{{ID: testOperator, Role: roleOperator}}
+			if err := os.WriteFile(logPath(), []byte("secret log line\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			commander(privateMsg(testOperator, 1, text))
+
+			if got := tg.sentTo(testOperator); len(got) != 1 || got[0] != permissionDenied {
+				t.Errorf("operator got %q, want permission denied", got)
+			}
+			for _, method := range []string{"pinChatMessage", "unpinChatMessage", "unpinAllChatMessages"} {
+				if got := tg.callsTo(method); len(got) != 0 {
+					t.Errorf("%s called for an operator: %v", method, got)
+				}
+			}
+			entries := auditEntries(0, 10)
+			if len(entries) != 1 || entries[0].Action != auditDenied || entries[0].Actor != testOperator {
+				t.Errorf("audit = %+v, want one denied entry", entries)
+			}
+		})
+	}
+}
+
+func TestOwnerCanReadLog(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	getConfig().Admins = []AdminConfig{{ID: testOperator, Role: roleOperator}}
+	if err := os.WriteFile(logPath(), []byte("line one\nline two\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	commander(privateMsg(testOwner, 1, "/log"))
+
+	if got := tg.sentTo(testOwner); len(got) != 1 || got[0] != "line one\nline two" {
+		t.Errorf("owner got %q, want the log tail", got)
+	}
+}
+
+func TestSendCommandsAudited(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		run    func()
+		action string
+		chatID int64
+	}{
+		{"/photourl", func() { commander(privateMsg(testOperator, 1, "/photourl 3290 https://example.com/a.jpg")) }, auditReply, 3290},
+		{"/docurl", func() { commander(privateMsg(testOperator, 1, "/docurl 3290 https://example.com/a.pdf")) }, auditReply, 3290},
+		{"/pin", func() { commander(privateMsg(testOwner, 1, "/pin 3291 5")) }, auditPin, 3291},
+		{"/unpin", func() { commander(privateMsg(testOwner, 1, "/unpin 3291")) }, auditUnpin, 3291},
+		{"/at", func() { commander(privateMsg(testOperator, 1, "/at +30m 3292 hello")) }, auditReply, 3292},
+		{"cli at", func() { doCommand("at +30m 3292 hello") }, auditReply, 3292},
+		{"cli schedule cancel", func() {
+			id, err := scheduleMessage(time.Now().Add(time.Hour), outboxItem{ChatID: 3292, Kind: outboxText, Text: "hello"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			doCommand(fmt.Sprintf("schedule cancel %d", id))
+		}, auditDelete, 3292},
+		{"cli photourl", func() { doCommand("photourl 3293 https://example.com/a.jpg") }, auditReply, 3293},
+		{"cli pin", func() { doCommand("pin 3293 5") }, auditPin, 3293},
+		{"cli draft resend", func() {
+			if err := saveDraft(3294, "draft text"); err != nil {
+				t.Fatal(err)
+			}
+			doCommand("draft resend 3294")
+		}, auditReply, 3294},
+		{"quick action template", func() {
+			if err := saveTemplate(Template{Name: "promo", Text: "hi"}); err != nil {
+				t.Fatal(err)
+			}
+			handleQuickAction(&tgbotapi.CallbackQuery{ID: "1", From: &tgbotapi.User{ID: testOwner}, Data: quickActionPrefix + "template:3295:promo"})
+		}, auditReply, 3295},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupTestDB(t)
+			newFakeTelegram(t)
+			getConfig().Admins = []AdminConfig{{ID: testOperator, Role: roleOperator}}
+
+			tc.run()
+
+			entries := auditEntries(0, 10)
+			if len(entries) != 1 || entries[0].Action != tc.action || entries[0].ChatID != tc.chatID {
+				t.Errorf("audit = %+v, want one %s entry for %d", entries, tc.action, tc.chatID)
+			}
+		})
+	}
+}
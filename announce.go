@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// announcementsBucket 记录每条定期公告最后一次发送的时间，键为公告名称，避免重启后同一分钟重复发送
+var announcementsBucket = []byte("announcements")
+
+// AnnouncementConfig 配置中的一条定期公告，按 cron 表达式向所有未退订的用户群发
+type AnnouncementConfig struct {
+	Name string `yaml:"name"` // 公告名称，用于记录发送状态
+	Cron string `yaml:"cron"` // 5 段 cron 表达式（分 时 日 月 周），按配置的 timezone 计算
+	Text string `yaml:"text"` // 公告内容，按 parse_mode 发送
+
+	schedule *cronSchedule
+}
+
+// compileAnnouncements 检查并解析定期公告的 cron 表达式
+func compileAnnouncements(list []AnnouncementConfig) error {
+	names := make(map[string]bool)
+	for i := range list {
+		a := &list[i]
+		if a.Name == "" {
+			return fmt.Errorf("第 %d 条定期公告缺少 name", i+1)
+		}
+		if names[a.Name] {
+			return fmt.Errorf("定期公告 %s 重复", a.Name)
+		}
+		names[a.Name] = true
+		if a.Text == "" {
+			return fmt.Errorf("定期公告 %s 缺少 text", a.Name)
+		}
+		s, err := parseCron(a.Cron)
+		if err != nil {
+			return fmt.Errorf("定期公告 %s: %v", a.Name, err)
+		}
+		a.schedule = s
+	}
+	return nil
+}
+
+// claimAnnouncement 在发送前记录本次发送的分钟，该分钟已发送过时返回 false
+// 先记录再发送，群发中途重启不会从头重发
+func claimAnnouncement(name string, minute time.Time) (bool, error) {
+	claimed := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(announcementsBucket)
+		last, _ := strconv.ParseInt(string(b.Get([]byte(name))), 10, 64)
+		if last >= minute.Unix() {
+			return nil
+		}
+		claimed = true
+		return b.Put([]byte(name), []byte(strconv.FormatInt(minute.Unix(), 10)))
+	})
+	return claimed, err
+}
+
+// runAnnouncements 发送所有在这一分钟到期的定期公告
+func runAnnouncements(now time.Time) {
+	minute := now.In(botLocation()).Truncate(time.Minute)
+	for _, a := range getConfig().Announcements {
+		if a.schedule == nil || !a.schedule.matches(minute) {
+			continue
+		}
+		ok, err := claimAnnouncement(a.Name, minute)
+		if err != nil {
+			logErrorf("记录定期公告 %s 的发送状态失败: %v", a.Name, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		logInfof("发送定期公告 %s", a.Name)
+		go broadcast(a.Text)
+	}
+}
+
+// announcementLoop 每分钟检查一次定期公告，停机期间错过的公告不会补发
+func announcementLoop() {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute + time.Second).Sub(now))
+		if getBot() == nil || len(getConfig().Announcements) == 0 {
+			continue
+		}
+		runAnnouncements(time.Now())
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// approvedBucket 存储管理员批准过的用户，值为批准时间（unix 秒）
+var approvedBucket = []byte("approved")
+
+// pendingBucket 存储等待批准的用户及暂缓转发的消息，键为聊天ID
+var pendingBucket = []byte("pending")
+
+// approvalPrefix 批准按钮回调数据的前缀，格式为 approval:<approve|ignore>:<chatid>
+const approvalPrefix = "approval:"
+
+// PendingRecord 等待批准的用户和暂缓转发的消息
+type PendingRecord struct {
+	ChatID int64       `json:"chat_id"`
+	Since  int64       `json:"since"`           // 第一条消息被暂缓的时间（unix 秒）
+	First  bool        `json:"first,omitempty"` // 第一条暂缓的消息是否为用户的第一条消息，批准后按新用户检查反垃圾规则
+	Held   []SimpleMsg `json:"held"`            // 暂缓转发的消息，批准后按顺序转发
+}
+
+// loadPending 读取等待批准的记录，不存在时返回 nil
+func loadPending(tx *bolt.Tx, chatID int64) *PendingRecord {
+	v := tx.Bucket(pendingBucket).Get([]byte(strconv.FormatInt(chatID, 10)))
+	if v == nil {
+		return nil
+	}
+	var rec PendingRecord
+	if json.Unmarshal(v, &rec) != nil {
+		return nil
+	}
+	return &rec
+}
+
+// putPending 保存等待批准的记录
+func putPending(tx *bolt.Tx, rec *PendingRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(pendingBucket).Put([]byte(strconv.FormatInt(rec.ChatID, 10)), data)
+}
+
+// holdUnapproved 开启 approval 时暂缓转发未批准用户的消息，返回 true 表示消息已被暂缓
+// 用户的第一条暂缓消息会通知管理员批准，之后的消息只加入队列；isNew 表示这是用户的第一条消息
+func holdUnapproved(msg SimpleMsg, isNew bool) bool {
+	if !getConfig().Approval.Enabled {
+		return false
+	}
+	var held, notify bool
+	var count int
+	err := db.Update(func(tx *bolt.Tx) error {
+		key := []byte(strconv.FormatInt(msg.ChatId, 10))
+		if tx.Bucket(approvedBucket).Get(key) != nil {
+			return nil
+		}
+		rec := loadPending(tx, msg.ChatId)
+		if rec == nil {
+			rec = &PendingRecord{ChatID: msg.ChatId, Since: time.Now().Unix(), First: isNew}
+			notify = true
+		}
+		held = true
+		rec.Held = append(rec.Held, msg)
+		count = len(rec.Held)
+		return putPending(tx, rec)
+	})
+	if err != nil {
+		logWith(msg.ChatId, msg.MessageID).Errorf("保存 %d 的待批准消息失败: %v", msg.ChatId, err)
+		return false
+	}
+	if !held {
+		return false
+	}
+	logWith(msg.ChatId, msg.MessageID).Infof("用户 %d 尚未批准, 暂缓转发第 %d 条消息", msg.ChatId, count)
+	if notify {
+		notifyPending(msg)
+	}
+	return true
+}
+
+// notifyPending 通知管理员有新用户等待批准，附带批准和忽略按钮
+func notifyPending(msg SimpleMsg) {
+	text := fmt.Sprintf("新用户等待批准，消息已暂缓转发\n用户: %s @%s (%d)\n内容: %s", strings.TrimSpace(msg.Name), msg.UserName, msg.ChatId, msgInfo(msg))
+	notice := tgbotapi.NewMessage(getConfig().Account.Owner, text)
+	notice.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("批准", fmt.Sprintf("%sapprove:%d", approvalPrefix, msg.ChatId)),
+		tgbotapi.NewInlineKeyboardButtonData("忽略", fmt.Sprintf("%signore:%d", approvalPrefix, msg.ChatId)),
+	))
+	notice.DisableWebPagePreview = true
+	if _, err := sendWithRetry(notice); err != nil {
+		logErrorf("通知管理员批准 %d 失败: %v", msg.ChatId, err)
+	}
+}
+
+// approveUser 批准用户并按顺序处理暂缓的消息，之后该用户的消息直接转发
+// 暂缓的消息与平时一样经过反垃圾、自动回复、工单推送等检查，没有暂缓消息的用户同样可以批准，返回处理的消息数
+func approveUser(chatID int64) (int, error) {
+	var held []SimpleMsg
+	first := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		key := []byte(strconv.FormatInt(chatID, 10))
+		if rec := loadPending(tx, chatID); rec != nil {
+			held, first = rec.Held, rec.First
+		}
+		if err := tx.Bucket(pendingBucket).Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(approvedBucket).Put(key, []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	// 已经写入批准记录，relayIncomingMsg 不会再次暂缓这些消息
+	// 批准由管理员的回调或命令行触发，暂缓的消息交给该用户的 worker 处理，与用户新发来的消息保持顺序
+	runOnWorker(chatID, "处理批准前暂缓的消息", func() {
+		for i, msg := range held {
+			relayIncomingMsg(msg, first && i == 0)
+		}
+	})
+	logInfof("批准用户 %d, 处理 %d 条暂缓的消息", chatID, len(held))
+	return len(held), nil
+}
+
+// ignorePending 丢弃用户暂缓的消息，用户仍未批准，再次发来消息时重新通知管理员
+func ignorePending(chatID int64) (int, error) {
+	var n int
+	err := db.Update(func(tx *bolt.Tx) error {
+		rec := loadPending(tx, chatID)
+		if rec == nil {
+			return fmt.Errorf("用户 %d 没有等待批准的消息", chatID)
+		}
+		n = len(rec.Held)
+		return tx.Bucket(pendingBucket).Delete([]byte(strconv.FormatInt(chatID, 10)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	logInfof("忽略用户 %d 的 %d 条待批准消息", chatID, n)
+	return n, nil
+}
+
+// pendingUsers 返回所有等待批准的用户，用于命令行查看
+func pendingUsers() []PendingRecord {
+	var records []PendingRecord
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(k, v []byte) error {
+			var rec PendingRecord
+			if json.Unmarshal(v, &rec) == nil {
+				records = append(records, rec)
+			}
+			return nil
+		})
+	})
+	return records
+}
+
+// handleApprovalCallback 处理管理员点击的批准或忽略按钮，结果以回调提示的形式显示
+func handleApprovalCallback(callback *tgbotapi.CallbackQuery) {
+	var result string
+	parts := strings.SplitN(strings.TrimPrefix(callback.Data, approvalPrefix), ":", 2)
+	var chatID int64
+	var err error
+	if len(parts) == 2 {
+		chatID, err = strconv.ParseInt(parts[1], 10, 64)
+	}
+	switch {
+	case callback.From == nil || !isAdmin(callback.From.ID):
+		result = "该操作仅限管理员使用"
+	case len(parts) != 2 || err != nil:
+		result = "无效的操作"
+	case parts[0] == "approve":
+		if n, err := approveUser(chatID); err != nil {
+			result = fmt.Sprintf("批准失败: %v", err)
+		} else {
+			result = fmt.Sprintf("已批准 %d, 处理 %d 条暂缓的消息", chatID, n)
+		}
+	case parts[0] == "ignore":
+		if n, err := ignorePending(chatID); err != nil {
+			result = err.Error()
+		} else {
+			result = fmt.Sprintf("已忽略 %d 的 %d 条消息", chatID, n)
+		}
+	default:
+		result = "未知的操作: " + parts[0]
+	}
+	if _, err := getBot().Request(tgbotapi.NewCallback(callback.ID, result)); err != nil {
+		logErrorf("处理回调请求失败: %v", err)
+	}
+}
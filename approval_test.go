@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// drainTicketing 取出推送队列中的所有消息
+func drainTicketing() []ticketingPayload {
+	var payloads []ticketingPayload
+	for {
+		select {
+		case p := <-ticketingQueue:
+			payloads = append(payloads, p)
+		default:
+			return payloads
+		}
+	}
+}
+
+func TestApprovalReleasesThroughPipeline(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	getConfig().Approval.Enabled = true
+	getConfig().Ticketing.URL = "http://ticketing.invalid/hook"
+	getConfig().AutoReply = []AutoReplyRule{{Keywords: []string{"price"}, Reply: "see the price list", Forward: true}}
+	if err := compileAutoReplies(getConfig().AutoReply); err != nil {
+		t.Fatal(err)
+	}
+	drainTicketing()
+
+	deliverIncomingMsg(privateMsg(51, 1, "what is the price?"))
+	if got := tg.callsTo("forwardMessage"); len(got) != 0 {
+		t.Fatalf("unapproved message was forwarded: %v", got)
+	}
+	if got := tg.sentTo(51); len(got) != 0 {
+		t.Fatalf("unapproved user got replies %q", got)
+	}
+	if got := drainTicketing(); len(got) != 0 {
+		t.Fatalf("unapproved message was pushed to ticketing: %v", got)
+	}
+
+	n, err := approveUser(51)
+	if err != nil || n != 1 {
+		t.Fatalf("approveUser = %d, %v, want 1, nil", n, err)
+	}
+	forwards := tg.callsTo("forwardMessage")
+	if len(forwards) != 1 || forwards[0].Params.Get("from_chat_id") != "51" || forwards[0].Params.Get("chat_id") != strconv.Itoa(testOwner) {
+		t.Errorf("forwards after approval = %v, want one from 51 to the owner", forwards)
+	}
+	if got := tg.sentTo(51); len(got) != 1 || got[0] != "see the price list" {
+		t.Errorf("auto-replies after approval = %q", got)
+	}
+	pushed := drainTicketing()
+	if len(pushed) != 1 || pushed[0].ChatID != 51 || pushed[0].Event != ticketingNew {
+		t.Errorf("ticketing pushes after approval = %+v, want one new event for 51", pushed)
+	}
+
+	// 批准后的消息直接转发
+	tg.reset()
+	deliverIncomingMsg(privateMsg(51, 2, "thanks"))
+	if got := tg.callsTo("forwardMessage"); len(got) != 1 {
+		t.Errorf("message after approval forwarded %d times, want 1", len(got))
+	}
+	if len(pendingUsers()) != 0 {
+		t.Errorf("pending users after approval: %v", pendingUsers())
+	}
+}
+
+func TestApprovalIgnoreDropsHeld(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	getConfig().Approval.Enabled = true
+
+	deliverIncomingMsg(privateMsg(52, 1, "hello"))
+	deliverIncomingMsg(privateMsg(52, 2, "anyone?"))
+	if n, err := ignorePending(52); err != nil || n != 2 {
+		t.Fatalf("ignorePending = %d, %v, want 2, nil", n, err)
+	}
+	if n, err := approveUser(52); err != nil || n != 0 {
+		t.Fatalf("approveUser after ignore = %d, %v, want 0, nil", n, err)
+	}
+	if got := tg.callsTo("forwardMessage"); len(got) != 0 {
+		t.Errorf("ignored messages were forwarded: %v", got)
+	}
+}
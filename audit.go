@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// auditBucket 按时间顺序存储管理员操作记录，键为递增序号
+var auditBucket = []byte("audit")
+
+// auditFile 审计日志文件，放在 data_dir 下，每行一条记录，不参与日志轮转
+const auditFile = "audit.log"
+
+// actorCLI 命令行执行的操作没有 Telegram ID，记为 0
+const actorCLI = 0
+
+// 审计记录的操作类型
+const (
+	auditReply     = "reply"
+	auditEdit      = "edit"
+	auditBan       = "ban"
+	auditUnban     = "unban"
+	auditBroadcast = "broadcast"
+	auditDelete    = "delete"
+	auditPin       = "pin"
+	auditUnpin     = "unpin"
+	auditLog       = "log"    // 查看日志，只用于权限检查，不单独记录
+	auditDenied    = "denied" // 管理员尝试执行没有权限的操作，详情为被拒绝的操作
+)
+
+// AuditEntry 一条管理员操作记录
+type AuditEntry struct {
+	Time   int64  `json:"time"`             // 操作时间（unix 秒）
+	Actor  int64  `json:"actor"`            // 执行操作的管理员 Telegram ID，0 表示命令行
+	Action string `json:"action"`           // reply、edit、ban、unban、broadcast、delete、denied 等
+	ChatID int64  `json:"chat_id"`          // 受影响的用户，广播为 0
+	Detail string `json:"detail,omitempty"` // 回复内容、发送结果等
+}
+
+// actorName 返回记录中显示的操作者
+func actorName(actor int64) string {
+	if actor == actorCLI {
+		return "cli"
+	}
+	return fmt.Sprintf("%d", actor)
+}
+
+// recordAudit 记录一次管理员操作，同时写入数据库和审计日志文件，失败只记录日志，不影响操作本身
+func recordAudit(actor int64, action string, chatID int64, detail string) {
+	e := AuditEntry{Time: time.Now().Unix(), Actor: actor, Action: action, ChatID: chatID, Detail: detail}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(outboxKey(id), data)
+	})
+	if err != nil {
+		logErrorf("保存审计记录失败: %v", err)
+	}
+	f, err := os.OpenFile(dataPath(auditFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		logErrorf("打开审计日志失败: %v", err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, formatAudit(e))
+}
+
+// auditEntries 返回与某个用户相关的操作记录，chatID 为 0 时返回所有记录，最多返回最近的 limit 条
+func auditEntries(chatID int64, limit int) []AuditEntry {
+	var entries []AuditEntry
+	db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(entries) < limit; k, v = c.Prev() {
+			var e AuditEntry
+			if len(k) != 8 || json.Unmarshal(v, &e) != nil {
+				continue
+			}
+			if chatID == 0 || e.ChatID == chatID {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	// 按时间从早到晚显示
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// formatAudit 生成审计日志和命令行中的一行
+func formatAudit(e AuditEntry) string {
+	line := fmt.Sprintf("%s actor=%s action=%s", time.Unix(e.Time, 0).Format("2006-01-02 15:04:05"), actorName(e.Actor), e.Action)
+	if e.ChatID != 0 {
+		line += fmt.Sprintf(" chat=%d", e.ChatID)
+	}
+	if e.Detail != "" {
+		line += " " + e.Detail
+	}
+	return line
+}
+
+// auditResult 把发送结果附在审计内容后面
+func auditResult(detail string, err error) string {
+	if err != nil {
+		return fmt.Sprintf("%s (failed: %v)", detail, err)
+	}
+	return detail
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AutoReplyRule 定义一条关键词自动回复规则
+type AutoReplyRule struct {
+	Keywords []string          `yaml:"keywords"` // 关键词列表，命中任意一个即触发（不区分大小写）
+	Regex    string            `yaml:"regex"`    // 可选的正则表达式（不区分大小写）
+	Reply    string            `yaml:"reply"`    // 自动回复的内容
+	Replies  map[string]string `yaml:"replies"`  // 可选的按语言代码（zh、en、ru 等）区分的回复，没有对应语言时使用 reply
+	Forward  bool              `yaml:"forward"`  // 命中后是否仍然转发给管理员
+
+	re *regexp.Regexp
+}
+
+// compileAutoReplies 预编译自动回复规则中的正则表达式
+func compileAutoReplies(rules []AutoReplyRule) error {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Reply == "" {
+			return fmt.Errorf("第 %d 条自动回复规则缺少 reply", i+1)
+		}
+		if rule.Regex == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + rule.Regex)
+		if err != nil {
+			return fmt.Errorf("第 %d 条自动回复规则的正则表达式无效: %v", i+1, err)
+		}
+		rule.re = re
+	}
+	return nil
+}
+
+// replyFor 返回指定语言的回复内容
+func (rule *AutoReplyRule) replyFor(lang string) string {
+	if reply := rule.Replies[normalizeLanguage(lang)]; reply != "" {
+		return reply
+	}
+	return rule.Reply
+}
+
+// matchAutoReply 查找第一条与文本匹配的自动回复规则，未命中返回 nil
+func matchAutoReply(text string) *AutoReplyRule {
+	if text == "" {
+		return nil
+	}
+	lower := strings.ToLower(text)
+	cfg := getConfig()
+	for i := range cfg.AutoReply {
+		rule := &cfg.AutoReply[i]
+		for _, kw := range rule.Keywords {
+			if kw != "" && strings.Contains(lower, strings.ToLower(kw)) {
+				return rule
+			}
+		}
+		if rule.re != nil && rule.re.MatchString(text) {
+			return rule
+		}
+	}
+	return nil
+}
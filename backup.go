@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// shutdownBackupPath 收到 SIGTERM 退出前自动备份的数据库文件
+func shutdownBackupPath() string {
+	return dbPath() + ".bak"
+}
+
+// backupDB 使用只读事务把整个数据库一致地写入 path，返回写入的字节数
+// 先写临时文件再改名，备份过程中出错不会破坏已有的备份
+func backupDB(path string) (int64, error) {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("创建备份文件失败: %v", err)
+	}
+	var size int64
+	err = db.View(func(tx *bolt.Tx) error {
+		size, err = tx.WriteTo(file)
+		return err
+	})
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("备份数据库失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("备份数据库失败: %v", err)
+	}
+	logInfof("数据库已备份到 %s, %d 字节", path, size)
+	return size, nil
+}
+
+// defaultBackupPath 手动备份的默认文件名
+func defaultBackupPath() string {
+	return fmt.Sprintf("%s.%s.bak", dbPath(), time.Now().Format("20060102-150405"))
+}
+
+// backupInterval 返回定期备份的间隔
+func backupInterval() time.Duration {
+	if getConfig().Backup.Interval <= 0 {
+		return time.Hour
+	}
+	return time.Duration(getConfig().Backup.Interval) * time.Minute
+}
+
+// backupLoop 按配置的间隔把数据库备份到带时间戳的文件，只保留最近的若干份
+func backupLoop() {
+	for {
+		time.Sleep(backupInterval())
+		if !getConfig().Backup.Enabled {
+			continue
+		}
+		dir := getConfig().Backup.Dir
+		if dir == "" {
+			dir = "backups"
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			logErrorf("创建备份目录失败: %v", err)
+			continue
+		}
+		path := filepath.Join(dir, fmt.Sprintf("bot-%s.db", time.Now().Format("20060102-150405")))
+		if _, err := backupDB(path); err != nil {
+			logErrorf("%v", err)
+			continue
+		}
+		pruneBackups(dir, getConfig().Backup.Keep)
+	}
+}
+
+// pruneBackups 删除多余的定期备份，只保留最新的 keep 份（默认 24）
+func pruneBackups(dir string, keep int) {
+	if keep <= 0 {
+		keep = 24
+	}
+	// 文件名中的时间戳可以直接按字符串排序
+	files, err := filepath.Glob(filepath.Join(dir, "bot-*.db"))
+	if err != nil || len(files) <= keep {
+		return
+	}
+	sort.Strings(files)
+	for _, f := range files[:len(files)-keep] {
+		if err := os.Remove(f); err != nil {
+			logErrorf("删除旧备份 %s 失败: %v", f, err)
+			continue
+		}
+		logInfof("删除旧备份 %s", f)
+	}
+}
+
+// snapshotMappings 读取消息ID映射，每条消息只保留最终对应的用户
+func snapshotMappings() map[int]int64 {
+	m := make(map[int]int64)
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketname).ForEach(func(k, v []byte) error {
+			msgid, err := strconv.Atoi(string(k))
+			if err != nil {
+				return nil
+			}
+			if chatID := resolveMapping(msgid, parseMapping(v)); chatID != 0 {
+				m[msgid] = chatID
+			}
+			return nil
+		})
+	})
+	return m
+}
+
+// restoreMappings 将备份的映射合并回数据库，已有的映射保持不变
+// 恢复的记录时间为 0，与已有记录冲突时以已有的为准
+func restoreMappings(m map[int]int64) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for msgid, chatID := range m {
+			if err := putMappingAt(tx, msgid, mappingEntry{ChatID: chatID}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	syncMappings()
+	return nil
+}
+
+// SaveMapToDisk 保存消息ID映射关系到磁盘
+func SaveMapToDisk(m map[int]int64) error {
+	file, err := os.Create(mapPath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := gob.NewEncoder(file)
+	err = encoder.Encode(m)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LoadMapFromDisk 从磁盘加载消息ID映射关系
+func LoadMapFromDisk() (map[int]int64, error) {
+	file, err := os.Open(mapPath())
+	if err != nil {
+		return make(map[int]int64), err
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(file)
+	var m map[int]int64
+	err = decoder.Decode(&m)
+	if err != nil {
+		return make(map[int]int64), err
+	}
+	return m, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// bannedBucket 存储被拉黑的用户
+var bannedBucket = []byte("banned")
+
+// defaultBanNotice 被拉黑用户收到的默认提示
+const defaultBanNotice = "您已被限制，无法联系客服"
+
+// banNoticeMu 保护 banNoticeSent
+var banNoticeMu sync.Mutex
+
+// banNoticeSent 记录每个被拉黑用户最后一次收到提示的时间
+var banNoticeSent = make(map[int64]time.Time)
+
+// banUser 拉黑用户，之后该用户的消息不会再转发给管理员
+func banUser(chatID int64) error {
+	if err := store.SetBanned(chatID, true); err != nil {
+		return err
+	}
+	logInfof("拉黑用户 %d", chatID)
+	return nil
+}
+
+// unbanUser 解除拉黑
+func unbanUser(chatID int64) error {
+	if err := store.SetBanned(chatID, false); err != nil {
+		return err
+	}
+	logInfof("解除拉黑用户 %d", chatID)
+	return nil
+}
+
+// isBanned 判断用户是否被拉黑
+func isBanned(chatID int64) bool {
+	banned, err := store.IsBanned(chatID)
+	if err != nil {
+		logWith(chatID, 0).Errorf("读取 %d 的拉黑状态失败: %v", chatID, err)
+	}
+	return banned
+}
+
+// noticeBannedUser 按配置提示被拉黑的用户，同一用户在间隔内只提示一次
+func noticeBannedUser(chatID int64) {
+	if !getConfig().Ban.Notify {
+		return
+	}
+	interval := time.Duration(getConfig().Ban.NoticeInterval) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	banNoticeMu.Lock()
+	last, ok := banNoticeSent[chatID]
+	if ok && time.Since(last) < interval {
+		banNoticeMu.Unlock()
+		return
+	}
+	banNoticeSent[chatID] = time.Now()
+	banNoticeMu.Unlock()
+
+	notice := getConfig().Ban.Notice
+	if notice == "" {
+		notice = defaultBanNotice
+	}
+	SendMsg(chatID, notice)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// resetBanNotices 清空提示记录，go test -count 多次运行时互不影响
+func resetBanNotices() {
+	banNoticeMu.Lock()
+	defer banNoticeMu.Unlock()
+	banNoticeSent = make(map[int64]time.Time)
+}
+
+// backdateBanNotice 把用户上次收到提示的时间提前 d
+func backdateBanNotice(chatID int64, d time.Duration) {
+	banNoticeMu.Lock()
+	defer banNoticeMu.Unlock()
+	banNoticeSent[chatID] = banNoticeSent[chatID].Add(-d)
+}
+
+func TestBannedUserSilent(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	if err := banUser(2810); err != nil {
+		t.Fatal(err)
+	}
+
+	deliverIncomingMsg(privateMsg(2810, 1, "hello"))
+	deliverIncomingMsg(privateMsg(2810, 2, "hello?"))
+
+	if got := tg.sentTo(2810); len(got) != 0 {
+		t.Errorf("silent ban replied %q", got)
+	}
+	if got := tg.callsTo("forwardMessage"); len(got) != 0 {
+		t.Errorf("banned user's message was forwarded: %v", got)
+	}
+}
+
+func TestBannedUserNotice(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	resetBanNotices()
+	getConfig().Ban.Notify = true
+	getConfig().Ban.NoticeInterval = 10
+	if err := banUser(2811); err != nil {
+		t.Fatal(err)
+	}
+
+	deliverIncomingMsg(privateMsg(2811, 1, "hello"))
+	deliverIncomingMsg(privateMsg(2811, 2, "hello?"))
+	if got := tg.sentTo(2811); len(got) != 1 || got[0] != defaultBanNotice {
+		t.Fatalf("notices = %q, want one default notice", got)
+	}
+
+	// 间隔内不再提示，超过间隔后再次提示
+	backdateBanNotice(2811, 9*time.Minute)
+	deliverIncomingMsg(privateMsg(2811, 3, "still there?"))
+	if got := tg.sentTo(2811); len(got) != 1 {
+		t.Fatalf("notice repeated within the interval: %q", got)
+	}
+	backdateBanNotice(2811, 2*time.Minute)
+	getConfig().Ban.Notice = "blocked"
+	deliverIncomingMsg(privateMsg(2811, 4, "anyone?"))
+	if got := tg.sentTo(2811); len(got) != 2 || got[1] != "blocked" {
+		t.Errorf("notices = %q, want a second custom notice after the interval", got)
+	}
+	if got := tg.callsTo("forwardMessage"); len(got) != 0 {
+		t.Errorf("banned user's message was forwarded: %v", got)
+	}
+}
+
+func TestBannedUserNoticeDefaultInterval(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	resetBanNotices()
+	getConfig().Ban.Notify = true
+
+	noticeBannedUser(2812)
+	backdateBanNotice(2812, 59*time.Minute)
+	noticeBannedUser(2812)
+	if got := tg.sentTo(2812); len(got) != 1 {
+		t.Fatalf("notices = %q, want 1 within the default hour", got)
+	}
+	backdateBanNotice(2812, 2*time.Minute)
+	noticeBannedUser(2812)
+	if got := tg.sentTo(2812); len(got) != 2 {
+		t.Errorf("notices = %q, want 2 after the default hour", got)
+	}
+}
+
+func TestBannedUserCommandsIgnored(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	resetBanNotices()
+	getConfig().Ban.Notify = true
+	if err := banUser(2812); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, cmd := range []string{"/start", "/help", "/lang en", "/unsubscribe"} {
+		handleUpdate(userUpdate(278200+i, 2812, i+1, cmd))
+	}
+	if got := tg.sentTo(2812); len(got) != 0 {
+		t.Errorf("banned user's commands were answered: %q", got)
+	}
+	if got := tg.sentTo(testOwner); len(got) != 0 {
+		t.Errorf("banned user's commands reached the owner: %q", got)
+	}
+}
+
+func TestBannedUserEditIgnored(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	deliverIncomingMsg(privateMsg(2813, 1, "hello"))
+	if got := tg.callsTo("forwardMessage"); len(got) != 1 {
+		t.Fatalf("forwarded %d messages, want 1", len(got))
+	}
+	if err := banUser(2813); err != nil {
+		t.Fatal(err)
+	}
+	tg.reset()
+
+	update := userUpdate(278300, 2813, 1, "hello, edited")
+	update.EditedMessage, update.Message = update.Message, nil
+	handleUpdate(update)
+	if got := tg.sentTo(testOwner); len(got) != 0 {
+		t.Errorf("banned user's edit was relayed: %q", got)
+	}
+}
@@ -1,17 +1,22 @@
 package main
 
 import (
-	"bufio"
-	"encoding/gob"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -19,77 +24,249 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// 日志轮转的默认值
 const (
-	maxLogSize    = 10 * 1024 * 1024 // 10MB
-	maxLogBackups = 5
+	defaultLogMaxSize    = 10 // MB
+	defaultLogMaxBackups = 5
 )
 
-// filename 存储机器人配置文件的名称
-var filename = "bot.map"
-
 // Config 存储机器人的配置信息
 type Config struct {
 	Account struct {
-		Mode     string `yaml:"mode"`     // 工作模式：polling 或 webhook
-		Token    string `yaml:"token"`    // Telegram Bot Token
-		Owner    int64  `yaml:"owner"`    // 管理员的 Telegram ID
-		Endpoint string `yaml:"endpoint"` // webhook 模式的回调地址
-		Port     int    `yaml:"port"`     // webhook 模式的端口
+		Mode               string `yaml:"mode"`                 // 工作模式：polling 或 webhook
+		Token              string `yaml:"token"`                // Telegram Bot Token
+		Owner              int64  `yaml:"owner"`                // 管理员的 Telegram ID
+		Endpoint           string `yaml:"endpoint"`             // webhook 模式的回调地址
+		Port               int    `yaml:"port"`                 // webhook 模式的端口
+		WebhookPath        string `yaml:"webhook_path"`         // webhook 服务监听的路径，留空时使用 endpoint 中的路径
+		DropPendingUpdates bool   `yaml:"drop_pending_updates"` // 启动时丢弃停机期间积压的更新，不再转发过时的消息
+		WebhookFallback    bool   `yaml:"webhook_fallback"`     // webhook 设置失败或持续出错时自动改用 polling 模式
+		SecretToken        string `yaml:"secret_token"`         // webhook 的 secret_token，不带该请求头或内容不一致的 webhook 请求返回 403，留空不校验
 	} `yaml:"account"`
+	WebhookAllowlist struct {
+		Enabled        bool     `yaml:"enabled"`         // 只接受来自 Telegram 地址段（149.154.160.0/20、91.108.4.0/22）的 webhook 请求
+		TrustedProxies []string `yaml:"trusted_proxies"` // 可信的反向代理地址或地址段，来自这些地址的请求按 X-Forwarded-For 判断来源
+	} `yaml:"webhook_allowlist"`
+	Admins         []AdminConfig `yaml:"admins"`          // owner 以外的管理员及其角色，owner 始终为 superadmin
+	AllowedUpdates []string      `yaml:"allowed_updates"` // 向 Telegram 请求的更新类型，留空使用 message、edited_message、callback_query、message_reaction
+	Workers        int           `yaml:"workers"`         // 并行处理更新的 worker 数量，同一用户的消息始终按顺序处理，默认 4
+	Timezone       string        `yaml:"timezone"`        // 定时消息使用的时区，如 Asia/Shanghai，留空使用系统时区
+	RateLimit      struct {
+		Global float64 `yaml:"global"` // 全局每秒最多发送的消息数，默认 30
+	} `yaml:"rate_limit"`
+	Health struct {
+		Port int `yaml:"port"` // polling 模式下 /health 的端口，0 表示不启动；webhook 模式使用 webhook 端口
+	} `yaml:"health"`
+	Metrics struct {
+		Port int `yaml:"port"` // Prometheus metrics 服务端口，0 表示不启动
+	} `yaml:"metrics"`
+	RelayMode string          `yaml:"relay_mode"` // 转发方式：forward（显示转发来源）或 copy（复制消息，不显示来源）
+	ParseMode string          `yaml:"parse_mode"` // 回复、广播和自动回复的解析模式：留空为纯文本，可选 MarkdownV2 或 HTML
+	AutoReply []AutoReplyRule `yaml:"auto_reply"` // 关键词自动回复规则
+	Commands  struct {
+		Public []BotCommandConfig `yaml:"public"` // 所有用户的命令菜单，留空使用默认
+		Owner  []BotCommandConfig `yaml:"owner"`  // 管理员额外的命令菜单，留空使用默认
+	} `yaml:"commands"`
+	Ban struct {
+		Notify         bool   `yaml:"notify"`          // 被拉黑用户发消息时是否回复提示，false 为静默丢弃
+		Notice         string `yaml:"notice"`          // 提示内容，留空使用默认提示
+		NoticeInterval int    `yaml:"notice_interval"` // 同一用户两次提示的最小间隔（分钟），默认 60
+	} `yaml:"ban"`
+	Announcements []AnnouncementConfig `yaml:"announcements"` // 按 cron 表达式定期群发的公告
+	Broadcast     struct {
+		SilentForward bool   `yaml:"silent_forward"` // 广播进行中时静默转发用户消息给管理员（不响铃）
+		Audience      string `yaml:"audience"`       // 广播对象：all（所有未退订的用户，默认）或 subscribers（只发给 /subscribe 订阅的用户）
+	} `yaml:"broadcast"`
+	Conversation struct {
+		MaxActive   int    `yaml:"max_active"`   // 同时进行的最大对话数，0 表示不限制
+		IdleTimeout int    `yaml:"idle_timeout"` // 对话无消息多少分钟后视为空闲并释放名额，默认 30
+		BusyMessage string `yaml:"busy_message"` // 排队时回复用户的提示，留空使用默认提示
+	} `yaml:"conversation"`
+	Report struct {
+		Enabled bool   `yaml:"enabled"` // 是否每天向管理员发送前一天的统计日报
+		Time    string `yaml:"time"`    // 发送时间，格式 HH:MM，默认 09:00
+	} `yaml:"report"`
+	MediaBackup struct {
+		Dir string `yaml:"dir"` // 用户发来的媒体本地备份目录，留空表示不备份
+	} `yaml:"media_backup"`
+	Welcome struct {
+		OnFirstContact bool `yaml:"on_first_contact"` // 用户首次发消息（任意类型）时自动发送欢迎消息
+	} `yaml:"welcome"`
+	Mapping struct {
+		MaxAgeDays int `yaml:"max_age_days"` // 消息ID映射保留天数，超过后自动删除，默认 30
+	} `yaml:"mapping"`
+	QuoteReply struct {
+		Enabled    bool `yaml:"enabled"`      // 管理员回复转发的消息时，发给用户的回复引用用户的原消息
+		MaxAgeDays int  `yaml:"max_age_days"` // 超过多少天的原消息不再引用，直接发送，默认 7
+	} `yaml:"quote_reply"`
+	OutgoingFilter OutgoingFilterConfig `yaml:"outgoing_filter"` // 管理员回复中禁止出现的词
+	AntiSpam       struct {
+		Enabled           bool `yaml:"enabled"`             // 是否检查疑似垃圾消息，命中的消息暂缓转发并由管理员审核
+		MaxURLs           int  `yaml:"max_urls"`            // 单条消息最多允许的链接数，默认 3
+		RepeatCount       int  `yaml:"repeat_count"`        // 时间窗口内重复发送相同内容达到多少次视为刷屏，默认 3
+		RepeatWindow      int  `yaml:"repeat_window"`       // 检测重复的时间窗口（分钟），默认 10
+		FirstMessageLinks bool `yaml:"first_message_links"` // 用户第一条消息包含链接或 @ 提及时拦截
+	} `yaml:"anti_spam"`
+	Approval struct {
+		Enabled bool `yaml:"enabled"` // 未经管理员批准的用户的消息暂缓转发，管理员点击「批准」后才转发
+	} `yaml:"approval"`
+	Captcha struct {
+		Enabled bool   `yaml:"enabled"` // 首次联系的用户需要先通过人机验证，消息才会转发给管理员
+		Mode    string `yaml:"mode"`    // 验证方式：button（点击「我是真人」，默认）或 math（选出算式的结果）
+	} `yaml:"captcha"`
+	Outbox struct {
+		MaxAttempts int `yaml:"max_attempts"` // 发送失败的消息最多重试次数，超过后放弃并通知管理员，默认 10
+	} `yaml:"outbox"`
+	Supervisor struct {
+		MaxPanics     int `yaml:"max_panics"`     // 时间窗口内处理消息出错达到多少次时自动重启进程，0 表示不重启
+		Window        int `yaml:"window"`         // 统计出错次数的时间窗口（分钟），默认 10
+		HealthyPeriod int `yaml:"healthy_period"` // 连续多少分钟没有出错后清零计数，默认 30
+	} `yaml:"supervisor"`
+	Backup struct {
+		Enabled  bool   `yaml:"enabled"`  // 是否定期自动备份数据库
+		Interval int    `yaml:"interval"` // 备份间隔（分钟），默认 60
+		Keep     int    `yaml:"keep"`     // 保留最近的备份数量，默认 24
+		Dir      string `yaml:"dir"`      // 备份目录，默认 backups
+	} `yaml:"backup"`
+	Paths struct {
+		DataDir string `yaml:"data_dir"` // 数据目录，相对路径的数据库、日志和映射备份都放在此目录下，默认当前目录
+		DBPath  string `yaml:"db_path"`  // 数据库文件，默认 bot.db
+		LogPath string `yaml:"log_path"` // 日志文件，默认 bot.log
+	} `yaml:"paths"`
+	Storage struct {
+		Backend    string `yaml:"backend"`     // 存储后端：bolt（默认）或 sqlite（映射、对话记录和拉黑名单同时写入 SQLite，需要 -tags sqlite 编译）
+		SQLitePath string `yaml:"sqlite_path"` // SQLite 数据库文件，默认 bot.sqlite
+	} `yaml:"storage"`
+	Log struct {
+		Level      string `yaml:"level"`       // 日志级别：debug、info、warn 或 error，默认 info；debug 时同时输出 tgbotapi 的日志
+		MaxSize    int    `yaml:"max_size"`    // 日志文件超过多少 MB 时轮转，默认 10
+		MaxBackups int    `yaml:"max_backups"` // 保留的旧日志数量，默认 5
+		Format     string `yaml:"format"`      // 日志格式：text（默认）或 json（每行一个 JSON 对象）
+	} `yaml:"log"`
+	CLI struct {
+		HistorySize int `yaml:"history_size"` // 命令行历史保留的条数，默认 500，负数表示不保存到 cli_history 文件
+	} `yaml:"cli"`
+	QuickActions struct {
+		Enabled bool                `yaml:"enabled"` // 在转发给管理员的发送者信息下附加快捷操作按钮
+		Buttons []QuickActionConfig `yaml:"buttons"` // 按钮列表，留空使用默认的拉黑和已解决
+	} `yaml:"quick_actions"`
+
+	Ticketing     TicketingConfig `yaml:"ticketing"`      // 把用户消息推送到外部工单系统
+	ForwardHeader string          `yaml:"forward_header"` // 转发前发送的发送者信息模板（Go text/template），留空使用默认格式
+
+	location       *time.Location     // 由 Timezone 解析得到
+	webhookTrusted []*net.IPNet       // 由 WebhookAllowlist.TrustedProxies 解析得到
+	headerTemplate *template.Template // 由 ForwardHeader 解析得到
 }
 
-// BotConfig 存储机器人的配置信息
-var BotConfig Config
+// currentConfig 机器人的配置信息，重新加载时整体替换而不修改原有配置，通过 getConfig/setConfig 访问
+var currentConfig atomic.Pointer[Config]
+
+// getConfig 返回当前配置，返回的配置不能修改；尚未加载时返回空配置
+func getConfig() *Config {
+	if cfg := currentConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &Config{}
+}
+
+// setConfig 替换当前配置
+func setConfig(cfg *Config) {
+	currentConfig.Store(cfg)
+}
 
 // bucketname 存储消息ID映射关系的 bucket 名称
 var bucketname = []byte("msg2chatid")
 
+// origBucketname 存储用户原始消息到转发消息ID的映射，键为 "chatid:messageid"
+var origBucketname = []byte("orig2msg")
+
 // db 存储消息ID映射关系的 BoltDB 实例
 var db *bolt.DB
 
-// lastreplyid 存储最后一次回复的消息ID
-var lastreplyid int
+// lastreplyid 最后一个发来消息的用户的聊天ID，命令行 ! 回复该用户
+// 处理更新的 goroutine 写入、命令行读取，因此使用原子操作
+var lastreplyid atomic.Int64
+
+// lastList 命令行 list 最近一次列出的用户，#序号 按此回复
+var lastList []int64
+
+// currentBot Telegram Bot API 实例，InitBot 可能在其他 goroutine 中重新创建，通过 getBot/setBot 访问
+var currentBot atomic.Pointer[tgbotapi.BotAPI]
+
+// getBot 返回当前的 Bot API 实例，尚未创建时返回 nil
+func getBot() *tgbotapi.BotAPI {
+	return currentBot.Load()
+}
 
-// bot Telegram Bot API 实例
-var bot *tgbotapi.BotAPI
+// setBot 替换 Bot API 实例
+func setBot(b *tgbotapi.BotAPI) {
+	currentBot.Store(b)
+}
 
 // 设置日志轮转
 func setupLogging() (*os.File, error) {
+	path := logPath()
+	maxSize := int64(getConfig().Log.MaxSize)
+	if maxSize <= 0 {
+		maxSize = defaultLogMaxSize
+	}
+	maxBackups := getConfig().Log.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = defaultLogMaxBackups
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("无法创建日志目录: %v", err)
+		}
+	}
+
 	// 检查日志文件大小
-	if fi, err := os.Stat("bot.log"); err == nil {
-		if fi.Size() > maxLogSize {
-			// 轮转日志文件
-			for i := maxLogBackups - 1; i > 0; i-- {
-				oldPath := fmt.Sprintf("bot.log.%d", i)
-				newPath := fmt.Sprintf("bot.log.%d", i+1)
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Size() > maxSize*1024*1024 {
+			// 轮转日志文件，旧日志压缩为 <log_path>.N.gz
+			for i := maxBackups - 1; i > 0; i-- {
+				oldPath := fmt.Sprintf("%s.%d.gz", path, i)
+				newPath := fmt.Sprintf("%s.%d.gz", path, i+1)
 				if _, err := os.Stat(oldPath); err == nil {
 					os.Rename(oldPath, newPath)
 				}
 			}
-			os.Rename("bot.log", "bot.log.1")
+			// 压缩失败时继续写入当前日志，下次启动或重新加载时再尝试轮转
+			if err := gzipFile(path, path+".1.gz"); err != nil {
+				fmt.Fprintf(os.Stderr, "压缩日志文件失败: %v\n", err)
+			}
 		}
 	}
 
 	// 打开新的日志文件
-	logFile, err := os.OpenFile("bot.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	logFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("无法创建日志文件: %v", err)
 	}
 
 	// 设置日志格式
-	log.SetOutput(logFile)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+	log.SetOutput(redactWriter{logFile})
+	setLogFormat(getConfig().Log.Format)
 
 	return logFile, nil
 }
 
 func cleanup() {
+	console.Restore()
+	if store != nil {
+		store.Close()
+	}
 	if db != nil {
 		db.Close()
 	}
-	os.Remove("bot.db.lock")
-	// 清理过期的日志文件
-	files, _ := filepath.Glob("bot.log.*")
+	os.Remove(dbLockPath())
+	removeOldLogs()
+}
+
+// removeOldLogs 清理过期的日志文件，包括压缩后的 .gz 和旧版本未压缩的轮转日志
+func removeOldLogs() {
+	files, _ := filepath.Glob(logPath() + ".*")
 	for _, f := range files {
 		if fi, err := os.Stat(f); err == nil {
 			if time.Since(fi.ModTime()) > 30*24*time.Hour { // 删除30天前的日志
@@ -100,6 +277,17 @@ func cleanup() {
 }
 
 func main() {
+	setupConsole(os.Args[1:])
+
+	// 子命令：加密 token，不启动机器人
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-token" {
+		if err := runEncryptToken(); err != nil {
+			fmt.Fprintf(os.Stderr, "加密 token 失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 设置清理函数
 	defer cleanup()
 
@@ -108,20 +296,35 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	go func() {
 		for sig := range sigChan {
-			log.Printf("收到信号: %v, 开始清理...", sig)
-			cleanup()
+			logInfof("收到信号: %v", sig)
 			if sig == syscall.SIGHUP {
-				// 重新加载配置
-				if err := loadConfig(); err != nil {
-					log.Printf("重新加载配置失败: %v", err)
+				// 数据库保持打开，只清理旧日志并重新加载配置
+				removeOldLogs()
+				reloadConfig()
+				if err := loadMessages(); err != nil {
+					logWarnf("重新加载文案失败，继续使用原有文案: %v", err)
 				}
 				setupLogging()
 			} else {
-				os.Exit(0)
+				// 先停止接收更新并等待处理中的更新完成，再备份数据库，避免丢失消息映射
+				beginShutdown()
+				stopBot()
+				if sig == syscall.SIGTERM && db != nil {
+					if _, err := backupDB(shutdownBackupPath()); err != nil {
+						logErrorf("%v", err)
+					}
+				}
+				shutdown()
 			}
 		}
 	}()
 
+	// 加载配置，日志路径来自配置，因此先于日志初始化
+	if err := loadConfig(); err != nil {
+		fmt.Printf("加载配置失败: %v\n", err)
+		return
+	}
+
 	// 设置日志
 	logFile, err := setupLogging()
 	if err != nil {
@@ -130,70 +333,357 @@ func main() {
 	}
 	defer logFile.Close()
 
-	// 加载配置
-	if err := loadConfig(); err != nil {
-		log.Printf("加载配置失败: %v", err)
-		return
+	// 加载文案，失败时使用内置文案
+	if err := loadMessages(); err != nil {
+		logWarnf("加载文案失败，使用内置文案: %v", err)
 	}
 
 	// 初始化数据库
 	if err := initDB(); err != nil {
-		log.Printf("初始化数据库失败: %v", err)
+		logErrorf("初始化数据库失败: %v", err)
 		return
 	}
 
-	// 启动机器人
-	bot, err = tgbotapi.NewBotAPI(BotConfig.Account.Token)
-	if err != nil {
-		log.Printf("Failed to create bot: %v", err)
-		panic("create bot fail: " + err.Error())
+	// 启动 metrics 服务
+	startMetricsServer(getConfig().Metrics.Port)
+
+	// 启动机器人，后台任务会发送消息，因此在机器人启动后再运行
+	// 启动失败时没有可用的机器人通知管理员，只记录日志并退出
+	if err := startBotWithRetry(); err != nil {
+		logErrorf("启动机器人失败: %v", err)
+		fmt.Printf("启动机器人失败: %v\n", err)
+		cleanup()
+		os.Exit(1)
 	}
-	go InitBot(BotConfig.Account.Mode, BotConfig.Account.Token, BotConfig.Account.Endpoint, BotConfig.Account.Port, handleUpdate)
 
-	// 启动命令行接口
+	// 定期释放空闲对话并让排队的用户接入
+	go conversationLoop()
+	// 每日统计报告
+	go reportLoop()
+	// 定期备份数据库
+	go backupLoop()
+	// 定期清理过期的消息映射
+	go mappingSweepLoop()
+	// 重发之前发送失败的消息
+	go outboxLoop()
+	// 发送到期的定时消息
+	go scheduleLoop()
+	// 定期公告
+	go announcementLoop()
+	// 推送用户消息到外部工单系统
+	go ticketingLoop()
+
+	// 启动命令行接口，退出命令行即关闭机器人
+	console.LoadHistory(historyPath(), getConfig().CLI.HistorySize)
 	startCommandLine()
+	shutdown()
 }
 
 func loadConfig() error {
+	// 先解析到新的结构中，校验通过后再替换，重新加载失败时保留原有配置
+	// 配置文件不存在时只使用环境变量
+	var cfg Config
 	yamlFile, err := os.ReadFile("bot.yaml")
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("读取配置文件失败: %v", err)
 	}
+	if err == nil {
+		if err := yaml.Unmarshal(yamlFile, &cfg); err != nil {
+			return fmt.Errorf("解析配置文件失败: %v", err)
+		}
+	}
+	if err := applyEnv(&cfg); err != nil {
+		return err
+	}
+	if cfg.Account.Token, err = decryptToken(cfg.Account.Token); err != nil {
+		return err
+	}
 
-	err = yaml.Unmarshal(yamlFile, &BotConfig)
+	if err := validateConfig(&cfg); err != nil {
+		return fmt.Errorf("配置无效: %v", err)
+	}
+	level, _ := parseLogLevel(cfg.Log.Level)
+	setConfig(&cfg)
+	globalLimiter.SetRate(cfg.RateLimit.Global)
+	setLogLevel(level)
+
+	return nil
+}
+
+// applyEnv 用环境变量覆盖账号配置，优先级高于配置文件
+func applyEnv(cfg *Config) error {
+	if v := os.Getenv("BOT_TOKEN"); v != "" {
+		cfg.Account.Token = v
+	}
+	if v := os.Getenv("BOT_MODE"); v != "" {
+		cfg.Account.Mode = v
+	}
+	if v := os.Getenv("BOT_ENDPOINT"); v != "" {
+		cfg.Account.Endpoint = v
+	}
+	if v := os.Getenv("BOT_OWNER"); v != "" {
+		owner, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("环境变量 BOT_OWNER 不是有效的 ID: %s", v)
+		}
+		cfg.Account.Owner = owner
+	}
+	if v := os.Getenv("BOT_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("环境变量 BOT_PORT 不是有效的端口: %s", v)
+		}
+		cfg.Account.Port = port
+	}
+	return nil
+}
+
+// validateConfig 检查配置是否完整有效，并预编译自动回复规则，启动时尽早发现配置错误
+func validateConfig(cfg *Config) error {
+	if strings.TrimSpace(cfg.Account.Token) == "" {
+		return errors.New("account.token 不能为空")
+	}
+	if cfg.Account.Owner <= 0 {
+		return errors.New("account.owner 必须是管理员的 Telegram ID")
+	}
+	switch cfg.Account.Mode {
+	case "polling":
+	case "webhook":
+		if cfg.Account.Endpoint == "" {
+			return errors.New("webhook 模式需要设置 account.endpoint")
+		}
+		if u, err := url.Parse(cfg.Account.Endpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("account.endpoint 必须是 https 地址: %s", cfg.Account.Endpoint)
+		}
+		if cfg.Account.Port <= 0 || cfg.Account.Port > 65535 {
+			return fmt.Errorf("webhook 模式需要设置有效的 account.port: %d", cfg.Account.Port)
+		}
+		if p := cfg.Account.WebhookPath; p != "" && !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("account.webhook_path 必须以 / 开头: %s", p)
+		}
+		if webhookListenPath(cfg.Account.Endpoint, cfg.Account.WebhookPath) == "/health" {
+			return errors.New("webhook 路径不能是 /health")
+		}
+		if s := cfg.Account.SecretToken; s != "" && !secretTokenPattern.MatchString(s) {
+			return errors.New("account.secret_token 只能包含 1-256 个字母、数字、_ 或 -")
+		}
+	default:
+		return fmt.Errorf("account.mode 只能为 polling 或 webhook: %q", cfg.Account.Mode)
+	}
+
+	switch cfg.Storage.Backend {
+	case "", storageBolt, storageSQLite:
+	default:
+		return fmt.Errorf("storage.backend 只能为 bolt 或 sqlite: %q", cfg.Storage.Backend)
+	}
+
+	for _, t := range cfg.AllowedUpdates {
+		if strings.TrimSpace(t) == "" {
+			return errors.New("allowed_updates 中不能有空的更新类型")
+		}
+	}
+
+	trusted, err := parseCIDRs(cfg.WebhookAllowlist.TrustedProxies)
 	if err != nil {
-		return fmt.Errorf("解析配置文件失败: %v", err)
+		return fmt.Errorf("解析 webhook_allowlist.trusted_proxies 失败: %v", err)
+	}
+	cfg.webhookTrusted = trusted
+
+	switch cfg.ParseMode {
+	case "", tgbotapi.ModeMarkdownV2, tgbotapi.ModeHTML:
+	default:
+		return fmt.Errorf("parse_mode 只能为空、MarkdownV2 或 HTML: %s", cfg.ParseMode)
+	}
+	switch cfg.RelayMode {
+	case "", "forward", "copy":
+	default:
+		return fmt.Errorf("转发方式无效: %s（可选 forward、copy）", cfg.RelayMode)
+	}
+
+	if _, err := parseLogLevel(cfg.Log.Level); err != nil {
+		return err
+	}
+	switch cfg.Log.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("日志格式无效: %s（可选 text、json）", cfg.Log.Format)
+	}
+
+	if err := validateAdmins(cfg.Admins, cfg.Account.Owner); err != nil {
+		return fmt.Errorf("解析管理员配置失败: %v", err)
+	}
+
+	if err := validateQuickActions(cfg.QuickActions.Buttons); err != nil {
+		return fmt.Errorf("解析快捷操作失败: %v", err)
+	}
+
+	if err := compileAutoReplies(cfg.AutoReply); err != nil {
+		return fmt.Errorf("解析自动回复规则失败: %v", err)
+	}
+
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return fmt.Errorf("时区无效: %v", err)
+		}
+		cfg.location = loc
+	}
+
+	if cfg.Report.Time != "" {
+		if _, err := time.Parse("15:04", cfg.Report.Time); err != nil {
+			return fmt.Errorf("report.time 必须是 HH:MM 格式: %s", cfg.Report.Time)
+		}
+	}
+
+	switch cfg.Broadcast.Audience {
+	case "", audienceAll, audienceSubscribers:
+	default:
+		return fmt.Errorf("广播对象无效: %s（可选 all、subscribers）", cfg.Broadcast.Audience)
+	}
+
+	if err := compileAnnouncements(cfg.Announcements); err != nil {
+		return fmt.Errorf("解析定期公告失败: %v", err)
 	}
 
+	if err := compileOutgoingFilter(&cfg.OutgoingFilter); err != nil {
+		return fmt.Errorf("解析禁用词失败: %v", err)
+	}
+
+	cfg.headerTemplate = compileHeaderTemplate(cfg.ForwardHeader)
 	return nil
 }
 
 func initDB() error {
 	// 尝试删除可能存在的锁文件
-	os.Remove("bot.db.lock")
-	os.Remove("bot.db")
+	os.Remove(dbLockPath())
+
+	path := dbPath()
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建数据目录失败: %v", err)
+		}
+	}
 
 	var err error
-	db, err = bolt.Open("bot.db", 0600, &bolt.Options{
+	db, err = bolt.Open(path, 0600, &bolt.Options{
 		Timeout: 3 * time.Second,
 	})
 	if err != nil {
 		return fmt.Errorf("打开数据库失败: %v", err)
 	}
 
-	return db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucketname)
-		if err != nil {
-			return fmt.Errorf("创建消息存储桶失败: %v", err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketname, origBucketname, usersBucket, optoutBucket, inactiveBucket, bannedBucket, draftsBucket, messagesBucket, statsBucket, templatesBucket, languageBucket, ticketsBucket, namesBucket, outboxBucket, spamBucket, scheduleBucket, announcementsBucket, subscribedBucket, auditBucket, sentBucket, approvedBucket, pendingBucket, verifiedBucket, captchaBucket, convQueueBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("创建存储桶 %s 失败: %v", name, err)
+			}
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	store, err = openStorage()
+	return err
 }
 
 // deliverIncomingMsg 处理接收到的消息
 // 将消息转发给管理员并存储消息ID映射关系
 func deliverIncomingMsg(msg SimpleMsg) {
-	log.Printf("receive message from %d %s\n", msg.ChatId, msg.Name)
+	logWith(msg.ChatId, msg.MessageID).Debugf("receive %s message from %d %s @%s", msg.ContentType, msg.ChatId, msg.Name, msg.UserName)
+	messagesReceived.Inc()
+	// 被拉黑的用户直接丢弃
+	if isBanned(msg.ChatId) {
+		logWith(msg.ChatId, msg.MessageID).Debugf("丢弃被拉黑用户 %d 的消息", msg.ChatId)
+		noticeBannedUser(msg.ChatId)
+		return
+	}
+	markReachable(msg.ChatId)
+	// 首次联系时先发送欢迎消息
+	isNew := touchUser(msg)
+	if isNew && getConfig().Welcome.OnFirstContact && claimWelcome(msg.ChatId) {
+		logWith(msg.ChatId, 0).Infof("用户 %d 首次联系, 发送欢迎消息", msg.ChatId)
+		SendStart(msg.ChatId)
+	}
+	// 新用户通过人机验证之前的消息暂缓处理
+	if holdUnverified(msg, isNew) {
+		return
+	}
+	relayIncomingMsg(msg, isNew)
+}
+
+// relayIncomingMsg 对通过验证的用户消息执行批准、反垃圾和自动回复检查后转发给管理员
+// isNew 表示这是用户的第一条消息
+func relayIncomingMsg(msg SimpleMsg, isNew bool) {
+	// 未批准用户的消息暂缓转发，等待管理员批准
+	if holdUnapproved(msg, isNew) {
+		return
+	}
+	// 疑似垃圾消息暂缓转发，等待管理员审核
+	if holdSpam(msg, isNew) {
+		return
+	}
+	// 关键词自动回复
+	if rule := matchAutoReply(msg.Text); rule != nil {
+		SendMsg(msg.ChatId, rule.replyFor(userLanguage(msg.ChatId)))
+		logWith(msg.ChatId, msg.MessageID).Infof("自动回复 %d, 转发给管理员: %v", msg.ChatId, rule.Forward)
+		if !rule.Forward {
+			return
+		}
+	}
+	pushTicketing(msg, ticketState(msg.ChatId))
+	setTicketState(msg.ChatId, ticketOpen)
+	// 同时进行的对话已满时进入排队
+	if !admitConversation(msg) {
+		return
+	}
+	forwardToOwner(msg)
+}
+
+// forwardToOwner 将用户消息转发给管理员并存储消息ID映射关系
+func forwardToOwner(msg SimpleMsg) {
+	// 提示管理员有新消息正在到达
+	sendTyping(getConfig().Account.Owner)
+	// 新对话先发送资料卡
+	sendProfileCard(msg)
+	// 相册中的多条消息缓冲后一起转发
+	if msg.MediaGroupID != "" {
+		bufferMediaGroup(msg)
+		return
+	}
+	info := msgInfo(msg)
+	backupMedia(msg)
+
+	printIncoming(msg.ChatId, msg.Name, info)
+	lastreplyid.Store(msg.ChatId)
+	headerid := sendHeader(msg)
+	var msgid int
+	if isParsedContent(msg) {
+		msgid = RelayMsg(getConfig().Account.Owner, msg.ChatId, msg.MessageID)
+	} else {
+		// 没有解析的类型直接转发原消息，部分类型（如账单）无法复制
+		msgid = ForwardMsg(getConfig().Account.Owner, msg.ChatId, msg.MessageID)
+	}
+	// 发送者信息也记录映射，管理员回复它同样能找到用户
+	putMapping(headerid, msg.ChatId, msg.MessageID)
+	putMapping(msgid, msg.ChatId, msg.MessageID)
+	logWith(msg.ChatId, msgid).Debugf("store chatid %d for message %d", msg.ChatId, msgid)
+	db.Update(func(tx *bolt.Tx) error {
+		tx.Bucket(origBucketname).Put(origKey(msg.ChatId, msg.MessageID), []byte(strconv.Itoa(msgid)))
+		return recordStats(tx, directionIn, msg.ChatId, time.Now())
+	})
+	saveTranscript(msg.ChatId, newTranscriptEntry(directionIn, msg))
+	logWith(msg.ChatId, msgid).Debugf("收到消息来自 %d, 消息 id %d, 消息内容 %s", msg.ChatId, msgid, info)
+}
+
+// isParsedContent 判断消息是否为 SimpleMsg 能完整表示的文本、图片、视频或文件
+func isParsedContent(msg SimpleMsg) bool {
+	return msg.Text != "" || msg.PhotoID != "" || msg.VideoID != "" || msg.FileID != ""
+}
+
+// msgInfo 生成消息内容的简短描述，用于日志和命令行显示
+func msgInfo(msg SimpleMsg) string {
 	var info string
 	if msg.Text != "" {
 		info = msg.Text
@@ -203,18 +693,85 @@ func deliverIncomingMsg(msg SimpleMsg) {
 		info = fmt.Sprintf("photo: %s", msg.PhotoID)
 	} else if msg.VideoID != "" {
 		info = fmt.Sprintf("video: %s", msg.VideoID)
+	} else if msg.Caption != "" {
+		info = fmt.Sprintf("%s: %s", msg.ContentType, msg.Caption)
+	} else {
+		// 投票、地点、游戏等没有解析的类型
+		info = fmt.Sprintf("unsupported type: %s", msg.ContentType)
 	}
+	return info
+}
 
-	fmt.Printf("(%d)%s: %s\n:: ", msg.ChatId, msg.Name, info)
-	lastreplyid = int(msg.ChatId)
-	msgid := ForwardMsg(BotConfig.Account.Owner, msg.ChatId, msg.MessageID)
-	db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketname)
-		b.Put([]byte(strconv.Itoa(msgid)), []byte(strconv.Itoa(int(msg.ChatId))))
-		log.Printf("store chatid %d for message %d\n", msg.ChatId, msgid)
+// formatHeader 生成转发前发送给管理员的发送者信息（MarkdownV2），名字可点击打开用户资料
+func formatHeader(msg SimpleMsg) string {
+	name := strings.TrimSpace(msg.Name)
+	if name == "" {
+		name = "user"
+	}
+	header := fmt.Sprintf("From: [%s](tg://user?id=%d)", escapeMarkdownV2(name), msg.ChatId)
+	if msg.UserName != "" {
+		header += " @" + escapeMarkdownV2(msg.UserName)
+	}
+	header = fmt.Sprintf("%s, id %d", header, msg.ChatId)
+	// 用户转发的是第三方的消息时标明原作者，复制模式下管理员看不到转发来源
+	if msg.ForwardFrom != "" {
+		header += "\nForwarded from: " + escapeMarkdownV2(msg.ForwardFrom)
+	}
+	// 用户回复了之前的某条消息时附上被回复的内容，方便管理员了解上下文
+	if msg.ReplyText != "" {
+		header += fmt.Sprintf("\n\\(in reply to: %s\\)", escapeMarkdownV2(msg.ReplyText))
+	}
+	return header
+}
+
+// sendHeader 向管理员发送发送者信息，返回消息ID
+func sendHeader(msg SimpleMsg) int {
+	text, parseMode := headerText(msg)
+	header := tgbotapi.NewMessage(getConfig().Account.Owner, text)
+	header.ParseMode = parseMode
+	if markup := quickActionMarkup(msg.ChatId); markup != nil {
+		header.ReplyMarkup = *markup
+	}
+	header.DisableNotification = quietRelay()
+	returinfo, _ := sendWithRetry(header)
+	return returinfo.MessageID
+}
+
+// origKey 生成用户原始消息的存储键
+func origKey(chatID int64, messageID int) []byte {
+	return []byte(fmt.Sprintf("%d:%d", chatID, messageID))
+}
+
+// deliverEditedMsg 处理用户编辑过的消息
+// 转发的消息无法被编辑，因此回复到管理员看到的转发消息下提示新内容
+func deliverEditedMsg(msg SimpleMsg) {
+	if isBanned(msg.ChatId) {
+		logWith(msg.ChatId, msg.MessageID).Debugf("丢弃被拉黑用户 %d 编辑的消息", msg.ChatId)
+		return
+	}
+	fwdid := 0
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(origBucketname).Get(origKey(msg.ChatId, msg.MessageID)); v != nil {
+			fwdid, _ = strconv.Atoi(string(v))
+		}
 		return nil
 	})
-	log.Printf("收到消息来自 %d, 消息 id %d, 消息内容 %s\n", msg.ChatId, msgid, info)
+	if fwdid == 0 {
+		logWith(msg.ChatId, msg.MessageID).Warnf("用户 %d 编辑了消息 %d, 但找不到对应的转发消息", msg.ChatId, msg.MessageID)
+		return
+	}
+
+	var note string
+	if msg.Text != "" {
+		note = fmt.Sprintf("用户编辑了消息 %d:\n%s", fwdid, msg.Text)
+	} else {
+		note = fmt.Sprintf("用户编辑了消息 %d 的说明文字:\n%s", fwdid, msg.Caption)
+	}
+	noteid := ReplyMsg(getConfig().Account.Owner, note, fwdid)
+	// 管理员回复这条提示时同样可以找到用户
+	putMapping(noteid, msg.ChatId, msg.MessageID)
+	printIncoming(msg.ChatId, msg.Name+" 编辑了消息", msg.Text+msg.Caption)
+	logWith(msg.ChatId, msg.MessageID).Infof("用户 %d 编辑了消息 %d, 已通知管理员", msg.ChatId, msg.MessageID)
 }
 
 // directmsg 处理直接发送消息的命令
@@ -229,52 +786,150 @@ func directmsg(msg SimpleMsg) {
 		}
 	}
 	if chatid == 0 {
-		SendMsg(msg.ChatId, "format invaild")
+		SendPlain(msg.ChatId, "format invaild")
 		return
 	}
 	if msg.Text != "" {
-		SendMsg(int64(chatid), msg.Text)
+		err := sendText(int64(chatid), msg.Text)
+		recordAudit(msg.FromID, auditReply, int64(chatid), auditResult(msg.Text, err))
+		if err != nil && !errors.Is(err, errQueued) && !errors.Is(err, errBlocked) {
+			// 发送失败时保存草稿，避免管理员输入的内容丢失
+			logWith(int64(chatid), 0).Errorf("发送给 %d 失败, 保存草稿: %v", chatid, err)
+			if err := saveDraft(int64(chatid), msg.Text); err != nil {
+				logErrorf("保存草稿失败: %v", err)
+				ReplyMsg(msg.ChatId, deliveryStatus(int64(chatid), err), msg.MessageID)
+				return
+			}
+			ReplyMsg(msg.ChatId, fmt.Sprintf("✗ 发送给 %d 失败，已保存草稿，可在命令行使用 draft resend %d 重试", chatid, chatid), msg.MessageID)
+			return
+		}
+		ReplyMsg(msg.ChatId, deliveryStatus(int64(chatid), err), msg.MessageID)
 	}
 }
 
+// deliveryStatus 生成告知管理员消息是否送达的提示，成功为 ✓，失败为 ✗ 和原因
+func deliveryStatus(chatID int64, err error) string {
+	if err != nil {
+		return fmt.Sprintf("✗ 发送给 %d 失败: %v", chatID, err)
+	}
+	if id := lastSentID(chatID); id != 0 {
+		return fmt.Sprintf("✓ 已发送给 %d（消息 %d）", chatID, id)
+	}
+	return fmt.Sprintf("✓ 已发送给 %d", chatID)
+}
+
 // deliverOutgoingMsg 处理发出的消息
 // 支持文本、图片、视频和文件的转发
 func deliverOutgoingMsg(msg SimpleMsg) {
+	// 图片、视频或文件的说明以 /at 开头时定时发送该媒体
+	if strings.HasPrefix(msg.Caption, "/at ") {
+		cmd, args := parseCommand(msg.Caption)
+		adminCommand(msg, cmd, args)
+		return
+	}
 	if msg.Text != "" && msg.Text[0] == '*' {
 		directmsg(msg)
 		return
 	}
-	storechatid := 0
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketname)
-		v := b.Get([]byte(strconv.Itoa(msg.ReplyID)))
-		if v != nil {
-			storechatid, _ = strconv.Atoi(string(v))
-		}
-		return nil
-	})
+	if msg.ReplyID == 0 {
+		SendPlain(msg.ChatId, "请回复一条转发的消息来回复用户，或使用 *chatid 内容 直接发送")
+		return
+	}
+	// 用户消息只转发给 owner，消息ID映射只对 owner 的聊天有效
+	if msg.ChatId != getConfig().Account.Owner {
+		SendPlain(msg.ChatId, "用户消息只转发给 owner，请使用 *chatid 内容 或 /reply <chatid> <内容> 回复用户")
+		return
+	}
+	entry := lookupMapping(msg.ReplyID)
+	storechatid := int(entry.ChatID)
 	if storechatid == 0 || storechatid == int(msg.ChatId) {
-		SendMsg(msg.ChatId, "reply to forward ...")
+		// 映射丢失（如数据库被清空）或回复的是机器人自己的提示消息
+		logWarnf("无法确定回复对象: 回复的消息 id %d 没有对应的用户", msg.ReplyID)
+		SendPlain(msg.ChatId, "找不到这条回复对应的用户，请直接回复转发的消息或它上方的发送者信息，也可以使用 *chatid 内容 直接发送")
 	} else {
+		item := outboxItem{ChatID: int64(storechatid), ReplyTo: quoteTarget(entry, time.Now())}
 		if msg.Text != "" {
-			fmt.Printf("(%d)%s\n", storechatid, msg.Text)
-			SendMsg(int64(storechatid), msg.Text)
+			printOutgoing(int64(storechatid), msg.Text)
+			item.Kind, item.Text, item.ParseMode = outboxText, msg.Text, getConfig().ParseMode
 		} else if msg.PhotoID != "" {
-			SendExistingPhoto(int64(storechatid), msg.PhotoID)
+			item.Kind, item.FileID = outboxPhoto, msg.PhotoID
 		} else if msg.VideoID != "" {
-			SendExistingVideo(int64(storechatid), msg.VideoID)
+			item.Kind, item.FileID = outboxVideo, msg.VideoID
 		} else if msg.FileID != "" {
-			SendExistingFile(int64(storechatid), msg.FileID, msg.FileName)
+			item.Kind, item.FileID, item.FileName = outboxFile, msg.FileID, msg.FileName
 		}
+		if item.Kind == "" {
+			ReplyMsg(msg.ChatId, "✗ 不支持回复这种类型的消息", msg.MessageID)
+			return
+		}
+		if err := checkOutgoing(item.Text); err != nil {
+			logWith(item.ChatID, 0).Warnf("阻止发送给 %d 的消息: %v", item.ChatID, err)
+			recordAudit(msg.FromID, auditReply, item.ChatID, auditResult(item.describe(), err))
+			ReplyMsg(msg.ChatId, deliveryStatus(item.ChatID, err), msg.MessageID)
+			return
+		}
+		sendTyping(item.ChatID)
+		err := sendOrQueue(item)
+		recordAudit(msg.FromID, auditReply, item.ChatID, auditResult(item.describe(), err))
+		ReplyMsg(msg.ChatId, deliveryStatus(item.ChatID, err), msg.MessageID)
+		if err != nil && !errors.Is(err, errQueued) {
+			return
+		}
+		messagesSent.Inc()
+		touchConversation(int64(storechatid))
+		recordOutgoing(int64(storechatid), msg)
 	}
 }
 
+// lookupChatID 根据转发给管理员的消息ID查找对应用户的聊天ID，找不到返回 0
+func lookupChatID(msgid int) int {
+	return int(lookupMapping(msgid).ChatID)
+}
+
+// lookupMapping 根据转发给管理员的消息ID查找最新的映射记录，找不到时 ChatID 为 0
+func lookupMapping(msgid int) mappingEntry {
+	entries, err := store.GetMapping(msgid)
+	if err != nil {
+		logErrorf("读取消息 %d 的映射失败: %v", msgid, err)
+	}
+	return latestMapping(msgid, entries)
+}
+
 // deliverOutgoingMsgCmdLine 处理命令行接口发出的消息
+// 发送结果在下一个提示符之前输出
 func deliverOutgoingMsgCmdLine(replyid int, text string) {
-	fmt.Printf("(%d)%s\n", replyid, text)
-	SendMsg(int64(replyid), text)
+	printOutgoing(int64(replyid), text)
+	err := sendText(int64(replyid), text)
+	recordAudit(actorCLI, auditReply, int64(replyid), auditResult(text, err))
+	switch {
+	case err == nil:
+		fmt.Printf("✓ sent to %d (message %d)\n", replyid, lastSentID(int64(replyid)))
+	case errors.Is(err, errQueued):
+		fmt.Printf("✗ failed to send to %d, queued for retry\n", replyid)
+	default:
+		fmt.Printf("✗ failed to send to %d: %v\n", replyid, err)
+	}
+}
+
+// sendText 管理员向用户发送文本，成功或加入重发队列后更新统计、对话状态和对话记录
+// 加入重发队列时返回 errQueued
+func sendText(chatID int64, text string) error {
+	if err := checkOutgoing(text); err != nil {
+		logWith(chatID, 0).Warnf("阻止发送给 %d 的消息: %v", chatID, err)
+		return err
+	}
+	sendTyping(chatID)
+	err := sendOrQueue(outboxItem{ChatID: chatID, Kind: outboxText, Text: text, ParseMode: getConfig().ParseMode})
+	if err != nil && !errors.Is(err, errQueued) {
+		return err
+	}
+	messagesSent.Inc()
+	touchConversation(chatID)
+	recordOutgoing(chatID, SimpleMsg{Text: text})
+	return err
 }
 
+// 以下为内置文案，可以在 messages.yaml 中覆盖
 var welcomeMsg = `*欢迎光临号多多*
 
 1\. 请少量购买测试业务后再批量购买！！！
@@ -306,14 +961,66 @@ var twoFaTutorial = `*2FA登录教程*
 4\. 输入2faCode，页面下方会生成一个6位数字
 5\. 返回推特登录页面，输入6位数字，完成登录`
 
+var helpMsg = `*帮助*
+
+• 直接发送消息即可联系人工客服，客服会尽快回复
+• 点击下方按钮查看登录教程
+• /start 查看欢迎消息
+• /subscribe 订阅广播消息
+• /stop 退订广播消息
+• /lang 设置语言，例如 /lang en`
+
 // commander 处理命令
 func commander(msg SimpleMsg) {
-	if msg.Text == "/start" {
+	cmd, args := parseCommand(msg.Text)
+	if isAdminCommand(cmd) {
+		if !isAdmin(msg.FromID) {
+			SendPlain(msg.ChatId, "该命令仅限管理员使用")
+			return
+		}
+		adminCommand(msg, cmd, args)
+		return
+	}
+	switch cmd {
+	case "/start":
+		if !isAdmin(msg.FromID) {
+			recordUser(msg)
+			setUserFlag(optoutBucket, msg.ChatId, false)
+			claimWelcome(msg.ChatId)
+		}
 		SendStart(msg.ChatId)
+	case "/subscribe":
+		recordUser(msg)
+		setUserFlag(optoutBucket, msg.ChatId, false)
+		setUserFlag(subscribedBucket, msg.ChatId, true)
+		logInfof("用户 %d 订阅广播", msg.ChatId)
+		SendPlain(msg.ChatId, "已订阅广播消息，发送 /unsubscribe 可随时退订")
+	case "/stop", "/unsubscribe":
+		recordUser(msg)
+		setUserFlag(optoutBucket, msg.ChatId, true)
+		setUserFlag(subscribedBucket, msg.ChatId, false)
+		logInfof("用户 %d 退订广播", msg.ChatId)
+		SendPlain(msg.ChatId, "已退订广播消息，发送 /subscribe 可重新订阅")
+	case "/help":
+		SendHelp(msg.ChatId)
+	case "/lang":
+		setLanguage(msg, args)
+	default:
+		SendPlain(msg.ChatId, "unknown command, try /help")
 	}
 }
 
 func SendStart(chatID int64) {
+	sendWithTutorials(chatID, messagesFor(userLanguage(chatID)).Welcome)
+}
+
+// SendHelp 发送帮助信息，附带与 /start 相同的教程按钮
+func SendHelp(chatID int64) {
+	sendWithTutorials(chatID, messagesFor(userLanguage(chatID)).Help)
+}
+
+// sendWithTutorials 发送 MarkdownV2 格式的消息并附带教程按钮
+func sendWithTutorials(chatID int64, text string) {
 	markup := tgbotapi.InlineKeyboardMarkup{
 		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
 			{
@@ -328,37 +1035,65 @@ func SendStart(chatID int64) {
 			},
 		},
 	}
-	msg := tgbotapi.NewMessage(chatID, welcomeMsg)
+	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = "MarkdownV2" // 改用 MarkdownV2
 	msg.DisableWebPagePreview = true
 	msg.ReplyMarkup = markup
-	bot.Send(msg)
+	sendWithRetry(msg)
 }
 
 // handleCallback 处理按钮回调
 func handleCallback(callback *tgbotapi.CallbackQuery) {
 	if callback == nil {
-		log.Println("警告: 收到空回调")
+		logWarnf("收到空回调")
+		return
+	}
+
+	// 快捷操作、审核和验证按钮自行确认回调并显示执行结果
+	if strings.HasPrefix(callback.Data, quickActionPrefix) {
+		handleQuickAction(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, spamPrefix) {
+		handleSpamCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, approvalPrefix) {
+		handleApprovalCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, captchaPrefix) {
+		handleCaptchaCallback(callback)
 		return
 	}
 
-	// 确认收到回调
-	msg := tgbotapi.NewCallback(callback.ID, "")
-	if _, err := bot.Request(msg); err != nil {
-		log.Printf("处理回调请求失败: %v", err)
+	// 确认收到回调，模板按钮提示用户已通知客服
+	isTemplate := strings.HasPrefix(callback.Data, templateCallbackPrefix)
+	ack := ""
+	if isTemplate {
+		ack = "已通知客服"
+	}
+	msg := tgbotapi.NewCallback(callback.ID, ack)
+	if _, err := getBot().Request(msg); err != nil {
+		logErrorf("处理回调请求失败: %v", err)
+		return
+	}
+	if isTemplate {
+		handleTemplateCallback(callback)
 		return
 	}
 
 	var text string
+	set := messagesFor(userLanguage(callback.Message.Chat.ID))
 	switch callback.Data {
 	case "tokenLoginDoc":
-		text = tokenTutorial
-		log.Println("发送token登录教程")
+		text = set.TokenTutorial
+		logDebugf("发送token登录教程")
 	case "2FaLoginDoc":
-		text = twoFaTutorial
-		log.Println("发送2FA登录教程")
+		text = set.TwoFaTutorial
+		logDebugf("发送2FA登录教程")
 	default:
-		log.Printf("未知的回调数据: %s", callback.Data)
+		logWarnf("未知的回调数据: %s", callback.Data)
 		return
 	}
 
@@ -366,22 +1101,24 @@ func handleCallback(callback *tgbotapi.CallbackQuery) {
 	msg2.ParseMode = "MarkdownV2"
 	msg2.DisableWebPagePreview = true
 
-	if _, err := bot.Send(msg2); err != nil {
-		log.Printf("发送教程消息失败: %v", err)
+	if _, err := sendWithRetry(msg2); err != nil {
+		logErrorf("发送教程消息失败: %v", err)
 		plainMsg := tgbotapi.NewMessage(callback.Message.Chat.ID, "抱歉，发送教程时出现错误，请稍后重试。")
-		bot.Send(plainMsg)
+		sendWithRetry(plainMsg)
 	}
 }
 
 // handleUpdate 处理 Telegram 更新事件
 func handleUpdate(update tgbotapi.Update) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("处理更新时发生错误: %v\n", r)
-			SendMsg(BotConfig.Account.Owner, "处理消息时出现错误！请查看日志了解详情。")
-			debug.PrintStack()
-		}
-	}()
+	defer recoverPanic("处理消息")
+
+	lastUpdateAt.Store(time.Now().Unix())
+
+	// webhook-test 发出的合成更新只用于确认链路，不做其他处理
+	if isWebhookTestUpdate(update) {
+		logDebugf("收到 webhook 测试更新")
+		return
+	}
 
 	// 处理按钮回调
 	if update.CallbackQuery != nil {
@@ -389,68 +1126,217 @@ func handleUpdate(update tgbotapi.Update) {
 		return
 	}
 
+	// 处理用户编辑过的消息
+	if update.EditedMessage != nil {
+		msg := formatMessage(update.EditedMessage)
+		if msg.Type == "private" && !isAdmin(msg.FromID) {
+			deliverEditedMsg(msg)
+		}
+		return
+	}
+
 	msg := FormatMsg(update)
 	if msg.Type != "private" {
 		return
 	}
 
-	// 处理命令
+	// 处理命令，被拉黑的用户发送的命令（/start、/help、/lang 等）不做回应
 	if strings.HasPrefix(msg.Text, "/") {
+		if !isAdmin(msg.FromID) && isBanned(msg.ChatId) {
+			logWith(msg.ChatId, msg.MessageID).Debugf("丢弃被拉黑用户 %d 的命令 %s", msg.ChatId, msg.Text)
+			return
+		}
 		commander(msg)
 		return
 	}
 
-	if msg.FromID == BotConfig.Account.Owner {
+	if isAdmin(msg.FromID) {
 		deliverOutgoingMsg(msg)
-	} else {
+	} else if msg, ok := runIncomingHooks(msg); ok {
 		deliverIncomingMsg(msg)
 	}
 }
 
-// SaveMapToDisk 保存消息ID映射关系到磁盘
-func SaveMapToDisk(m map[int]int64) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+// startCommandLine 运行命令行接口，直到输入 exit/quit 或标准输入关闭
+// 标准输入不是终端时（如后台运行），输入关闭后只停用命令行，机器人继续运行直到收到信号
+func startCommandLine() {
+	for {
+		text, err := console.ReadLine()
+		if err != nil {
+			if strings.TrimSpace(text) != "" {
+				doCommand(text)
+			}
+			if err == io.EOF && !stdinIsTerminal() {
+				logInfof("标准输入已关闭, 停用命令行")
+				select {}
+			}
+			fmt.Println()
+			return
+		}
+		doCommand(text)
 	}
-	defer file.Close()
+}
+
+// stdinIsTerminal 判断标准输入是否为终端
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
 
-	encoder := gob.NewEncoder(file)
-	err = encoder.Encode(m)
+// botStopTimeout 关闭时等待处理中的更新完成的最长时间
+const botStopTimeout = 10 * time.Second
+
+var (
+	botMu     sync.Mutex
+	botCancel context.CancelFunc // 取消正在运行的 InitBot
+	botDone   chan struct{}      // InitBot 返回后关闭
+	botMode   string             // 正在运行的模式，webhook 失败回退后与配置不同
+)
+
+var (
+	// lifecycleMu 保证重新加载配置、webhook 回退和关闭不会同时重启或停止机器人
+	// 开始关闭后一直持有，之后不会再启动机器人
+	lifecycleMu  sync.Mutex
+	shutdownOnce sync.Once
+)
+
+// beginShutdown 开始关闭，等待正在进行的重启完成，之后不再启动机器人
+func beginShutdown() {
+	shutdownOnce.Do(lifecycleMu.Lock)
+}
+
+// startBot 按当前配置创建 Bot API 实例、设置命令菜单，并在后台运行 InitBot
+func startBot() error {
+	return startBotMode(getConfig().Account.Mode)
+}
+
+// startBotMode 以指定模式启动机器人
+// 设置 webhook 失败且开启了 webhook_fallback 时改用 polling 模式
+func startBotMode(mode string) error {
+	b, err := tgbotapi.NewBotAPI(getConfig().Account.Token)
 	if err != nil {
+		if isUnauthorized(err) {
+			return fmt.Errorf("bot token 无效或已被撤销，请检查 account.token: %w", err)
+		}
 		return err
 	}
+	if mode == "webhook" {
+		if err := setWebhook(b, getConfig().Account.Endpoint); err != nil {
+			if !getConfig().Account.WebhookFallback || isUnauthorized(err) {
+				return err
+			}
+			logWarnf("设置 webhook 失败, 改用 polling 模式: %v", err)
+			mode = "polling"
+		}
+	}
+	setBot(b)
+	if mode != getConfig().Account.Mode {
+		// 账号上残留的 webhook 会导致 getUpdates 失败
+		if err := clearWebhook(); err != nil {
+			logErrorf("%v", err)
+		}
+	}
+	registerCommands()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	botMu.Lock()
+	botCancel, botDone, botMode = cancel, done, mode
+	botMu.Unlock()
+	go func() {
+		defer close(done)
+		InitBot(ctx, b, mode, getConfig().Account.Endpoint, getConfig().Account.Port, handleUpdate)
+	}()
+	if mode == "webhook" && getConfig().Account.WebhookFallback {
+		go watchWebhook(ctx)
+	}
 	return nil
 }
 
-// LoadMapFromDisk 从磁盘加载消息ID映射关系
-func LoadMapFromDisk() (map[int]int64, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return make(map[int]int64), err
+// 启动失败后重试的等待时间
+const (
+	startRetryMin = 5 * time.Second
+	startRetryMax = 5 * time.Minute
+)
+
+// startBotWithRetry 启动机器人，遇到网络错误或 Telegram 服务器错误时等待后重试
+// token 无效等重试也不会成功的错误直接返回
+func startBotWithRetry() error {
+	wait := startRetryMin
+	for {
+		err := startBot()
+		if err == nil || !isTransientError(err) {
+			return err
+		}
+		logWarnf("启动机器人失败, %v 后重试: %v", wait, err)
+		time.Sleep(wait)
+		if wait *= 2; wait > startRetryMax {
+			wait = startRetryMax
+		}
 	}
-	defer file.Close()
+}
 
-	decoder := gob.NewDecoder(file)
-	var m map[int]int64
-	err = decoder.Decode(&m)
-	if err != nil {
-		return make(map[int]int64), err
+// reloadConfig 重新加载配置（SIGHUP），账号相关配置变化时重启机器人：
+// token 变化会重新创建 Bot API 实例，webhook 的地址或端口变化会重新设置 webhook 并在新端口上启动服务
+// 新配置无法启动时恢复原有账号配置
+func reloadConfig() {
+	lifecycleMu.Lock()
+	defer lifecycleMu.Unlock()
+	old := getConfig().Account
+	if err := loadConfig(); err != nil {
+		logErrorf("重新加载配置失败: %v", err)
+		return
+	}
+	if getConfig().Account == old {
+		registerCommands()
+		return
+	}
+
+	logInfof("账号配置已变化, 重启机器人")
+	stopBot()
+	if old.Mode == "webhook" && getConfig().Account.Mode != "webhook" {
+		// 切换到 polling 前删除 webhook，否则 getUpdates 会失败
+		if err := clearWebhook(); err != nil {
+			logErrorf("%v", err)
+		}
+	}
+	if err := startBot(); err != nil {
+		logWarnf("使用新配置启动机器人失败, 恢复原有账号配置: %v", err)
+		restored := *getConfig()
+		restored.Account = old
+		setConfig(&restored)
+		if err := startBot(); err != nil {
+			logErrorf("恢复原有账号配置失败: %v", err)
+		}
 	}
-	return m, nil
 }
 
-// startCommandLine 启动命令行接口
-func startCommandLine() {
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Print(":: ")
-		text, _ := reader.ReadString('\n')
-		doCommand(text)
+// stopBot 停止 InitBot，等待处理中的更新完成，最多等待 botStopTimeout
+func stopBot() {
+	botMu.Lock()
+	cancel, done := botCancel, botDone
+	botCancel, botDone = nil, nil
+	botMu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(botStopTimeout):
+		logWarnf("等待处理中的更新超时")
 	}
 }
 
+// shutdown 停止接收更新、关闭数据库并退出
+func shutdown() {
+	logInfof("正在关闭...")
+	beginShutdown()
+	stopBot()
+	cleanup()
+	os.Exit(0)
+}
+
 // parseCommand 解析命令
 func parseCommand(text string) (string, []string) {
 	cmdarr := strings.Split(text, " ")
@@ -467,16 +1353,558 @@ func isNumber(s string) bool {
 
 // doCommand 执行命令
 func doCommand(text string) {
+	text = strings.TrimSpace(text)
 	if text == "" {
 		return
 	}
 	cmd, args := parseCommand(text)
-	if cmd == "!" || cmd == "0" {
-		deliverOutgoingMsgCmdLine(lastreplyid, args[0])
-	} else if isNumber(cmd) {
+	switch {
+	case cmd == "exit" || cmd == "quit":
+		shutdown()
+	case cmd == "!" || cmd == "0":
+		if len(args) == 0 {
+			fmt.Println("usage: ! <text>, reply to the last user")
+			return
+		}
+		deliverOutgoingMsgCmdLine(int(lastreplyid.Load()), strings.Join(args, " "))
+	case isNumber(cmd):
+		if len(args) == 0 {
+			fmt.Println("usage: <chatid> <text>")
+			return
+		}
 		chatid, _ := strconv.Atoi(cmd)
-		SendMsg(int64(chatid), args[0])
-	} else {
+		deliverOutgoingMsgCmdLine(chatid, strings.Join(args, " "))
+	case strings.HasPrefix(cmd, "#") && isNumber(cmd[1:]):
+		index, _ := strconv.Atoi(cmd[1:])
+		if index < 1 || index > len(lastList) || len(args) == 0 {
+			fmt.Println("usage: #<index> <text>, index from the last \"list\"")
+			return
+		}
+		deliverOutgoingMsgCmdLine(int(lastList[index-1]), strings.Join(args, " "))
+	case strings.HasPrefix(cmd, "@") && len(cmd) > 1:
+		words := append([]string{cmd[1:]}, args...)
+		name, ids, text := resolveNameArgs(words)
+		switch {
+		case len(ids) == 0:
+			fmt.Printf("no user named %s, usage: @name <text>\n", cmd[1:])
+		case len(ids) > 1:
+			fmt.Printf("%d users are named %s, reply by chat id instead:\n", len(ids), name)
+			for _, id := range ids {
+				fmt.Printf("  %d\n", id)
+			}
+		default:
+			deliverOutgoingMsgCmdLine(int(ids[0]), text)
+		}
+	case cmd == "list":
+		n := 10
+		if len(args) == 1 && isNumber(args[0]) {
+			n, _ = strconv.Atoi(args[0])
+		}
+		users := recentUsers(n)
+		lastList = lastList[:0]
+		for i, u := range users {
+			lastList = append(lastList, u.ChatID)
+			fmt.Printf("#%d (%d)%s  %s\n", i+1, u.ChatID, u.Name, time.Unix(u.LastSeen, 0).Format("2006-01-02 15:04:05"))
+		}
+		if len(users) == 0 {
+			fmt.Println("no users yet")
+		}
+	case cmd == mediaPhoto || cmd == mediaDocument:
+		if len(args) < 2 || !isNumber(args[0]) {
+			fmt.Printf("usage: %s <chatid> <path>\n", cmd)
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		path := strings.Join(args[1:], " ")
+		fileID, err := sendLocalFile(chatid, cmd, path)
+		recordAudit(actorCLI, auditReply, chatid, auditResult(cmd+" "+path, err))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("sent to %d, file id %s\n", chatid, fileID)
+	case cmd == mediaPhoto+"url" || cmd == mediaDocument+"url":
+		if len(args) != 2 || !isNumber(args[0]) {
+			fmt.Printf("usage: %s <chatid> <url>\n", cmd)
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		fileID, err := sendURLFile(chatid, strings.TrimSuffix(cmd, "url"), args[1])
+		recordAudit(actorCLI, auditReply, chatid, auditResult(cmd+" "+args[1], err))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("sent to %d, file id %s\n", chatid, fileID)
+	case cmd == "broadcast":
+		if len(args) == 0 {
+			fmt.Println("usage: broadcast <text>")
+			return
+		}
+		text := strings.Join(args, " ")
+		sent, skipped := broadcast(text)
+		recordAudit(actorCLI, auditBroadcast, 0, fmt.Sprintf("sent=%d skipped=%d %s", sent, skipped, text))
+		fmt.Printf("broadcast done: sent %d, skipped %d (opted out, unreachable or failed)\n", sent, skipped)
+	case cmd == "ban" || cmd == "unban":
+		if len(args) != 1 || !isNumber(args[0]) {
+			fmt.Printf("usage: %s <chatid>\n", cmd)
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		var err error
+		if cmd == "ban" {
+			err = banUser(chatid)
+		} else {
+			err = unbanUser(chatid)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		recordAudit(actorCLI, cmd, chatid, "")
+		fmt.Printf("%s %d done\n", cmd, chatid)
+	case cmd == "import-users":
+		if len(args) != 1 {
+			fmt.Println("usage: import-users <path>")
+			return
+		}
+		entries, invalid, dup, err := parseUserImport(args[0])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		added, err := importUsers(entries)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("imported %d new users (%d already known, %d duplicates, %d invalid)\n", added, len(entries)-added, dup, invalid)
+		logInfof("导入用户 %s: 新增 %d, 无效 %d, 重复 %d", args[0], added, invalid, dup)
+	case cmd == "webhook-test":
+		if err := webhookTest(); err != nil {
+			fmt.Printf("webhook test failed: %v\n", err)
+			return
+		}
+		fmt.Println("webhook test ok: synthetic update was handled")
+	case cmd == "draft":
+		if len(args) == 1 && args[0] == "list" {
+			for chatid, text := range listDrafts() {
+				fmt.Printf("(%d)%s\n", chatid, text)
+			}
+			return
+		}
+		if len(args) != 2 || args[0] != "resend" || !isNumber(args[1]) {
+			fmt.Println("usage: draft list | draft resend <chatid>")
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[1], 10, 64)
+		err := resendDraft(chatid)
+		recordAudit(actorCLI, auditReply, chatid, auditResult("draft resend", err))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("draft resent to %d\n", chatid)
+	case cmd == "audit":
+		var chatid int64
+		if len(args) == 1 && isNumber(args[0]) {
+			chatid, _ = strconv.ParseInt(args[0], 10, 64)
+		} else if len(args) != 0 {
+			fmt.Println("usage: audit [chatid]")
+			return
+		}
+		for _, e := range auditEntries(chatid, 50) {
+			fmt.Println(formatAudit(e))
+		}
+	case cmd == "subscribers":
+		audience := getConfig().Broadcast.Audience
+		if audience == "" {
+			audience = audienceAll
+		}
+		var subscribed, optout int
+		db.View(func(tx *bolt.Tx) error {
+			subscribed = countKeys(tx.Bucket(subscribedBucket))
+			optout = countKeys(tx.Bucket(optoutBucket))
+			return nil
+		})
+		fmt.Printf("%d subscribed, %d unsubscribed, %d will receive broadcasts (audience: %s)\n", subscribed, optout, broadcastRecipients(), audience)
+	case cmd == "spam":
+		switch {
+		case len(args) == 0:
+			records := heldSpam()
+			for _, rec := range records {
+				fmt.Printf("(%d) %d held, %s, %s\n", rec.ChatID, len(rec.Held), rec.Reason, time.Unix(rec.Flagged, 0).Format("2006-01-02 15:04:05"))
+			}
+			fmt.Printf("%d users held\n", len(records))
+		case len(args) == 2 && args[0] == "approve" && isNumber(args[1]):
+			chatid, _ := strconv.ParseInt(args[1], 10, 64)
+			n, err := approveSpam(chatid)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("approved %d, released %d held messages\n", chatid, n)
+		case len(args) == 2 && args[0] == "ban" && isNumber(args[1]):
+			chatid, _ := strconv.ParseInt(args[1], 10, 64)
+			if err := rejectSpam(chatid); err != nil {
+				fmt.Println(err)
+				return
+			}
+			recordAudit(actorCLI, auditBan, chatid, "spam")
+			fmt.Printf("banned %d\n", chatid)
+		default:
+			fmt.Println("usage: spam | spam approve <chatid> | spam ban <chatid>")
+		}
+	case cmd == "approval":
+		switch {
+		case len(args) == 0:
+			records := pendingUsers()
+			for _, rec := range records {
+				fmt.Printf("(%d) %d held since %s\n", rec.ChatID, len(rec.Held), time.Unix(rec.Since, 0).Format("2006-01-02 15:04:05"))
+			}
+			fmt.Printf("%d users pending\n", len(records))
+		case len(args) == 2 && args[0] == "approve" && isNumber(args[1]):
+			chatid, _ := strconv.ParseInt(args[1], 10, 64)
+			n, err := approveUser(chatid)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("approved %d, released %d held messages\n", chatid, n)
+		case len(args) == 2 && args[0] == "ignore" && isNumber(args[1]):
+			chatid, _ := strconv.ParseInt(args[1], 10, 64)
+			n, err := ignorePending(chatid)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("ignored %d messages from %d\n", n, chatid)
+		default:
+			fmt.Println("usage: approval | approval approve <chatid> | approval ignore <chatid>")
+		}
+	case cmd == "at":
+		if len(args) < 3 {
+			fmt.Println("usage: at <+30m|15:04|2006-01-02T15:04> <chatid> <text>")
+			return
+		}
+		at, err := parseScheduleTime(args[0], time.Now())
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		chatid, err := parseScheduleChatID(args[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		item := outboxItem{ChatID: chatid, Kind: outboxText, Text: strings.Join(args[2:], " "), ParseMode: getConfig().ParseMode}
+		id, err := scheduleMessage(at, item)
+		recordAudit(actorCLI, auditReply, chatid, auditResult(scheduledDetail(id, at, item), err))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("scheduled #%d for %s\n", id, at.Format("2006-01-02 15:04 MST"))
+	case cmd == "schedule":
+		switch {
+		case len(args) == 0:
+			list := listScheduled()
+			for _, s := range list {
+				fmt.Println(formatScheduled(s))
+			}
+			fmt.Printf("%d scheduled messages\n", len(list))
+		case len(args) == 2 && args[0] == "cancel" && isNumber(args[1]):
+			id, _ := strconv.ParseUint(args[1], 10, 64)
+			chatid, err := cancelScheduled(id)
+			recordAudit(actorCLI, auditDelete, chatid, auditResult(fmt.Sprintf("scheduled #%d", id), err))
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("scheduled message #%d cancelled\n", id)
+		default:
+			fmt.Println("usage: schedule | schedule cancel <id>")
+		}
+	case cmd == "edit":
+		if len(args) < 2 {
+			fmt.Println("usage: edit <message_id> <text> | edit <chatid>:<message_id> <text>")
+			return
+		}
+		chatid, msgid, ok := parseSentRef(args[0])
+		if !ok {
+			fmt.Println("usage: edit <message_id> <text> | edit <chatid>:<message_id> <text>")
+			return
+		}
+		chatid, err := resolveSent(chatid, msgid)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		text := strings.Join(args[1:], " ")
+		err = editSent(chatid, msgid, text)
+		recordAudit(actorCLI, auditEdit, chatid, auditResult(fmt.Sprintf("#%d %s", msgid, text), err))
+		if err != nil {
+			fmt.Printf("✗ failed to edit message %d for %d: %v\n", msgid, chatid, err)
+			return
+		}
+		fmt.Printf("✓ edited message %d for %d\n", msgid, chatid)
+	case cmd == "del":
+		if len(args) != 1 {
+			fmt.Println("usage: del <message_id> | del <chatid>:<message_id>")
+			return
+		}
+		chatid, msgid, ok := parseSentRef(args[0])
+		if !ok {
+			fmt.Println("usage: del <message_id> | del <chatid>:<message_id>")
+			return
+		}
+		chatid, err := resolveSent(chatid, msgid)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		err = deleteSent(chatid, msgid)
+		recordAudit(actorCLI, auditDelete, chatid, auditResult(fmt.Sprintf("message #%d", msgid), err))
+		if err != nil {
+			fmt.Printf("✗ failed to delete message %d for %d: %v\n", msgid, chatid, err)
+			return
+		}
+		fmt.Printf("✓ deleted message %d for %d\n", msgid, chatid)
+	case cmd == "pin" || cmd == "unpin":
+		chatid, msgid, ok := parsePinArgs(args, cmd == "pin")
+		if !ok {
+			fmt.Println("usage: pin <chatid> <message_id> | unpin <chatid> [message_id]")
+			return
+		}
+		err := pinMessage(chatid, msgid, cmd == "pin")
+		recordAudit(actorCLI, cmd, chatid, auditResult(fmt.Sprintf("message #%d", msgid), err))
+		if err != nil {
+			fmt.Printf("✗ failed to %s message for %d: %v\n", cmd, chatid, err)
+			return
+		}
+		fmt.Printf("✓ %s done for %d\n", cmd, chatid)
+	case cmd == "outbox":
+		switch {
+		case len(args) == 0:
+			items := listOutbox()
+			for _, it := range items {
+				fmt.Println(formatOutboxItem(it))
+			}
+			fmt.Printf("%d queued messages\n", len(items))
+		case len(args) == 2 && args[0] == "retry" && isNumber(args[1]):
+			id, _ := strconv.ParseUint(args[1], 10, 64)
+			if err := retryOutbox(id); err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("outbox #%d will be retried\n", id)
+		case len(args) == 2 && args[0] == "del" && isNumber(args[1]):
+			id, _ := strconv.ParseUint(args[1], 10, 64)
+			if err := deleteOutbox(id); err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("outbox #%d deleted\n", id)
+		default:
+			fmt.Println("usage: outbox | outbox retry <id> | outbox del <id>")
+		}
+	case cmd == "mapping":
+		if len(args) != 1 {
+			fmt.Println("usage: mapping stats | mapping prune")
+			return
+		}
+		switch args[0] {
+		case "stats":
+			entries, chats := mappingStats()
+			fmt.Printf("%d mappings for %d chats\n", entries, chats)
+		case "prune":
+			removed, err := pruneMappings()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("pruned %d orphan mappings\n", removed)
+		default:
+			fmt.Println("usage: mapping stats | mapping prune")
+		}
+	case cmd == "compact":
+		now := time.Now()
+		removed, err := sweepMappings(now.Add(-mappingMaxAge()), now)
+		if err == nil {
+			err = sweepSent(now.Add(-mappingMaxAge()))
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("removed %d mappings older than %s\n", removed, mappingMaxAge())
+	case cmd == "history":
+		if len(args) < 1 || len(args) > 2 || !isNumber(args[0]) {
+			fmt.Println("usage: history <chatid> [n]")
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		n := 20
+		if len(args) == 2 {
+			n, _ = strconv.Atoi(args[1])
+		}
+		entries := loadTranscript(chatid, n)
+		if len(entries) == 0 {
+			fmt.Printf("no history for %d\n", chatid)
+			return
+		}
+		for _, entry := range entries {
+			fmt.Println(formatTranscriptEntry(entry))
+		}
+	case cmd == "export":
+		if len(args) != 1 || !isNumber(args[0]) {
+			fmt.Println("usage: export <chatid>")
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		path, err := exportConversation(chatid)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("exported conversation with %d to %s\n", chatid, path)
+		logInfof("导出 %d 的对话记录到 %s", chatid, path)
+	case cmd == "purge-media":
+		if len(args) != 1 || !isNumber(args[0]) {
+			fmt.Println("usage: purge-media <chatid>")
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		files, freed, err := purgeMedia(chatid)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		recordAudit(actorCLI, auditDelete, chatid, fmt.Sprintf("media files=%d bytes=%d", files, freed))
+		fmt.Printf("purged %d files for %d, %d bytes freed\n", files, chatid, freed)
+	case cmd == "chartdata":
+		if len(args) != 1 || !isNumber(args[0]) {
+			fmt.Println("usage: chartdata <days>")
+			return
+		}
+		days, _ := strconv.Atoi(args[0])
+		if days <= 0 || days > 366 {
+			fmt.Println("days must be between 1 and 366")
+			return
+		}
+		fmt.Print(formatChartData(dailyCounts(days, time.Now())))
+	case cmd == "tickets":
+		tickets := openTickets()
+		for _, t := range tickets {
+			fmt.Println(formatTicket(t))
+		}
+		fmt.Printf("%d open tickets\n", len(tickets))
+	case cmd == "resolve":
+		if len(args) != 1 || !isNumber(args[0]) {
+			fmt.Println("usage: resolve <chatid>")
+			return
+		}
+		chatid, _ := strconv.ParseInt(args[0], 10, 64)
+		if err := resolveTicket(chatid); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("ticket %d resolved\n", chatid)
+	case cmd == "templates":
+		templatesCommand(args)
+	case cmd == "verify":
+		if len(args) == 1 && args[0] == "fix" {
+			if getConfig().Account.Mode == "webhook" {
+				fmt.Println("verify fix only clears a stray webhook in polling mode")
+				return
+			}
+			if err := clearWebhook(); err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Println("webhook cleared")
+			return
+		}
+		problems, err := verifyConfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(problems) == 0 {
+			fmt.Printf("ok: %s mode matches the bot account\n", getConfig().Account.Mode)
+			return
+		}
+		for _, p := range problems {
+			fmt.Println("warning:", p)
+		}
+		if getConfig().Account.Mode != "webhook" {
+			fmt.Println("run \"verify fix\" to delete the webhook")
+		}
+	case cmd == "backup":
+		switch {
+		case len(args) == 1 && args[0] == "mappings":
+			m := snapshotMappings()
+			if err := SaveMapToDisk(m); err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("saved %d mappings to %s\n", len(m), mapPath())
+		case len(args) <= 1:
+			path := defaultBackupPath()
+			if len(args) == 1 {
+				path = args[0]
+			}
+			size, err := backupDB(path)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("database backed up to %s (%d bytes)\n", path, size)
+		default:
+			fmt.Println("usage: backup [path] | backup mappings")
+		}
+	case cmd == "restore":
+		if len(args) != 1 || args[0] != "mappings" {
+			fmt.Println("usage: restore mappings")
+			return
+		}
+		m, err := LoadMapFromDisk()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := restoreMappings(m); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("restored %d mappings from %s\n", len(m), mapPath())
+		logInfof("从 %s 恢复消息映射 %d 条", mapPath(), len(m))
+	case cmd == "dbget":
+		if len(args) != 2 {
+			fmt.Println("usage: dbget <bucket> <key>")
+			return
+		}
+		value, err := dbGet(args[0], args[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(formatRaw(value))
+	case cmd == "dbkeys":
+		if len(args) != 1 {
+			fmt.Println("usage: dbkeys <bucket>")
+			return
+		}
+		keys, err := dbKeys(args[0])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, k := range keys {
+			fmt.Println(formatRaw(k))
+		}
+		fmt.Printf("%d keys\n", len(keys))
+	default:
 		fmt.Println("unknown command")
 	}
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestDoCommandWithoutText(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	lastreplyid.Store(41)
+
+	// 只有命令没有内容时只提示用法，不能 panic
+	for _, line := range []string{"!", "0", "41", " ! ", "41 "} {
+		doCommand(line)
+	}
+	if got := tg.callsTo("sendMessage"); len(got) != 0 {
+		t.Errorf("sent %d messages for commands without text", len(got))
+	}
+}
+
+func TestDoCommandSendsWholeText(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	lastreplyid.Store(41)
+
+	doCommand("! hello world")
+	doCommand("42 see you  soon")
+	if got := tg.sentTo(41); len(got) != 1 || got[0] != "hello world" {
+		t.Errorf("! sent %q, want [hello world]", got)
+	}
+	if got := tg.sentTo(42); len(got) != 1 || got[0] != "see you  soon" {
+		t.Errorf("<chatid> sent %q, want [see you  soon]", got)
+	}
+}
+
+// userUpdate 构造一条用户发来的私聊文本更新
+func userUpdate(updateID int, chatID int64, messageID int, text string) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message: &tgbotapi.Message{
+			MessageID: messageID,
+			From:      &tgbotapi.User{ID: chatID, FirstName: "User"},
+			Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+			Text:      text,
+		},
+	}
+}
+
+// TestConcurrentUpdates 多个 worker 同时处理不同用户的更新，命令行同时读取 lastreplyid、重新创建 Bot API 实例
+// 需要用 go test -race 运行才能发现数据竞争
+func TestConcurrentUpdates(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+
+	// 处理更新的同时重新加载配置，账号配置不变，只替换配置并重新注册命令菜单
+	dir := getConfig().Paths.DataDir
+	yamlConfig := fmt.Sprintf("account:\n  mode: polling\n  token: \"123:test\"\n  owner: %d\npaths:\n  data_dir: %q\n", testOwner, dir)
+	if err := os.WriteFile(filepath.Join(dir, "bot.yaml"), []byte(yamlConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	const users = 20
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_ = lastreplyid.Load()
+			setBot(getBot())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			reloadConfig()
+		}
+	}()
+
+	pool := newUpdatePool(4, handleUpdate)
+	for i := 0; i < users; i++ {
+		pool.dispatch(userUpdate(308000+i, int64(3080+i), 1, "hello"))
+	}
+	pool.close()
+	close(done)
+	wg.Wait()
+
+	if got := len(tg.callsTo("forwardMessage")); got != users {
+		t.Errorf("forwarded %d messages, want %d", got, users)
+	}
+	if id := lastreplyid.Load(); id < 3080 || id >= 3080+users {
+		t.Errorf("lastreplyid = %d, want one of the senders", id)
+	}
+}
+
+func TestValidateRelayMode(t *testing.T) {
+	for _, mode := range []string{"", "forward", "copy", "Copy", "forwrd"} {
+		cfg := Config{RelayMode: mode}
+		cfg.Account.Token = "123:test"
+		cfg.Account.Owner = testOwner
+		cfg.Account.Mode = "polling"
+		err := validateConfig(&cfg)
+		valid := mode == "" || mode == "forward" || mode == "copy"
+		if valid && err != nil {
+			t.Errorf("relay_mode %q rejected: %v", mode, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("relay_mode %q accepted", mode)
+		}
+	}
+}
+
+func TestValidateReportTime(t *testing.T) {
+	for _, tt := range []struct {
+		time  string
+		valid bool
+	}{
+		{"", true},
+		{"09:00", true},
+		{"23:59", true},
+		{"9:30", true},
+		{"25:00", false},
+		{"12:60", false},
+		{"9am", false},
+		{"0900", false},
+		{"09:00:00", false},
+	} {
+		cfg := Config{}
+		cfg.Account.Token = "123:test"
+		cfg.Account.Owner = testOwner
+		cfg.Account.Mode = "polling"
+		cfg.Report.Time = tt.time
+		err := validateConfig(&cfg)
+		if tt.valid && err != nil {
+			t.Errorf("report.time %q rejected: %v", tt.time, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("report.time %q accepted", tt.time)
+		}
+	}
+}
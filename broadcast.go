@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// broadcastInterval 群发时两条消息之间的间隔，避免触发 Telegram 限流
+const broadcastInterval = 50 * time.Millisecond
+
+// activeBroadcasts 正在进行的广播任务数
+var activeBroadcasts atomic.Int32
+
+// 广播对象
+const (
+	audienceAll         = "all"         // 所有未退订的用户
+	audienceSubscribers = "subscribers" // 只发给主动订阅的用户
+)
+
+// isBroadcastRecipient 判断用户是否应收到广播：未退订、仍可达，subscribers 模式下还需主动订阅过
+func isBroadcastRecipient(chatID int64) bool {
+	if hasUserFlag(optoutBucket, chatID) || hasUserFlag(inactiveBucket, chatID) {
+		return false
+	}
+	if getConfig().Broadcast.Audience == audienceSubscribers {
+		return hasUserFlag(subscribedBucket, chatID)
+	}
+	return true
+}
+
+// broadcastRecipients 统计当前会收到广播的用户数
+func broadcastRecipients() int {
+	n := 0
+	for _, chatID := range listUserIDs() {
+		if isBroadcastRecipient(chatID) {
+			n++
+		}
+	}
+	return n
+}
+
+// quietRelay 判断转发给管理员的消息是否应静默发送（广播进行中且开启了 silent_forward）
+func quietRelay() bool {
+	return getConfig().Broadcast.SilentForward && activeBroadcasts.Load() > 0
+}
+
+// broadcast 向按 broadcast.audience 选出的用户群发消息，返回发送和跳过的数量
+func broadcast(text string) (sent, skipped int) {
+	activeBroadcasts.Add(1)
+	defer activeBroadcasts.Add(-1)
+	for _, chatID := range listUserIDs() {
+		if !isBroadcastRecipient(chatID) {
+			skipped++
+			continue
+		}
+		if err := SendMsg(chatID, text); err != nil {
+			logWith(chatID, 0).Errorf("广播发送给 %d 失败: %v", chatID, err)
+			skipped++
+			continue
+		}
+		sent++
+		time.Sleep(broadcastInterval)
+	}
+	logInfof("广播完成: 发送 %d, 跳过 %d", sent, skipped)
+	return sent, skipped
+}
@@ -0,0 +1,113 @@
+package main
+
+import "testing"
+
+func TestBroadcastSkipsOptedOutUsers(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+
+	for _, id := range []int64{11, 12, 13} {
+		touchUser(privateMsg(id, 1, "hi"))
+	}
+	commander(privateMsg(12, 2, "/stop"))
+	tg.reset()
+
+	sent, skipped := broadcast("news")
+	if sent != 2 || skipped != 1 {
+		t.Fatalf("broadcast = sent %d, skipped %d, want 2, 1", sent, skipped)
+	}
+	if got := tg.sentTo(12); len(got) != 0 {
+		t.Errorf("opted-out user received %q", got)
+	}
+	for _, id := range []int64{11, 13} {
+		if got := tg.sentTo(id); len(got) != 1 || got[0] != "news" {
+			t.Errorf("user %d received %q, want [news]", id, got)
+		}
+	}
+}
+
+func TestBroadcastResubscribe(t *testing.T) {
+	for _, cmd := range []string{"/start", "/subscribe"} {
+		t.Run(cmd, func(t *testing.T) {
+			setupTestDB(t)
+			tg := newFakeTelegram(t)
+
+			commander(privateMsg(21, 1, "/stop"))
+			if isBroadcastRecipient(21) {
+				t.Fatal("user is still a recipient after /stop")
+			}
+			commander(privateMsg(21, 2, cmd))
+			tg.reset()
+
+			if sent, _ := broadcast("back"); sent != 1 {
+				t.Fatalf("broadcast sent %d, want 1", sent)
+			}
+			if got := tg.sentTo(21); len(got) != 1 || got[0] != "back" {
+				t.Errorf("user received %q, want [back]", got)
+			}
+		})
+	}
+}
+
+func TestSubscribersAudience(t *testing.T) {
+	setupTestDB(t)
+	newFakeTelegram(t)
+	getConfig().Broadcast.Audience = audienceSubscribers
+
+	commander(privateMsg(31, 1, "/start"))
+	commander(privateMsg(32, 1, "/subscribe"))
+	commander(privateMsg(33, 1, "/subscribe"))
+	commander(privateMsg(33, 2, "/stop"))
+
+	for id, want := range map[int64]bool{31: false, 32: true, 33: false} {
+		if got := isBroadcastRecipient(id); got != want {
+			t.Errorf("isBroadcastRecipient(%d) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestQuietRelayDuringBroadcast(t *testing.T) {
+	tests := []struct {
+		silent, active, want bool
+	}{
+		{false, false, false},
+		{false, true, false},
+		{true, false, false},
+		{true, true, true},
+	}
+	for _, tt := range tests {
+		setupTestDB(t)
+		tg := newFakeTelegram(t)
+		getConfig().Broadcast.SilentForward = tt.silent
+		if tt.active {
+			activeBroadcasts.Add(1)
+		}
+		deliverIncomingMsg(privateMsg(2850, 1, "hello"))
+		if tt.active {
+			activeBroadcasts.Add(-1)
+		}
+
+		// 资料卡、发送者信息和转发的消息都应一致
+		calls := append(tg.callsTo("sendMessage"), tg.callsTo("forwardMessage")...)
+		if len(calls) < 3 {
+			t.Fatalf("silent=%v active=%v: only %d calls to the owner", tt.silent, tt.active, len(calls))
+		}
+		for _, c := range calls {
+			if got := c.Params.Get("disable_notification") == "true"; got != tt.want {
+				t.Errorf("silent=%v active=%v: %s disable_notification = %v, want %v", tt.silent, tt.active, c.Method, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestBroadcastEndsQuietRelay(t *testing.T) {
+	setupTestDB(t)
+	newFakeTelegram(t)
+	getConfig().Broadcast.SilentForward = true
+	touchUser(privateMsg(2851, 1, "hi"))
+
+	broadcast("news")
+	if quietRelay() {
+		t.Error("forwards are still silent after the broadcast finished")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// verifiedBucket 存储通过人机验证的用户，值为通过的时间（unix 秒）
+var verifiedBucket = []byte("verified")
+
+// captchaBucket 存储正在进行的人机验证及验证前暂缓的消息，键为聊天ID
+var captchaBucket = []byte("captcha")
+
+// captchaPrefix 验证按钮回调数据的前缀，格式为 captcha:<answer>
+const captchaPrefix = "captcha:"
+
+// 验证方式
+const (
+	captchaButton = "button" // 点击「我是真人」按钮
+	captchaMath   = "math"   // 从几个选项中选出算式的结果
+)
+
+// captchaOptions 算术验证的选项个数
+const captchaOptions = 4
+
+// captchaHuman 按钮验证的回调答案
+const captchaHuman = "human"
+
+// CaptchaChallenge 一次进行中的人机验证
+type CaptchaChallenge struct {
+	ChatID    int64       `json:"chat_id"`
+	Answer    string      `json:"answer"`               // 正确的回调答案
+	MessageID int         `json:"message_id,omitempty"` // 发给用户的验证消息，答错时更新题目
+	Created   int64       `json:"created"`              // 开始验证的时间（unix 秒）
+	Held      []SimpleMsg `json:"held"`                 // 通过验证前暂缓的消息，通过后按顺序处理
+}
+
+// captchaMode 返回验证方式，默认为按钮
+func captchaMode() string {
+	if getConfig().Captcha.Mode == captchaMath {
+		return captchaMath
+	}
+	return captchaButton
+}
+
+// newCaptcha 生成验证题目，返回提示文字、按钮和正确答案
+func newCaptcha() (string, tgbotapi.InlineKeyboardMarkup, string) {
+	if captchaMode() == captchaButton {
+		markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("我是真人", captchaPrefix+captchaHuman),
+		))
+		return "为了防止垃圾消息，请先点击下面的按钮完成验证，验证通过后您的消息会转给客服。", markup, captchaHuman
+	}
+	a, b := rand.Intn(10)+1, rand.Intn(10)+1
+	answer := a + b
+	// 正确答案和几个相近的错误答案，打乱顺序
+	options := []int{answer}
+	for len(options) < captchaOptions {
+		n := answer + rand.Intn(9) - 4
+		if n > 0 && !containsInt(options, n) {
+			options = append(options, n)
+		}
+	}
+	rand.Shuffle(len(options), func(i, j int) { options[i], options[j] = options[j], options[i] })
+	var row []tgbotapi.InlineKeyboardButton
+	for _, n := range options {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(n), captchaPrefix+strconv.Itoa(n)))
+	}
+	text := fmt.Sprintf("为了防止垃圾消息，请先完成验证：%d + %d = ?\n验证通过后您的消息会转给客服。", a, b)
+	return text, tgbotapi.NewInlineKeyboardMarkup(row), strconv.Itoa(answer)
+}
+
+// containsInt 判断 n 是否在 list 中
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCaptcha 读取用户进行中的验证，不存在时返回 nil
+func loadCaptcha(tx *bolt.Tx, chatID int64) *CaptchaChallenge {
+	v := tx.Bucket(captchaBucket).Get([]byte(strconv.FormatInt(chatID, 10)))
+	if v == nil {
+		return nil
+	}
+	var c CaptchaChallenge
+	if json.Unmarshal(v, &c) != nil {
+		return nil
+	}
+	return &c
+}
+
+// putCaptcha 保存进行中的验证
+func putCaptcha(tx *bolt.Tx, c *CaptchaChallenge) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(captchaBucket).Put([]byte(strconv.FormatInt(c.ChatID, 10)), data)
+}
+
+// holdUnverified 开启 captcha 时，新用户在通过验证之前的消息暂缓处理，返回 true 表示消息已被暂缓
+// 只有首次联系的用户需要验证，开启前已联系过的用户不受影响
+func holdUnverified(msg SimpleMsg, isNew bool) bool {
+	if !getConfig().Captcha.Enabled {
+		return false
+	}
+	var held, challenge bool
+	err := db.Update(func(tx *bolt.Tx) error {
+		c := loadCaptcha(tx, msg.ChatId)
+		if c == nil {
+			if !isNew || tx.Bucket(verifiedBucket).Get([]byte(strconv.FormatInt(msg.ChatId, 10))) != nil {
+				return nil
+			}
+			c = &CaptchaChallenge{ChatID: msg.ChatId, Created: time.Now().Unix()}
+		}
+		// 新的验证或上次验证消息没能发出时发送题目
+		challenge = c.MessageID == 0
+		held = true
+		c.Held = append(c.Held, msg)
+		return putCaptcha(tx, c)
+	})
+	if err != nil {
+		logWith(msg.ChatId, msg.MessageID).Errorf("保存 %d 的验证状态失败: %v", msg.ChatId, err)
+		return false
+	}
+	if !held {
+		return false
+	}
+	if challenge {
+		sendCaptcha(msg.ChatId)
+	} else {
+		logWith(msg.ChatId, msg.MessageID).Debugf("用户 %d 尚未通过验证, 暂缓消息", msg.ChatId)
+	}
+	return true
+}
+
+// sendCaptcha 向用户发送新的验证题目，已有验证消息时在原消息上更新
+func sendCaptcha(chatID int64) {
+	text, markup, answer := newCaptcha()
+	var msgid int
+	err := db.View(func(tx *bolt.Tx) error {
+		if c := loadCaptcha(tx, chatID); c != nil {
+			msgid = c.MessageID
+		}
+		return nil
+	})
+	if err != nil {
+		logWith(chatID, 0).Errorf("读取 %d 的验证状态失败: %v", chatID, err)
+		return
+	}
+	if msgid != 0 {
+		if _, err := getBot().Request(tgbotapi.NewEditMessageTextAndMarkup(chatID, msgid, text, markup)); err != nil {
+			logWith(chatID, msgid).Warnf("更新 %d 的验证题目失败: %v", chatID, err)
+			msgid = 0
+		}
+	}
+	if msgid == 0 {
+		m := tgbotapi.NewMessage(chatID, text)
+		m.ReplyMarkup = markup
+		sent, err := sendWithRetry(m)
+		if err != nil {
+			logWith(chatID, 0).Errorf("发送验证给 %d 失败: %v", chatID, err)
+			return
+		}
+		msgid = sent.MessageID
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		c := loadCaptcha(tx, chatID)
+		if c == nil {
+			return nil
+		}
+		c.Answer, c.MessageID = answer, msgid
+		return putCaptcha(tx, c)
+	})
+	if err != nil {
+		logWith(chatID, msgid).Errorf("保存 %d 的验证题目失败: %v", chatID, err)
+		return
+	}
+	logWith(chatID, msgid).Infof("向新用户 %d 发送人机验证", chatID)
+}
+
+// handleCaptchaCallback 处理用户点击的验证按钮：答对时记为已验证并处理暂缓的消息，答错时换一道题
+func handleCaptchaCallback(callback *tgbotapi.CallbackQuery) {
+	if callback.Message == nil {
+		return
+	}
+	answer := strings.TrimPrefix(callback.Data, captchaPrefix)
+	chatID := callback.Message.Chat.ID
+	var c *CaptchaChallenge
+	passed := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		c = loadCaptcha(tx, chatID)
+		// 验证只能由用户本人在自己的聊天中完成
+		if c == nil || callback.From == nil || callback.From.ID != chatID || c.Answer != answer {
+			return nil
+		}
+		passed = true
+		key := []byte(strconv.FormatInt(chatID, 10))
+		if err := tx.Bucket(captchaBucket).Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(verifiedBucket).Put(key, []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+	})
+	if err != nil {
+		logWith(chatID, 0).Errorf("保存 %d 的验证结果失败: %v", chatID, err)
+		return
+	}
+
+	result := "答案不正确，请重试"
+	switch {
+	case c == nil:
+		result = "验证已完成"
+	case passed:
+		result = "验证通过"
+	}
+	if _, err := getBot().Request(tgbotapi.NewCallback(callback.ID, result)); err != nil {
+		logErrorf("处理回调请求失败: %v", err)
+	}
+	if c == nil {
+		return
+	}
+	if !passed {
+		logWith(chatID, 0).Infof("用户 %d 验证答错", chatID)
+		sendCaptcha(chatID)
+		return
+	}
+
+	if c.MessageID != 0 {
+		if _, err := getBot().Request(tgbotapi.NewEditMessageText(chatID, c.MessageID, "✓ 验证通过，您的消息已转给客服")); err != nil {
+			logWith(chatID, c.MessageID).Warnf("更新 %d 的验证消息失败: %v", chatID, err)
+		}
+	}
+	logWith(chatID, 0).Infof("用户 %d 通过验证, 处理 %d 条暂缓的消息", chatID, len(c.Held))
+	runOnWorker(chatID, "处理验证前暂缓的消息", func() {
+		for i, msg := range c.Held {
+			relayIncomingMsg(msg, i == 0)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// dayCount 某一天的消息数量
+type dayCount struct {
+	Date     string
+	Incoming int
+	Outgoing int
+}
+
+// dailyCounts 统计最近 days 天（含今天）每天收发的消息数量，按日期正序返回
+func dailyCounts(days int, now time.Time) []dayCount {
+	counts := make([]dayCount, days)
+	index := make(map[string]int, days)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := today.AddDate(0, 0, -(days - 1))
+	for i := range counts {
+		date := start.AddDate(0, 0, i).Format("2006-01-02")
+		counts[i].Date = date
+		index[date] = i
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(messagesBucket).ForEach(func(k, v []byte) error {
+			// 每个用户是一个子 bucket，v 为 nil
+			b := tx.Bucket(messagesBucket).Bucket(k)
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(_, data []byte) error {
+				var entry TranscriptEntry
+				if json.Unmarshal(data, &entry) != nil {
+					return nil
+				}
+				t := time.Unix(entry.Time, 0).In(now.Location())
+				if t.Before(start) {
+					return nil
+				}
+				i, ok := index[t.Format("2006-01-02")]
+				if !ok {
+					return nil
+				}
+				if entry.Direction == directionOut {
+					counts[i].Outgoing++
+				} else {
+					counts[i].Incoming++
+				}
+				return nil
+			})
+		})
+	})
+	return counts
+}
+
+// formatChartData 将每日统计输出为 CSV，方便导入表格或绘图工具
+func formatChartData(counts []dayCount) string {
+	var sb strings.Builder
+	sb.WriteString("date,incoming,outgoing,total\n")
+	for _, c := range counts {
+		fmt.Fprintf(&sb, "%s,%d,%d,%d\n", c.Date, c.Incoming, c.Outgoing, c.Incoming+c.Outgoing)
+	}
+	return sb.String()
+}
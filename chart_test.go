@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestDailyCountsBucketsByLocalDay(t *testing.T) {
+	setupTestDB(t)
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	at := func(day, hour, min, sec int) int64 {
+		return time.Date(2026, 3, day, hour, min, sec, 0, loc).Unix()
+	}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, loc)
+
+	entries := map[int64][]TranscriptEntry{
+		2860: {
+			{Time: at(7, 23, 59, 59), Direction: directionIn}, // 统计范围之前
+			{Time: at(8, 0, 0, 0), Direction: directionIn},
+			{Time: at(9, 23, 59, 59), Direction: directionOut},
+			{Time: at(10, 0, 0, 0), Direction: directionIn}, // UTC 仍是 9 日
+			{Time: at(11, 1, 0, 0), Direction: directionIn}, // 明天
+		},
+		2861: {
+			{Time: at(10, 8, 0, 0), Direction: directionOut},
+			{Time: at(10, 11, 59, 59), Direction: directionIn},
+		},
+	}
+	for chatID, list := range entries {
+		for _, e := range list {
+			saveTranscript(chatID, e)
+		}
+	}
+	// 无法解析的记录直接跳过
+	db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(messagesBucket).Bucket([]byte("2861")).Put([]byte("bad"), []byte("{"))
+	})
+
+	want := []dayCount{
+		{Date: "2026-03-08", Incoming: 1},
+		{Date: "2026-03-09", Outgoing: 1},
+		{Date: "2026-03-10", Incoming: 2, Outgoing: 1},
+	}
+	if got := dailyCounts(3, now); !reflect.DeepEqual(got, want) {
+		t.Errorf("dailyCounts = %+v, want %+v", got, want)
+	}
+}
+
+func TestDailyCountsEmptyDays(t *testing.T) {
+	setupTestDB(t)
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	got := dailyCounts(2, now)
+	want := []dayCount{{Date: "2026-02-28"}, {Date: "2026-03-01"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dailyCounts = %+v, want %+v", got, want)
+	}
+}
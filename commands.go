@@ -0,0 +1,74 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// BotCommandConfig 配置中的一条命令菜单项
+type BotCommandConfig struct {
+	Command     string `yaml:"command"`     // 命令名，不带 /
+	Description string `yaml:"description"` // 菜单中显示的说明
+}
+
+// defaultPublicCommands 未配置时所有用户看到的命令菜单
+var defaultPublicCommands = []BotCommandConfig{
+	{Command: "start", Description: "欢迎消息和登录教程"},
+	{Command: "help", Description: "查看帮助"},
+	{Command: "subscribe", Description: "订阅广播消息"},
+	{Command: "stop", Description: "退订广播消息"},
+	{Command: "lang", Description: "设置语言: /lang en"},
+}
+
+// defaultOwnerCommands 未配置时管理员额外看到的命令菜单
+var defaultOwnerCommands = []BotCommandConfig{
+	{Command: "reply", Description: "回复用户: /reply <chatid> <text>"},
+	{Command: "broadcast", Description: "群发消息: /broadcast <text>"},
+	{Command: "ban", Description: "拉黑用户: /ban <chatid>"},
+	{Command: "unban", Description: "解除拉黑: /unban <chatid>"},
+	{Command: "users", Description: "用户统计"},
+	{Command: "stats", Description: "今日消息统计"},
+	{Command: "log", Description: "查看日志: /log [n]"},
+	{Command: "photourl", Description: "发送网络图片: /photourl <chatid> <url>"},
+	{Command: "docurl", Description: "发送网络文件: /docurl <chatid> <url>"},
+	{Command: "at", Description: "定时发送: /at <时间> <chatid> <text>"},
+	{Command: "pin", Description: "置顶消息: /pin <chatid> <message_id>"},
+}
+
+// toBotCommands 转换为 API 使用的命令列表
+func toBotCommands(cmds []BotCommandConfig) []tgbotapi.BotCommand {
+	out := make([]tgbotapi.BotCommand, 0, len(cmds))
+	for _, c := range cmds {
+		out = append(out, tgbotapi.BotCommand{Command: c.Command, Description: c.Description})
+	}
+	return out
+}
+
+// registerCommands 调用 setMyCommands 设置命令菜单
+// 所有私聊使用公开命令，管理员的聊天额外显示管理命令
+func registerCommands() {
+	public := getConfig().Commands.Public
+	if len(public) == 0 {
+		public = defaultPublicCommands
+	}
+	owner := getConfig().Commands.Owner
+	if len(owner) == 0 {
+		owner = defaultOwnerCommands
+	}
+
+	cfg := tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeAllPrivateChats(), toBotCommands(public)...)
+	if _, err := getBot().Request(cfg); err != nil {
+		logErrorf("设置命令菜单失败: %v", err)
+		return
+	}
+
+	// 聊天范围的菜单会覆盖私聊范围，因此管理员的菜单需要包含公开命令
+	ownerCmds := append(append([]BotCommandConfig{}, public...), owner...)
+	for _, id := range adminIDs() {
+		cfg = tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeChat(id), toBotCommands(ownerCmds)...)
+		if _, err := getBot().Request(cfg); err != nil {
+			logErrorf("设置管理员 %d 的命令菜单失败: %v", id, err)
+			return
+		}
+	}
+	logInfof("已设置命令菜单: 公开 %d 条, 管理员 %d 条", len(public), len(ownerCmds))
+}
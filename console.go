@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// 命令行输出使用的 ANSI 转义序列
+const (
+	ansiReset     = "\033[0m"
+	ansiDim       = "\033[2m"
+	ansiCyan      = "\033[36m"
+	ansiGreen     = "\033[32m"
+	ansiClearLine = "\r\033[K" // 回到行首并清除整行
+)
+
+// consolePrompt 命令行提示符
+const consolePrompt = ":: "
+
+// noColorFlag 关闭彩色输出的命令行参数
+const noColorFlag = "--no-color"
+
+// consoleANSI 是否在命令行输出中使用颜色和清行等 ANSI 转义序列
+// 标准输出是终端、没有 --no-color 参数且没有设置 NO_COLOR 环境变量时开启，重定向到文件时保持纯文本
+var consoleANSI bool
+
+// setupConsole 根据启动参数和标准输出判断是否使用 ANSI 转义序列
+func setupConsole(args []string) {
+	for _, arg := range args {
+		if arg == noColorFlag {
+			return
+		}
+	}
+	consoleANSI = os.Getenv("NO_COLOR") == "" && stdoutIsTerminal()
+}
+
+// stdoutIsTerminal 判断标准输出是否为终端
+func stdoutIsTerminal() bool {
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize 给文本加上颜色，未开启 ANSI 时原样返回
+func colorize(color, text string) string {
+	if !consoleANSI {
+		return text
+	}
+	return color + text + ansiReset
+}
+
+// consoleLine 生成一行对话输出：暗色的 (chatid) 加上指定颜色的内容
+func consoleLine(chatID int64, color, text string) string {
+	return colorize(ansiDim, fmt.Sprintf("(%d)", chatID)) + colorize(color, text)
+}
+
+// printIncoming 输出用户发来的消息或与用户相关的事件
+func printIncoming(chatID int64, name, text string) {
+	console.Println(consoleLine(chatID, ansiCyan, name+": "+text))
+}
+
+// printOutgoing 输出发给用户的消息
+func printOutgoing(chatID int64, text string) {
+	console.Println(consoleLine(chatID, ansiGreen, text))
+}
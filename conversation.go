@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// defaultBusyMessage 对话名额已满时回复用户的默认提示
+const defaultBusyMessage = "客服繁忙，请稍后再试"
+
+// conversationCheckInterval 检查空闲对话和排队的间隔
+const conversationCheckInterval = 30 * time.Second
+
+// convMu 保护 activeConvs，并保证判断名额和排队的过程不会交错
+var convMu sync.Mutex
+
+// activeConvs 正在进行的对话及其最后活动时间
+var activeConvs = make(map[int64]time.Time)
+
+// convQueueBucket 存储排队等待接入的用户及排队期间的消息，键为聊天ID，重启后继续排队
+var convQueueBucket = []byte("convqueue")
+
+// queuedConv 一个排队等待接入的用户
+type queuedConv struct {
+	ChatID int64       `json:"chat_id"`
+	Seq    uint64      `json:"seq"`  // 进入队列的顺序
+	Msgs   []SimpleMsg `json:"msgs"` // 排队期间发来的消息，接入后依次转发
+}
+
+// loadQueuedConvs 按进入队列的顺序返回所有排队的用户
+func loadQueuedConvs(tx *bolt.Tx) []queuedConv {
+	var queue []queuedConv
+	tx.Bucket(convQueueBucket).ForEach(func(k, v []byte) error {
+		var q queuedConv
+		if json.Unmarshal(v, &q) == nil {
+			queue = append(queue, q)
+		}
+		return nil
+	})
+	sort.Slice(queue, func(i, j int) bool { return queue[i].Seq < queue[j].Seq })
+	return queue
+}
+
+// idleTimeout 返回对话的空闲超时时间
+func idleTimeout() time.Duration {
+	if getConfig().Conversation.IdleTimeout <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(getConfig().Conversation.IdleTimeout) * time.Minute
+}
+
+// admitConversation 判断用户消息能否立即转发给管理员
+// 对话名额已满时将消息放入队列并提示用户，返回 false
+func admitConversation(msg SimpleMsg) bool {
+	max := getConfig().Conversation.MaxActive
+	if max <= 0 {
+		return true
+	}
+
+	convMu.Lock()
+	if _, ok := activeConvs[msg.ChatId]; ok {
+		activeConvs[msg.ChatId] = time.Now()
+		convMu.Unlock()
+		return true
+	}
+	var queued, alreadyQueued bool
+	var position int
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(convQueueBucket)
+		key := []byte(strconv.FormatInt(msg.ChatId, 10))
+		var q queuedConv
+		if v := b.Get(key); v != nil {
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			alreadyQueued = true
+		} else if len(activeConvs) < max && countKeys(b) == 0 {
+			return nil
+		} else {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			q = queuedConv{ChatID: msg.ChatId, Seq: seq}
+		}
+		q.Msgs = append(q.Msgs, msg)
+		data, err := json.Marshal(q)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+		queued, position = true, countKeys(b)
+		return nil
+	})
+	if err != nil {
+		// 无法保存排队状态时直接转发，不丢失消息
+		logWith(msg.ChatId, msg.MessageID).Errorf("保存 %d 的排队状态失败: %v", msg.ChatId, err)
+		queued = false
+	}
+	if !queued {
+		activeConvs[msg.ChatId] = time.Now()
+		convMu.Unlock()
+		return true
+	}
+	convMu.Unlock()
+
+	if !alreadyQueued {
+		busy := getConfig().Conversation.BusyMessage
+		if busy == "" {
+			busy = defaultBusyMessage
+		}
+		SendMsg(msg.ChatId, busy)
+		logInfof("对话名额已满, 用户 %d 进入排队, 当前排第 %d 位", msg.ChatId, position)
+	}
+	return false
+}
+
+// touchConversation 更新对话的最后活动时间，管理员回复时调用
+func touchConversation(chatID int64) {
+	convMu.Lock()
+	defer convMu.Unlock()
+	if _, ok := activeConvs[chatID]; ok {
+		activeConvs[chatID] = time.Now()
+	}
+}
+
+// endConversation 结束对话并释放名额，随后让排队的用户接入
+func endConversation(chatID int64) {
+	convMu.Lock()
+	delete(activeConvs, chatID)
+	convMu.Unlock()
+	promoteQueued()
+}
+
+// promoteQueued 释放空闲的对话，并按顺序让排队的用户接入，转发他们排队期间的消息
+func promoteQueued() {
+	max := getConfig().Conversation.MaxActive
+
+	convMu.Lock()
+	now := time.Now()
+	for chatID, last := range activeConvs {
+		if now.Sub(last) > idleTimeout() {
+			delete(activeConvs, chatID)
+			logInfof("对话 %d 空闲超时, 释放名额", chatID)
+		}
+	}
+	var promoted []queuedConv
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(convQueueBucket)
+		for _, q := range loadQueuedConvs(tx) {
+			if max > 0 && len(activeConvs)+len(promoted) >= max {
+				break
+			}
+			if err := b.Delete([]byte(strconv.FormatInt(q.ChatID, 10))); err != nil {
+				return err
+			}
+			promoted = append(promoted, q)
+		}
+		return nil
+	})
+	if err != nil {
+		logErrorf("读取排队的用户失败: %v", err)
+		promoted = nil
+	}
+	for _, q := range promoted {
+		activeConvs[q.ChatID] = now
+	}
+	convMu.Unlock()
+
+	// 由计时器或管理员结束对话触发，排队的消息交给各自用户的 worker 转发
+	for _, q := range promoted {
+		if len(q.Msgs) == 0 {
+			continue
+		}
+		msgs := q.Msgs
+		runOnWorker(q.ChatID, "转发排队消息", func() {
+			logInfof("排队用户 %d 接入, 转发 %d 条排队消息", msgs[0].ChatId, len(msgs))
+			printIncoming(msgs[0].ChatId, msgs[0].Name, "排队结束, 已接入")
+			for _, m := range msgs {
+				forwardToOwner(m)
+			}
+		})
+	}
+}
+
+// conversationLoop 定期检查空闲对话和排队
+func conversationLoop() {
+	ticker := time.NewTicker(conversationCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		promoteQueued()
+	}
+}
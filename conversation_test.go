@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// resetConversations 清空对话名额，排队状态在每个测试的数据库中
+func resetConversations(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		convMu.Lock()
+		defer convMu.Unlock()
+		activeConvs = make(map[int64]time.Time)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// forwardedFrom 返回每次转发给管理员的消息来自的用户，按转发顺序排列
+func forwardedFrom(tg *fakeTelegram) []int64 {
+	var chats []int64
+	for _, c := range tg.callsTo("forwardMessage") {
+		id, _ := strconv.ParseInt(c.Params.Get("from_chat_id"), 10, 64)
+		chats = append(chats, id)
+	}
+	return chats
+}
+
+func TestConversationCap(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	resetConversations(t)
+	getConfig().Conversation.MaxActive = 2
+
+	deliverIncomingMsg(privateMsg(2870, 1, "a"))
+	deliverIncomingMsg(privateMsg(2871, 1, "b"))
+	deliverIncomingMsg(privateMsg(2870, 2, "a again"))
+	deliverIncomingMsg(privateMsg(2872, 1, "c"))
+	deliverIncomingMsg(privateMsg(2872, 2, "c again"))
+	deliverIncomingMsg(privateMsg(2873, 1, "d"))
+
+	if got := forwardedFrom(tg); len(got) != 3 {
+		t.Fatalf("forwarded from %v, want only the two active users", got)
+	}
+	// 排队的用户只收到一次提示
+	for _, id := range []int64{2872, 2873} {
+		if got := countText(tg.sentTo(id), defaultBusyMessage); got != 1 {
+			t.Errorf("user %d got the busy message %d times, want 1", id, got)
+		}
+	}
+	getConfig().Conversation.BusyMessage = "please wait"
+	deliverIncomingMsg(privateMsg(2874, 1, "e"))
+	if got := tg.sentTo(2874); len(got) != 1 || got[0] != "please wait" {
+		t.Errorf("custom busy message = %q, want [please wait]", got)
+	}
+}
+
+func TestPromoteQueued(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	resetConversations(t)
+	getConfig().Conversation.MaxActive = 2
+
+	deliverIncomingMsg(privateMsg(2875, 1, "a"))
+	deliverIncomingMsg(privateMsg(2876, 1, "b"))
+	deliverIncomingMsg(privateMsg(2877, 1, "c"))
+	deliverIncomingMsg(privateMsg(2877, 2, "c again"))
+	deliverIncomingMsg(privateMsg(2878, 1, "d"))
+	tg.reset()
+
+	// 结束一个对话后，排在最前的用户接入，排队期间的消息按顺序转发
+	endConversation(2875)
+	if got := forwardedFrom(tg); len(got) != 2 || got[0] != 2877 || got[1] != 2877 {
+		t.Fatalf("forwarded from %v, want both queued messages of 2877", got)
+	}
+	if ids := tg.callsTo("forwardMessage"); ids[0].Params.Get("message_id") != "1" || ids[1].Params.Get("message_id") != "2" {
+		t.Errorf("queued messages forwarded out of order")
+	}
+
+	// 新用户仍要排在已排队的用户之后
+	deliverIncomingMsg(privateMsg(2879, 1, "e"))
+	if got := forwardedFrom(tg); len(got) != 2 {
+		t.Fatalf("new user jumped the queue: forwarded from %v", got)
+	}
+
+	// 空闲超时的对话释放名额
+	convMu.Lock()
+	activeConvs[2876] = time.Now().Add(-idleTimeout() - time.Minute)
+	convMu.Unlock()
+	tg.reset()
+	promoteQueued()
+	if got := forwardedFrom(tg); len(got) != 1 || got[0] != 2878 {
+		t.Errorf("forwarded from %v after idle timeout, want [2878]", got)
+	}
+
+	// 已接入的用户继续发消息直接转发
+	tg.reset()
+	deliverIncomingMsg(privateMsg(2877, 3, "more"))
+	if got := forwardedFrom(tg); len(got) != 1 || got[0] != 2877 {
+		t.Errorf("active user's message forwarded from %v, want [2877]", got)
+	}
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	resetConversations(t)
+	getConfig().Conversation.MaxActive = 1
+
+	deliverIncomingMsg(privateMsg(2880, 1, "a"))
+	deliverIncomingMsg(privateMsg(2881, 1, "b"))
+	deliverIncomingMsg(privateMsg(2881, 2, "b again"))
+
+	// 重启后内存中的对话名额清空，排队的用户和消息仍在数据库中
+	store.Close()
+	db.Close()
+	if err := initDB(); err != nil {
+		t.Fatal(err)
+	}
+	resetConversations(t)
+	tg.reset()
+
+	pool := newUpdatePool(2, handleUpdate)
+	currentPool.Store(pool)
+	t.Cleanup(func() { currentPool.Store(nil) })
+	promoteQueued()
+	pool.close()
+
+	if got := forwardedFrom(tg); len(got) != 2 || got[0] != 2881 || got[1] != 2881 {
+		t.Fatalf("forwarded from %v after restart, want both queued messages of 2881", got)
+	}
+	if ids := tg.callsTo("forwardMessage"); ids[0].Params.Get("message_id") != "1" || ids[1].Params.Get("message_id") != "2" {
+		t.Errorf("queued messages forwarded out of order")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+// dbGet 读取指定 bucket 中某个键的原始值
+func dbGet(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s 不存在", bucket)
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("键 %s 不存在", key)
+		}
+		// bolt 返回的切片只在事务内有效，需要复制
+		value = append([]byte(nil), v...)
+		return nil
+	})
+	return value, err
+}
+
+// dbKeys 列出指定 bucket 中的所有键
+func dbKeys(bucket string) ([][]byte, error) {
+	var keys [][]byte
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s 不存在", bucket)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// formatRaw 可打印的内容按字符串显示，否则显示为十六进制
+func formatRaw(data []byte) string {
+	if !utf8.Valid(data) {
+		return "hex:" + hex.EncodeToString(data)
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return "hex:" + hex.EncodeToString(data)
+		}
+	}
+	return string(data)
+}
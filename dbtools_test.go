@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// seedBucket 在测试数据库中创建 bucket 并写入键值
+func seedBucket(t *testing.T, bucket string, kv map[string]string) {
+	t.Helper()
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for k, v := range kv {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDBGet(t *testing.T) {
+	setupTestDB(t)
+	seedBucket(t, "dbtools-test", map[string]string{"a": "1", "b": "\x00\x01"})
+
+	if v, err := dbGet("dbtools-test", "a"); err != nil || string(v) != "1" {
+		t.Errorf("dbGet(a) = %q, %v, want 1", v, err)
+	}
+	if v, err := dbGet("dbtools-test", "b"); err != nil || !bytes.Equal(v, []byte{0, 1}) {
+		t.Errorf("dbGet(b) = %q, %v, want \\x00\\x01", v, err)
+	}
+	if _, err := dbGet("dbtools-test", "missing"); err == nil {
+		t.Error("dbGet returned no error for a missing key")
+	}
+	if _, err := dbGet("no-such-bucket", "a"); err == nil {
+		t.Error("dbGet returned no error for a missing bucket")
+	}
+}
+
+func TestDBKeys(t *testing.T) {
+	setupTestDB(t)
+	seedBucket(t, "dbtools-test", map[string]string{"x": "1", "y": "2", "z": "3"})
+
+	keys, err := dbKeys("dbtools-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, k := range keys {
+		got = append(got, string(k))
+	}
+	sort.Strings(got)
+	if len(got) != 3 || got[0] != "x" || got[1] != "y" || got[2] != "z" {
+		t.Errorf("dbKeys = %q, want [x y z]", got)
+	}
+
+	seedBucket(t, "dbtools-empty", nil)
+	if keys, err := dbKeys("dbtools-empty"); err != nil || len(keys) != 0 {
+		t.Errorf("dbKeys(empty) = %q, %v, want no keys", keys, err)
+	}
+	if _, err := dbKeys("no-such-bucket"); err == nil {
+		t.Error("dbKeys returned no error for a missing bucket")
+	}
+}
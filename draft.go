@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// draftsBucket 存储发送失败的管理员回复草稿，键为目标聊天ID
+var draftsBucket = []byte("drafts")
+
+// saveDraft 保存发送失败的草稿
+func saveDraft(chatID int64, text string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(draftsBucket).Put([]byte(strconv.FormatInt(chatID, 10)), []byte(text))
+	})
+}
+
+// loadDraft 读取草稿，不存在返回空字符串
+func loadDraft(chatID int64) string {
+	var text string
+	db.View(func(tx *bolt.Tx) error {
+		text = string(tx.Bucket(draftsBucket).Get([]byte(strconv.FormatInt(chatID, 10))))
+		return nil
+	})
+	return text
+}
+
+// deleteDraft 删除草稿
+func deleteDraft(chatID int64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(draftsBucket).Delete([]byte(strconv.FormatInt(chatID, 10)))
+	})
+}
+
+// listDrafts 列出所有草稿
+func listDrafts() map[int64]string {
+	drafts := make(map[int64]string)
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(draftsBucket).ForEach(func(k, v []byte) error {
+			if id, err := strconv.ParseInt(string(k), 10, 64); err == nil {
+				drafts[id] = string(v)
+			}
+			return nil
+		})
+	})
+	return drafts
+}
+
+// resendDraft 重新发送草稿，成功后删除
+func resendDraft(chatID int64) error {
+	text := loadDraft(chatID)
+	if text == "" {
+		return fmt.Errorf("没有发给 %d 的草稿", chatID)
+	}
+	if err := SendMsg(chatID, text); err != nil {
+		return fmt.Errorf("重新发送失败，草稿已保留: %v", err)
+	}
+	logInfof("草稿已重新发送给 %d", chatID)
+	return deleteDraft(chatID)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDraftSavedOnSendFailure(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	tg.failChat(2830, "Bad Request: chat not found")
+
+	directmsg(privateMsg(testOwner, 1, "*2830 order shipped"))
+
+	if got := loadDraft(2830); got != "order shipped" {
+		t.Errorf("draft = %q, want %q", got, "order shipped")
+	}
+	replies := tg.sentTo(testOwner)
+	if len(replies) != 1 || !strings.Contains(replies[0], "已保存草稿") {
+		t.Errorf("owner replies = %q, want a draft notice", replies)
+	}
+}
+
+func TestDraftNotSavedOnSuccess(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+
+	directmsg(privateMsg(testOwner, 1, "*2831 hello"))
+
+	if got := tg.sentTo(2831); len(got) != 1 || got[0] != "hello" {
+		t.Errorf("user received %q, want [hello]", got)
+	}
+	if drafts := listDrafts(); len(drafts) != 0 {
+		t.Errorf("drafts = %v, want none", drafts)
+	}
+}
+
+func TestResendDraft(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	tg.failChat(2832, "Bad Request: chat not found")
+	directmsg(privateMsg(testOwner, 1, "*2832 try again"))
+
+	// 仍然失败时草稿保留
+	if err := resendDraft(2832); err == nil {
+		t.Fatal("resendDraft succeeded while sending fails")
+	}
+	if got := loadDraft(2832); got != "try again" {
+		t.Fatalf("draft after failed resend = %q, want it kept", got)
+	}
+
+	tg.failChat(2832, "")
+	tg.reset()
+	if err := resendDraft(2832); err != nil {
+		t.Fatal(err)
+	}
+	if got := tg.sentTo(2832); len(got) != 1 || got[0] != "try again" {
+		t.Errorf("user received %q, want [try again]", got)
+	}
+	if got := loadDraft(2832); got != "" {
+		t.Errorf("draft = %q after resend, want it deleted", got)
+	}
+	if err := resendDraft(2832); err == nil {
+		t.Error("resendDraft succeeded without a draft")
+	}
+}
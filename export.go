@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// exportMessage 导出文件中的一条消息
+type exportMessage struct {
+	Time      string `json:"time"`
+	Direction string `json:"direction"`
+	Type      string `json:"type"`
+	Text      string `json:"text"`
+}
+
+// exportFile 导出文件的内容
+type exportFile struct {
+	ChatID     int64           `json:"chat_id"`
+	Name       string          `json:"name"`
+	ExportedAt string          `json:"exported_at"`
+	Messages   []exportMessage `json:"messages"`
+}
+
+// exportConversation 将用户的完整对话导出为带时间戳的 JSON 文件，返回文件路径
+func exportConversation(chatID int64) (string, error) {
+	entries := loadTranscript(chatID, 0)
+	if len(entries) == 0 {
+		return "", fmt.Errorf("没有 %d 的对话记录", chatID)
+	}
+
+	now := time.Now()
+	out := exportFile{ChatID: chatID, ExportedAt: now.Format(time.RFC3339)}
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(usersBucket).Get([]byte(strconv.FormatInt(chatID, 10))); v != nil {
+			var info UserInfo
+			if json.Unmarshal(v, &info) == nil {
+				out.Name = info.Name
+			}
+		}
+		return nil
+	})
+	for _, entry := range entries {
+		out.Messages = append(out.Messages, exportMessage{
+			Time:      time.Unix(entry.Time, 0).Format(time.RFC3339),
+			Direction: entry.Direction,
+			Type:      entry.Type,
+			Text:      entry.Text,
+		})
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("export_%d_%s.json", chatID, now.Format("20060102-150405"))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	webhookCheckInterval = 5 * time.Minute // 检查 webhook 状态的间隔
+	webhookMaxFailures   = 3               // 连续多少次检查都在出错时改用 polling
+)
+
+// currentMode 返回机器人正在运行的模式，webhook 回退后为 polling
+func currentMode() string {
+	botMu.Lock()
+	defer botMu.Unlock()
+	return botMode
+}
+
+// webhookFailing 判断 webhook 最近是否在出错：检查间隔内有错误，且有更新积压没有送达
+func webhookFailing() (bool, string) {
+	info, err := getBot().GetWebhookInfo()
+	if err != nil {
+		logWarnf("获取webhook信息失败: %v", err)
+		return false, ""
+	}
+	recent := info.LastErrorDate != 0 && time.Since(time.Unix(int64(info.LastErrorDate), 0)) < webhookCheckInterval
+	return recent && info.PendingUpdateCount > 0, info.LastErrorMessage
+}
+
+// watchWebhook 定期检查 webhook 状态，持续出错时改用 polling 模式，直到 ctx 被取消
+func watchWebhook(ctx context.Context) {
+	ticker := time.NewTicker(webhookCheckInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		failing, reason := webhookFailing()
+		if !failing {
+			failures = 0
+			continue
+		}
+		failures++
+		logWarnf("webhook 出错 (%d/%d): %s", failures, webhookMaxFailures, reason)
+		if failures >= webhookMaxFailures {
+			fallbackToPolling(reason)
+			return
+		}
+	}
+}
+
+// fallbackToPolling 停止 webhook 服务并改用 polling 模式，之后重新加载改变了账号配置或重启进程时才会再次使用 webhook
+func fallbackToPolling(reason string) {
+	lifecycleMu.Lock()
+	defer lifecycleMu.Unlock()
+	if currentMode() != "webhook" {
+		return
+	}
+
+	logWarnf("webhook 持续出错, 改用 polling 模式: %s", reason)
+	stopBot()
+	if err := startBotMode("polling"); err != nil {
+		logErrorf("改用 polling 模式失败: %v", err)
+		return
+	}
+	SendPlain(getConfig().Account.Owner, fmt.Sprintf("webhook 持续出错，已自动改用 polling 模式: %s", reason))
+}
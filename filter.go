@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 禁用词的匹配方式
+const (
+	filterWord      = "word"      // 整词匹配，前后不能紧挨字母或数字
+	filterSubstring = "substring" // 子串匹配
+)
+
+// errBlocked 管理员发出的消息包含禁用词，已阻止发送
+var errBlocked = errors.New("消息包含禁用词，已阻止发送")
+
+// OutgoingFilterConfig 管理员发给用户的消息中禁止出现的词，防止误发内部备注或测试消息
+type OutgoingFilterConfig struct {
+	Mode  string   `yaml:"mode"`  // word（整词，默认）或 substring（子串）
+	Words []string `yaml:"words"` // 禁用词，不区分大小写
+
+	re *regexp.Regexp
+}
+
+// compileOutgoingFilter 把所有禁用词预编译为一个正则表达式
+func compileOutgoingFilter(f *OutgoingFilterConfig) error {
+	f.re = nil
+	switch f.Mode {
+	case "", filterWord, filterSubstring:
+	default:
+		return fmt.Errorf("禁用词匹配方式无效: %s（可选 word、substring）", f.Mode)
+	}
+	var words []string
+	for i, w := range f.Words {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			return fmt.Errorf("第 %d 个禁用词为空", i+1)
+		}
+		words = append(words, regexp.QuoteMeta(w))
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	pattern := "(" + strings.Join(words, "|") + ")"
+	if f.Mode != filterSubstring {
+		pattern = `(?:^|[^\p{L}\p{N}_])` + pattern + `(?:$|[^\p{L}\p{N}_])`
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return err
+	}
+	f.re = re
+	return nil
+}
+
+// checkOutgoing 检查管理员发出的文本，包含禁用词时返回 errBlocked
+func checkOutgoing(text string) error {
+	re := getConfig().OutgoingFilter.re
+	if re == nil || text == "" {
+		return nil
+	}
+	if m := re.FindStringSubmatch(text); m != nil {
+		return fmt.Errorf("%w: %s", errBlocked, m[1])
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"text/template"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// headerData 发送者信息模板可以使用的字段：SimpleMsg 的所有字段，以及工单状态和对话消息数
+type headerData struct {
+	SimpleMsg
+	TicketState  string // open、pending、resolved，没有工单时为空
+	MessageCount int    // 对话记录中的消息总数（不含本条）
+}
+
+// compileHeaderTemplate 解析发送者信息模板，为空时返回 nil
+// 模板无效时记录错误并返回 nil，使用默认的发送者信息
+func compileHeaderTemplate(text string) *template.Template {
+	if text == "" {
+		return nil
+	}
+	tmpl, err := template.New("header").Option("missingkey=error").Parse(text)
+	if err != nil {
+		logErrorf("解析 forward_header 模板失败, 使用默认格式: %v", err)
+		return nil
+	}
+	return tmpl
+}
+
+// loadHeaderData 读取模板需要的工单状态和对话消息数
+func loadHeaderData(msg SimpleMsg) headerData {
+	data := headerData{SimpleMsg: msg, TicketState: ticketState(msg.ChatId)}
+	key := []byte(strconv.FormatInt(msg.ChatId, 10))
+	db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(messagesBucket).Bucket(key); b != nil {
+			data.MessageCount = b.Stats().KeyN
+		}
+		return nil
+	})
+	return data
+}
+
+// headerText 返回发送者信息及其解析模式
+// 配置了模板时按模板生成纯文本，模板执行失败时使用默认的 MarkdownV2 格式
+func headerText(msg SimpleMsg) (string, string) {
+	if tmpl := getConfig().headerTemplate; tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, loadHeaderData(msg)); err != nil {
+			logErrorf("生成发送者信息失败, 使用默认格式: %v", err)
+		} else if buf.Len() > 0 {
+			return buf.String(), ""
+		}
+	}
+	return formatHeader(msg), tgbotapi.ModeMarkdownV2
+}
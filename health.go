@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// lastUpdateAt 最后一次收到 Telegram 更新的时间（unix 秒），用于判断轮询是否卡住
+var lastUpdateAt atomic.Int64
+
+// healthStatus /health 返回的内容
+type healthStatus struct {
+	Status     string `json:"status"`
+	Bot        string `json:"bot"`
+	DBOpen     bool   `json:"db_open"`
+	LastUpdate string `json:"last_update,omitempty"`
+}
+
+// healthHandler 机器人已连接且数据库可用时返回 200，否则返回 503
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{Status: "ok"}
+	b := getBot()
+	if b != nil {
+		status.Bot = b.Self.UserName
+	}
+	status.DBOpen = db != nil && db.View(func(tx *bolt.Tx) error { return nil }) == nil
+	if ts := lastUpdateAt.Load(); ts > 0 {
+		status.LastUpdate = time.Unix(ts, 0).Format(time.RFC3339)
+	}
+
+	code := http.StatusOK
+	if b == nil || !status.DBOpen {
+		status.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+var (
+	healthMu     sync.Mutex
+	healthServer *http.Server // polling 模式下单独的健康检查服务
+)
+
+// startHealthServer polling 模式下在单独的端口启动 /health，端口为 0 时关闭
+// 重新加载配置时可能再次调用：端口不变时保持运行，端口变化时关闭旧服务后在新端口启动
+// webhook 模式下 /health 注册在 webhook 的服务上
+func startHealthServer(port int) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	addr := fmt.Sprintf(":%d", port)
+	if healthServer != nil {
+		if healthServer.Addr == addr {
+			return
+		}
+		healthServer.Close()
+		healthServer = nil
+	}
+	if port == 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthHandler)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	healthServer = srv
+	logInfof("启动健康检查服务, 端口: %d", port)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logErrorf("健康检查服务退出: %v", err)
+		}
+	}()
+}
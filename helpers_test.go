@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// testOwner 测试中的管理员ID
+const testOwner = 1000
+
+// setupTestDB 使用默认配置并在临时目录中初始化数据库，测试结束后关闭
+// 同时清空最近处理过的更新ID，go test -count 多次运行时不会把更新当作重复跳过
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	seenUpdates = newUpdateSet(recentUpdateCount)
+	setConfig(&Config{})
+	getConfig().Account.Owner = testOwner
+	getConfig().Paths.DataDir = t.TempDir()
+	if err := initDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		db.Close()
+	})
+}
+
+// telegramCall 机器人向 Telegram 发出的一次 API 请求
+type telegramCall struct {
+	Method string
+	Params url.Values
+}
+
+// fakeTelegram 模拟 Telegram Bot API，记录所有请求
+// 发送类请求返回一条新消息，results 中的方法返回指定的结果
+// fail 中的方法和发往 failChats 中聊天的请求返回 400 错误
+type fakeTelegram struct {
+	mu        sync.Mutex
+	calls     []telegramCall
+	nextID    int
+	fail      map[string]string // 方法名到错误描述
+	failChats map[int64]string  // 聊天ID到错误描述
+	results   map[string]string // 方法名到 JSON 格式的结果
+}
+
+// newFakeTelegram 启动模拟服务并让 getBot 返回指向它的 Bot API 实例，测试结束后恢复
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+	f := &fakeTelegram{nextID: 100, fail: make(map[string]string), failChats: make(map[int64]string), results: make(map[string]string)}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	bot := &tgbotapi.BotAPI{Token: "123:test", Client: srv.Client(), Buffer: 100}
+	bot.SetAPIEndpoint(srv.URL + "/bot%s/%s")
+	old := getBot()
+	setBot(bot)
+	t.Cleanup(func() { setBot(old) })
+	return f
+}
+
+func (f *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	// 普通请求为表单，上传文件时为 multipart，两种都会填充 r.Form
+	r.ParseMultipartForm(1 << 20)
+	method := path.Base(r.URL.Path)
+	chatID, _ := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+	f.mu.Lock()
+	f.calls = append(f.calls, telegramCall{Method: method, Params: r.Form})
+	f.nextID++
+	id := f.nextID
+	desc, failed := f.fail[method]
+	if !failed {
+		desc, failed = f.failChats[chatID]
+	}
+	result, custom := f.results[method]
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if failed {
+		fmt.Fprintf(w, `{"ok":false,"error_code":400,"description":%q}`, desc)
+		return
+	}
+	if custom {
+		fmt.Fprintf(w, `{"ok":true,"result":%s}`, result)
+		return
+	}
+	fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"date":0,"chat":{"id":%d,"type":"private"}}}`, id, chatID)
+}
+
+// failMethod 让之后对 method 的请求返回 400 错误
+func (f *fakeTelegram) failMethod(method, desc string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail[method] = desc
+}
+
+// respond 让之后对 method 的请求返回 result（JSON 格式）
+func (f *fakeTelegram) respond(method, result string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[method] = result
+}
+
+// failChat 让之后发往 chatID 的请求返回 400 错误，desc 为空时恢复正常
+func (f *fakeTelegram) failChat(chatID int64, desc string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if desc == "" {
+		delete(f.failChats, chatID)
+		return
+	}
+	f.failChats[chatID] = desc
+}
+
+// callsTo 返回对 method 的所有请求
+func (f *fakeTelegram) callsTo(method string) []telegramCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []telegramCall
+	for _, c := range f.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// sentTo 返回发给 chatID 的文本消息
+func (f *fakeTelegram) sentTo(chatID int64) []string {
+	var texts []string
+	for _, c := range f.callsTo("sendMessage") {
+		if c.Params.Get("chat_id") == strconv.FormatInt(chatID, 10) {
+			texts = append(texts, c.Params.Get("text"))
+		}
+	}
+	return texts
+}
+
+// lastMessageID 返回最近一次请求得到的消息ID
+func (f *fakeTelegram) lastMessageID() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nextID
+}
+
+// reset 清空已记录的请求
+func (f *fakeTelegram) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+// privateMsg 构造一条用户发来的私聊文本消息
+func privateMsg(chatID int64, messageID int, text string) SimpleMsg {
+	return SimpleMsg{
+		Type:        "private",
+		FromID:      chatID,
+		MessageID:   messageID,
+		Text:        text,
+		ContentType: "text",
+		ChatId:      chatID,
+		Name:        "Test User",
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+// MessageHook 处理用户消息的钩子，在 deliverIncomingMsg 之前按注册顺序执行
+// 返回修改后的消息交给后续钩子和转发流程，返回 false 时停止处理这条消息
+type MessageHook func(SimpleMsg) (SimpleMsg, bool)
+
+// incomingHooks 已注册的钩子，只在启动前注册，运行时只读
+var incomingHooks []MessageHook
+
+// registerIncomingHook 注册一个用户消息钩子，需要在机器人启动前调用，例如在单独文件的 init 中：
+//
+//	func init() {
+//		registerIncomingHook(func(msg SimpleMsg) (SimpleMsg, bool) {
+//			return msg, !strings.Contains(msg.Text, "casino")
+//		})
+//	}
+func registerIncomingHook(h MessageHook) {
+	incomingHooks = append(incomingHooks, h)
+}
+
+// runIncomingHooks 依次执行钩子，某个钩子返回 false 时停止并返回 false
+func runIncomingHooks(msg SimpleMsg) (SimpleMsg, bool) {
+	for _, h := range incomingHooks {
+		var ok bool
+		if msg, ok = h(msg); !ok {
+			logWith(msg.ChatId, msg.MessageID).Debugf("消息被钩子拦截")
+			return msg, false
+		}
+	}
+	return msg, true
+}
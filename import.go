@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// importEntry 导入文件中的一条用户记录
+type importEntry struct {
+	ChatID int64  `json:"chat_id"`
+	Name   string `json:"name"`
+}
+
+// parseUserImport 解析其他机器人导出的用户列表
+// 支持 JSON（数字数组或 {"chat_id","name"} 对象数组）和 CSV（chatid[,name]）
+// 返回去重后的有效记录以及无效和重复的条数
+func parseUserImport(path string) (entries []importEntry, invalid, dup int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+
+	var raw []importEntry
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		raw, invalid, err = parseImportJSON(file)
+	} else {
+		raw, invalid, err = parseImportCSV(file)
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	seen := make(map[int64]bool)
+	for _, e := range raw {
+		if seen[e.ChatID] {
+			dup++
+			continue
+		}
+		seen[e.ChatID] = true
+		entries = append(entries, e)
+	}
+	return entries, invalid, dup, nil
+}
+
+func parseImportJSON(r io.Reader) ([]importEntry, int, error) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, 0, fmt.Errorf("解析 JSON 失败: %v", err)
+	}
+	var entries []importEntry
+	invalid := 0
+	for _, item := range items {
+		var e importEntry
+		if err := json.Unmarshal(item, &e.ChatID); err != nil {
+			if err := json.Unmarshal(item, &e); err != nil {
+				invalid++
+				continue
+			}
+		}
+		if e.ChatID == 0 {
+			invalid++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, invalid, nil
+}
+
+func parseImportCSV(r io.Reader) ([]importEntry, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, 0, fmt.Errorf("解析 CSV 失败: %v", err)
+	}
+	var entries []importEntry
+	invalid := 0
+	for i, rec := range records {
+		if len(rec) == 0 || strings.TrimSpace(rec[0]) == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(rec[0]), 10, 64)
+		if err != nil || id == 0 {
+			// 第一行可能是表头
+			if i > 0 {
+				invalid++
+			}
+			continue
+		}
+		e := importEntry{ChatID: id}
+		if len(rec) > 1 {
+			e.Name = strings.TrimSpace(rec[1])
+		}
+		entries = append(entries, e)
+	}
+	return entries, invalid, nil
+}
+
+// importUsers 将导入的用户写入 users bucket，已存在的用户保持不变
+// 返回新增的用户数
+func importUsers(entries []importEntry) (added int, err error) {
+	now := time.Now().Unix()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		for _, e := range entries {
+			key := []byte(strconv.FormatInt(e.ChatID, 10))
+			if b.Get(key) != nil {
+				continue
+			}
+			data, err := json.Marshal(UserInfo{ChatID: e.ChatID, Name: e.Name, FirstSeen: now, LastSeen: now, NoMessage: true})
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if added > 0 {
+		if err := store.SyncUsers(); err != nil {
+			logWarnf("同步导入的用户到存储后端失败: %v", err)
+		}
+	}
+	return added, nil
+}
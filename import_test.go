@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeImportFile 在临时目录中写入导入文件，返回其路径
+func writeImportFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseUserImport(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+		want                []importEntry
+		invalid, dup        int
+	}{
+		{
+			name: "json numbers", file: "users.json",
+			content: `[1, 2, 0, "x", 2, 3]`,
+			want:    []importEntry{{ChatID: 1}, {ChatID: 2}, {ChatID: 3}},
+			invalid: 2, dup: 1,
+		},
+		{
+			name: "json objects", file: "users.JSON",
+			content: `[{"chat_id": 10, "name": "Alice"}, {"name": "no id"}, {"chat_id": 10, "name": "again"}, 11]`,
+			want:    []importEntry{{ChatID: 10, Name: "Alice"}, {ChatID: 11}},
+			invalid: 1, dup: 1,
+		},
+		{
+			name: "csv with header", file: "users.csv",
+			content: "chat_id,name\n20, Bob\n\n21\nabc,bad\n20,dup\n",
+			want:    []importEntry{{ChatID: 20, Name: "Bob"}, {ChatID: 21}},
+			invalid: 1, dup: 1,
+		},
+		{
+			name: "csv without extension", file: "users",
+			content: "30,Carol\n-31,Group\n",
+			want:    []importEntry{{ChatID: 30, Name: "Carol"}, {ChatID: -31, Name: "Group"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, invalid, dup, err := parseUserImport(writeImportFile(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries = %v, want %v", entries, tt.want)
+			}
+			if invalid != tt.invalid || dup != tt.dup {
+				t.Errorf("invalid, dup = %d, %d, want %d, %d", invalid, dup, tt.invalid, tt.dup)
+			}
+		})
+	}
+}
+
+func TestParseUserImportErrors(t *testing.T) {
+	if _, _, _, err := parseUserImport(writeImportFile(t, "bad.json", `{"chat_id": 1}`)); err == nil {
+		t.Error("parseUserImport accepted a JSON object instead of an array")
+	}
+	if _, _, _, err := parseUserImport(writeImportFile(t, "bad.csv", "1,\"unterminated\n")); err == nil {
+		t.Error("parseUserImport accepted malformed CSV")
+	}
+	if _, _, _, err := parseUserImport(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("parseUserImport returned no error for a missing file")
+	}
+}
+
+func TestImportUsers(t *testing.T) {
+	setupTestDB(t)
+	newFakeTelegram(t)
+	touchUser(privateMsg(40, 1, "hi"))
+
+	added, err := importUsers([]importEntry{{ChatID: 40, Name: "Imported"}, {ChatID: 41, Name: "Dave"}, {ChatID: 42}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Errorf("added = %d, want 2", added)
+	}
+
+	users := make(map[int64]UserInfo)
+	for _, u := range listUsers() {
+		users[u.ChatID] = u
+	}
+	if len(users) != 3 {
+		t.Fatalf("users bucket has %d users, want 3: %v", len(users), users)
+	}
+	// 已存在的用户保持不变
+	if u := users[40]; u.Name != "Test User" || u.NoMessage {
+		t.Errorf("existing user changed to %+v", u)
+	}
+	if u := users[41]; u.Name != "Dave" || !u.NoMessage || u.FirstSeen == 0 {
+		t.Errorf("imported user = %+v", u)
+	}
+
+	// 再次导入不会重复添加
+	if added, err := importUsers([]importEntry{{ChatID: 41}, {ChatID: 42}}); err != nil || added != 0 {
+		t.Errorf("second import added %d, %v, want 0", added, err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// telegramCIDRs Telegram 发送 webhook 请求使用的地址段
+var telegramCIDRs = mustParseCIDRs("149.154.160.0/20", "91.108.4.0/22")
+
+// mustParseCIDRs 解析内置的地址段
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// parseCIDRs 解析地址段列表，单个 IP 视为 /32 或 /128
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("地址段无效: %s", c)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ipInNets 判断 IP 是否属于任意一个地址段
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 返回请求的来源 IP
+// 直接连接的地址属于可信代理时，从 X-Forwarded-For 中从右往左取第一个不属于可信代理的地址
+func clientIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ipInNets(ip, trusted) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !ipInNets(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// allowWebhookRequest 判断是否接受这个 webhook 请求
+// 未开启时全部接受；开启后只接受来自 Telegram 地址段的请求，以及带有本进程密钥的 webhook-test 请求
+// 本机地址不做例外：webhook 服务前面通常是本机的 TLS 反向代理，代理没有设置 X-Forwarded-For 时所有请求都来自本机
+func allowWebhookRequest(r *http.Request) (net.IP, bool) {
+	ip := clientIP(r, getConfig().webhookTrusted)
+	if !getConfig().WebhookAllowlist.Enabled || isWebhookTestRequest(r) {
+		return ip, true
+	}
+	if ip == nil {
+		return nil, false
+	}
+	return ip, ipInNets(ip, telegramCIDRs)
+}
+
+// telegramOnly 拒绝不在允许范围内的 webhook 请求，返回 403 并记录来源
+func telegramOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ip, ok := allowWebhookRequest(r); !ok {
+			logWarnf("拒绝来自 %s 的 webhook 请求（%s）", ip, r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
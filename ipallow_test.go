@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTelegramOnly(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		enabled    bool
+		remote     string
+		forwarded  string
+		testKey    string
+		wantStatus int
+	}{
+		{"disabled", false, "203.0.113.5:1234", "", "", http.StatusOK},
+		{"telegram", true, "149.154.167.220:443", "", "", http.StatusOK},
+		{"telegram second range", true, "91.108.4.10:443", "", "", http.StatusOK},
+		{"other address", true, "203.0.113.5:1234", "", "", http.StatusForbidden},
+		{"untrusted loopback", true, "[::1]:1234", "", "", http.StatusForbidden},
+		{"proxy without forwarded for", true, "127.0.0.1:1234", "", "", http.StatusForbidden},
+		{"proxy forwarding telegram", true, "127.0.0.1:1234", "149.154.167.220", "", http.StatusOK},
+		{"proxy forwarding other", true, "127.0.0.1:1234", "203.0.113.5", "", http.StatusForbidden},
+		{"proxy chain", true, "127.0.0.1:1234", "149.154.167.220, 10.1.2.3", "", http.StatusOK},
+		{"spoofed forwarded for", true, "203.0.113.5:1234", "149.154.167.220", "", http.StatusForbidden},
+		{"webhook-test key", true, "127.0.0.1:1234", "", webhookTestKey, http.StatusOK},
+		{"wrong webhook-test key", true, "127.0.0.1:1234", "", "guess", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(&Config{})
+			getConfig().WebhookAllowlist.Enabled = tt.enabled
+			getConfig().webhookTrusted = trusted
+
+			req := httptest.NewRequest(http.MethodPost, "/hook", nil)
+			req.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.testKey != "" {
+				req.Header.Set(webhookTestHeader, tt.testKey)
+			}
+			rec := httptest.NewRecorder()
+			telegramOnly(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestWebhookTestPassesAllowlist(t *testing.T) {
+	setConfig(&Config{})
+	getConfig().WebhookAllowlist.Enabled = true
+	url := startTestWebhook(t, "/hook")
+
+	if err := postWebhookTest(url); err != nil {
+		t.Fatalf("postWebhookTest with allowlist enabled: %v", err)
+	}
+}
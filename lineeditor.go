@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// 行编辑器处理的控制字符
+const (
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyBackspace = 0x08
+	keyCtrlU     = 0x15
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+)
+
+// defaultHistorySize 命令行历史默认保留的条数
+const defaultHistorySize = 500
+
+// lineEditor 命令行的行编辑器，所有命令行输出都经过它以免互相穿插
+// 标准输入和输出都是终端时，读取一行期间把终端切换到原始模式逐字读取，自己维护正在输入的内容：
+// 异步输出（如用户发来的消息）先清除提示符行，输出后重新显示提示符和正在输入的内容
+// 上下方向键在历史命令之间切换，历史保存到文件，下次启动时仍可使用
+// 不是终端时（如后台运行或输入来自管道）按行读取，不做编辑，也不记录历史
+type lineEditor struct {
+	mu       sync.Mutex
+	in       *bufio.Reader
+	fd       int
+	tty      bool        // 是否逐字读取并自行显示输入
+	reading  bool        // 正在等待输入，异步输出后需要重新显示提示符
+	buf      []rune      // 正在输入的内容
+	state    *term.State // 进入原始模式前的终端状态，不在原始模式时为 nil
+	history  []string    // 历史命令，最新的在最后
+	histPos  int         // 正在显示的历史命令下标，等于 len(history) 表示正在编辑新的一行
+	draft    []rune      // 翻看历史前正在输入的内容，回到最新一行时恢复
+	histFile string      // 历史文件路径，为空时不保存
+	histSize int         // 最多保留的历史条数
+}
+
+// console 命令行使用的行编辑器
+var console = newLineEditor()
+
+// newLineEditor 创建读取标准输入的行编辑器
+func newLineEditor() *lineEditor {
+	fd := int(os.Stdin.Fd())
+	return &lineEditor{
+		in:       bufio.NewReader(os.Stdin),
+		fd:       fd,
+		tty:      term.IsTerminal(fd) && stdoutIsTerminal(),
+		histSize: defaultHistorySize,
+	}
+}
+
+// ReadLine 显示提示符并读取一行输入，不含换行符
+// 输入结束（Ctrl+D 或标准输入关闭）时返回已读到的内容和 io.EOF
+func (e *lineEditor) ReadLine() (string, error) {
+	if !e.tty {
+		e.mu.Lock()
+		e.reading = true
+		fmt.Print(consolePrompt)
+		e.mu.Unlock()
+		line, err := e.in.ReadString('\n')
+		e.mu.Lock()
+		e.reading = false
+		e.mu.Unlock()
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+		}
+		return line, err
+	}
+
+	e.mu.Lock()
+	state, err := term.MakeRaw(e.fd)
+	if err != nil {
+		// 无法切换到原始模式时退回按行读取
+		e.tty = false
+		e.mu.Unlock()
+		return e.ReadLine()
+	}
+	e.state, e.reading, e.buf = state, true, nil
+	e.histPos, e.draft = len(e.history), nil
+	e.redraw()
+	e.mu.Unlock()
+
+	line, err := e.readRaw()
+
+	e.mu.Lock()
+	e.restore()
+	e.reading, e.buf, e.draft = false, nil, nil
+	if err == nil {
+		e.addHistory(line)
+	}
+	// 原始模式下回车不会换行，命令的输出从下一行开始；输入结束时由调用方换行
+	if err == nil {
+		fmt.Println()
+	}
+	e.mu.Unlock()
+	return line, err
+}
+
+// readRaw 在原始模式下逐字读取，直到回车、Ctrl+D 或读取出错
+func (e *lineEditor) readRaw() (string, error) {
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return e.current(), err
+		}
+		e.mu.Lock()
+		switch {
+		case r == '\r' || r == '\n':
+			e.mu.Unlock()
+			return e.current(), nil
+		case r == keyCtrlC || r == keyCtrlD && len(e.buf) == 0:
+			// 原始模式下 Ctrl+C 不再产生信号，与 Ctrl+D 一样结束输入，随后关闭机器人
+			e.mu.Unlock()
+			return "", io.EOF
+		case r == keyBackspace || r == keyDelete:
+			if len(e.buf) > 0 {
+				e.buf = e.buf[:len(e.buf)-1]
+			}
+		case r == keyCtrlU:
+			e.buf = nil
+		case r == keyEscape:
+			// 上下方向键切换历史命令，其他转义序列忽略
+			switch e.readEscape() {
+			case "[A", "OA":
+				e.recall(-1)
+			case "[B", "OB":
+				e.recall(1)
+			}
+		case r >= ' ':
+			e.buf = append(e.buf, r)
+		}
+		e.redraw()
+		e.mu.Unlock()
+	}
+}
+
+// readEscape 读取 ESC 之后的转义序列并返回（不含 ESC），如上方向键为 "[A"
+// CSI（ESC [）和 SS3（ESC O）序列读到结束字符为止，其他情况只读一个字符
+func (e *lineEditor) readEscape() string {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return ""
+	}
+	seq := []rune{r}
+	if r != '[' && r != 'O' {
+		return string(seq)
+	}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return string(seq)
+		}
+		seq = append(seq, r)
+		// 参数和中间字符在 0x20-0x3f 之间，结束字符在 0x40-0x7e 之间
+		if r >= 0x40 && r <= 0x7e {
+			return string(seq)
+		}
+	}
+}
+
+// recall 向前（-1）或向后（1）切换历史命令，调用时需持有 mu
+// 切换后的内容可以继续编辑，不会修改历史本身
+func (e *lineEditor) recall(step int) {
+	pos := e.histPos + step
+	if pos < 0 || pos > len(e.history) {
+		return
+	}
+	if e.histPos == len(e.history) {
+		e.draft = e.buf
+	}
+	e.histPos = pos
+	if pos == len(e.history) {
+		e.buf = e.draft
+		return
+	}
+	e.buf = []rune(e.history[pos])
+}
+
+// addHistory 记录一条命令并追加到历史文件，空行和与上一条相同的命令不记录，调用时需持有 mu
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > e.histSize {
+		e.history = e.history[len(e.history)-e.histSize:]
+	}
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logWarnf("写入命令行历史失败: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		logWarnf("写入命令行历史失败: %v", err)
+	}
+}
+
+// LoadHistory 读取历史文件并在之后的输入中记录历史，size 为保留的条数，0 使用默认值，负数表示不保存历史文件
+// 文件中的条数超过 size 时重写文件只保留最近的部分；不是终端时不读取也不记录
+func (e *lineEditor) LoadHistory(path string, size int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.tty {
+		return
+	}
+	if size == 0 {
+		size = defaultHistorySize
+	}
+	if size < 0 {
+		return
+	}
+	e.histFile, e.histSize = path, size
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logWarnf("读取命令行历史失败: %v", err)
+		}
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) <= size {
+		return
+	}
+	e.history = e.history[len(e.history)-size:]
+	if err := os.WriteFile(path, []byte(strings.Join(e.history, "\n")+"\n"), 0600); err != nil {
+		logWarnf("重写命令行历史失败: %v", err)
+	}
+}
+
+// current 返回正在输入的内容
+func (e *lineEditor) current() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return string(e.buf)
+}
+
+// redraw 清除当前行并重新显示提示符和正在输入的内容，调用时需持有 mu
+func (e *lineEditor) redraw() {
+	fmt.Print(ansiClearLine + consolePrompt + string(e.buf))
+}
+
+// restore 退出原始模式，调用时需持有 mu
+func (e *lineEditor) restore() {
+	if e.state == nil {
+		return
+	}
+	if err := term.Restore(e.fd, e.state); err != nil {
+		logWarnf("恢复终端模式失败: %v", err)
+	}
+	e.state = nil
+}
+
+// Restore 退出原始模式，关闭程序前调用，避免终端停留在原始模式
+func (e *lineEditor) Restore() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.restore()
+}
+
+// Println 在操作员可能正在输入时输出一行
+// 正在等待输入时先清除提示符行，输出后重新显示提示符和正在输入的内容
+func (e *lineEditor) Println(line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case e.reading && e.state != nil:
+		// 原始模式下换行不会回到行首
+		fmt.Print(ansiClearLine + line + "\r\n")
+		e.redraw()
+	case e.reading:
+		if consoleANSI {
+			line = ansiClearLine + line
+		}
+		fmt.Print(line + "\n" + consolePrompt)
+	default:
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 日志级别，低于当前级别的日志不会写入
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// logLevelNames 配置中的级别名称
+var logLevelNames = map[string]int32{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// levelNames 日志中显示的级别名称
+var levelNames = [...]string{levelDebug: "debug", levelInfo: "info", levelWarn: "warn", levelError: "error"}
+
+// logLevel 当前的日志级别，SIGHUP 重新加载配置时可能在其他 goroutine 中修改
+var logLevel atomic.Int32
+
+// jsonLogs 为 true 时每条日志输出为一行 JSON
+var jsonLogs atomic.Bool
+
+func init() {
+	logLevel.Store(levelInfo)
+}
+
+// setLogFormat 设置日志格式，json 时由 logf 自行输出时间和调用位置
+func setLogFormat(format string) {
+	jsonLogs.Store(format == "json")
+	if format == "json" {
+		log.SetFlags(0)
+	} else {
+		log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+	}
+}
+
+// parseLogLevel 解析配置中的日志级别，留空为 info
+func parseLogLevel(name string) (int32, error) {
+	if name == "" {
+		return levelInfo, nil
+	}
+	level, ok := logLevelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("日志级别无效: %s（可选 debug、info、warn、error）", name)
+	}
+	return level, nil
+}
+
+// setLogLevel 设置日志级别，debug 级别下同时输出 tgbotapi 库自身的日志
+func setLogLevel(level int32) {
+	logLevel.Store(level)
+	if level == levelDebug {
+		tgbotapi.SetLogger(libraryLogger{})
+	} else {
+		tgbotapi.SetLogger(&emptyLogger{})
+	}
+}
+
+// logFields 附加在日志上的结构化字段，为 0 的字段不输出
+type logFields struct {
+	ChatID    int64
+	MessageID int
+}
+
+// jsonLogEntry JSON 格式的一条日志
+type jsonLogEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Caller    string `json:"caller,omitempty"`
+	ChatID    int64  `json:"chat_id,omitempty"`
+	MessageID int    `json:"message_id,omitempty"`
+	Message   string `json:"message"`
+}
+
+// logf 按级别写入日志，调用层级固定为 调用者 -> 包装函数 -> logf，保证显示调用者的位置
+func logf(level int32, fields logFields, format string, args ...interface{}) {
+	if level < logLevel.Load() {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	if !jsonLogs.Load() {
+		log.Output(3, "["+strings.ToUpper(levelNames[level])+"] "+message)
+		return
+	}
+	entry := jsonLogEntry{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		Level:     levelNames[level],
+		ChatID:    fields.ChatID,
+		MessageID: fields.MessageID,
+		Message:   message,
+	}
+	if _, file, line, ok := runtime.Caller(2); ok {
+		entry.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	log.Output(3, string(data))
+}
+
+func logDebugf(format string, args ...interface{}) { logf(levelDebug, logFields{}, format, args...) }
+func logInfof(format string, args ...interface{})  { logf(levelInfo, logFields{}, format, args...) }
+func logWarnf(format string, args ...interface{})  { logf(levelWarn, logFields{}, format, args...) }
+func logErrorf(format string, args ...interface{}) { logf(levelError, logFields{}, format, args...) }
+
+// logWith 返回带聊天ID和消息ID的日志字段，messageID 为 0 表示不涉及具体消息
+func logWith(chatID int64, messageID int) logFields {
+	return logFields{ChatID: chatID, MessageID: messageID}
+}
+
+func (f logFields) Debugf(format string, args ...interface{}) { logf(levelDebug, f, format, args...) }
+func (f logFields) Infof(format string, args ...interface{})  { logf(levelInfo, f, format, args...) }
+func (f logFields) Warnf(format string, args ...interface{})  { logf(levelWarn, f, format, args...) }
+func (f logFields) Errorf(format string, args ...interface{}) { logf(levelError, f, format, args...) }
+
+// redactWriter 写入前去掉 bot token，所有日志都经过它写入文件，调用处不需要自行处理
+type redactWriter struct {
+	w io.Writer
+}
+
+// Write 写入去掉敏感信息后的内容，返回值按原始长度计算，避免 log 包认为写入不完整
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// libraryLogger 把 tgbotapi 的日志以 debug 级别写入日志文件
+type libraryLogger struct{}
+
+func (libraryLogger) Printf(format string, args ...interface{}) {
+	logf(levelDebug, logFields{}, "tgbotapi: "+format, args...)
+}
+
+func (libraryLogger) Println(args ...interface{}) {
+	logf(levelDebug, logFields{}, "tgbotapi: %s", strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+// gzipFile 把 src 压缩为 dst 后删除 src
+// 先写临时文件并同步到磁盘再改名，中途崩溃时 src 保持原样，不会丢失日志
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(src)
+	if fi, err := in.Stat(); err == nil {
+		zw.ModTime = fi.ModTime()
+	}
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if serr := out.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+// captureLog 让所有级别的日志经过 redactWriter 写入缓冲区，测试结束后恢复原来的设置
+func captureLog(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out, flags, level := log.Writer(), log.Flags(), logLevel.Load()
+	log.SetOutput(redactWriter{&buf})
+	setLogFormat(format)
+	logLevel.Store(levelDebug)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+		jsonLogs.Store(false)
+		logLevel.Store(level)
+	})
+	return &buf
+}
+
+func TestRedactWriterHidesToken(t *testing.T) {
+	const token = "123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw"
+	// 与配置不同的 token 也要按格式去掉
+	const other = "987654321:ZZHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw"
+
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			setupTestDB(t)
+			getConfig().Account.Token = token
+			buf := captureLog(t, format)
+
+			err := errors.New(`Post "https://api.telegram.org/bot` + token + `/sendMessage": EOF`)
+			logErrorf("发送消息失败: %v", err)
+			logWith(1, 2).Warnf("token %s", token)
+			libraryLogger{}.Printf("Endpoint: %s", "https://api.telegram.org/bot"+other+"/getMe")
+			libraryLogger{}.Println("token", other)
+
+			got := buf.String()
+			if strings.Contains(got, token) || strings.Contains(got, other) {
+				t.Fatalf("token found in log:\n%s", got)
+			}
+			if n := strings.Count(got, "[REDACTED]"); n != 4 {
+				t.Errorf("log has %d redactions, want 4:\n%s", n, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultLogTailLines = 50  // /log 默认显示的行数
+	maxLogTailLines     = 500 // /log 最多显示的行数
+)
+
+// botTokenPattern 匹配 Telegram bot token 的格式
+var botTokenPattern = regexp.MustCompile(`\d{6,}:[A-Za-z0-9_-]{30,}`)
+
+// tailFile 读取文件最后 n 行
+func tailFile(path string, n int) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// redactSecrets 去掉文本中的 bot token 等敏感信息
+func redactSecrets(text string) string {
+	if token := getConfig().Account.Token; token != "" {
+		text = strings.ReplaceAll(text, token, "[REDACTED]")
+	}
+	return botTokenPattern.ReplaceAllString(text, "[REDACTED]")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTailFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, content string
+		n             int
+		want          []string
+	}{
+		{"fewer lines", "a\nb\n", 5, []string{"a", "b"}},
+		{"exact", "a\nb\nc", 3, []string{"a", "b", "c"}},
+		{"more lines", "a\nb\nc\nd\n", 2, []string{"c", "d"}},
+		{"trailing newlines", "a\nb\n\n\n", 1, []string{"b"}},
+		{"blank lines kept", "a\n\nb\n", 3, []string{"a", "", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".log")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tailFile(path, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("tailFile(%q, %d) = %q, want %q", tt.content, tt.n, got, tt.want)
+			}
+		})
+	}
+	if _, err := tailFile(filepath.Join(dir, "missing.log"), 5); err == nil {
+		t.Error("tailFile returned no error for a missing file")
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	setConfig(&Config{})
+	getConfig().Account.Token = "short-test-token"
+	defer func() { setConfig(&Config{}) }()
+
+	tests := []struct{ in, want string }{
+		{"no secrets here", "no secrets here"},
+		{"token short-test-token in text", "token [REDACTED] in text"},
+		{"https://api.telegram.org/bot123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw/getMe", "https://api.telegram.org/bot[REDACTED]/getMe"},
+		{"chat 123456789:short is not a token", "chat 123456789:short is not a token"},
+	}
+	for _, tt := range tests {
+		if got := redactSecrets(tt.in); got != tt.want {
+			t.Errorf("redactSecrets(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLogCommandChunksAndRedacts(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	const token = "123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw"
+
+	// 600 行，每行约 100 个字符，/log 最多返回 500 行，需要拆成多条消息
+	var lines []string
+	for i := 0; i < 600; i++ {
+		lines = append(lines, fmt.Sprintf("%04d|%s|%s", i, token, strings.Repeat("x", 40)))
+	}
+	if err := os.WriteFile(logPath(), []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	commander(privateMsg(testOwner, 1, "/log 1000"))
+
+	chunks := tg.sentTo(testOwner)
+	if len(chunks) < 2 {
+		t.Fatalf("sent %d messages, want the log split into several", len(chunks))
+	}
+	for i, c := range chunks {
+		if n := utf8.RuneCountInString(c); n > maxMessageLength {
+			t.Errorf("message %d has %d characters, limit %d", i, n, maxMessageLength)
+		}
+		if strings.Contains(c, token) {
+			t.Errorf("message %d contains the bot token", i)
+		}
+	}
+	got := strings.Split(strings.Join(chunks, "\n"), "\n")
+	if len(got) != maxLogTailLines {
+		t.Fatalf("got %d lines, want %d", len(got), maxLogTailLines)
+	}
+	if want := fmt.Sprintf("%04d|[REDACTED]|", 100); !strings.HasPrefix(got[0], want) {
+		t.Errorf("first line = %q, want prefix %q", got[0], want)
+	}
+	if want := fmt.Sprintf("%04d|[REDACTED]|", 599); !strings.HasPrefix(got[len(got)-1], want) {
+		t.Errorf("last line = %q, want prefix %q", got[len(got)-1], want)
+	}
+}
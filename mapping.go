@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// mappingEntry 消息ID映射中的一条记录：对应用户的聊天ID、用户的原始消息ID和写入时间
+type mappingEntry struct {
+	ChatID        int64 `json:"chat_id"`
+	UserMessageID int   `json:"user_message_id,omitempty"` // 用户聊天中对应的原始消息ID，管理员回复时引用该消息，旧数据为 0
+	Timestamp     int64 `json:"timestamp"`                 // 写入时间（unix 秒），旧数据为 0
+}
+
+// parseMapping 解析映射值
+// 存储格式为映射记录的 JSON 数组，同一消息ID在恢复或合并后可能对应多个用户
+// 兼容旧版本的字符串格式 "chatid[:unix[:origid]]"（多个用逗号分隔），由 migrateMapping 在读取时升级
+func parseMapping(v []byte) []mappingEntry {
+	if len(v) == 0 {
+		return nil
+	}
+	if !isLegacyMapping(v) {
+		var entries []mappingEntry
+		if err := json.Unmarshal(v, &entries); err != nil {
+			logWarnf("解析消息映射 %q 失败: %v", v, err)
+			return nil
+		}
+		return entries
+	}
+	var entries []mappingEntry
+	for _, part := range strings.Split(string(v), ",") {
+		var e mappingEntry
+		fields := strings.SplitN(part, ":", 3)
+		id, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || id == 0 {
+			continue
+		}
+		e.ChatID = id
+		if len(fields) >= 2 {
+			e.Timestamp, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+		if len(fields) == 3 {
+			e.UserMessageID, _ = strconv.Atoi(fields[2])
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// isLegacyMapping 判断映射值是否为旧版本的字符串格式
+func isLegacyMapping(v []byte) bool {
+	return len(v) > 0 && v[0] != '['
+}
+
+// encodeMapping 将映射记录编码为存储格式
+func encodeMapping(entries []mappingEntry) []byte {
+	if entries == nil {
+		entries = []mappingEntry{}
+	}
+	data, _ := json.Marshal(entries)
+	return data
+}
+
+// migrateMapping 把 bucket 中 key 对应的旧格式映射值升级为 JSON 格式，已是新格式时不做任何事
+func migrateMapping(bucket []byte, key []byte) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		v := b.Get(key)
+		if !isLegacyMapping(v) {
+			return nil
+		}
+		return b.Put(key, encodeMapping(parseMapping(v)))
+	})
+	if err != nil {
+		logWarnf("升级消息映射 %s 失败: %v", key, err)
+	}
+}
+
+// putMapping 通过当前存储记录转发消息ID对应的用户和用户的原始消息ID，时间为当前时间
+// origID 为 0 表示没有对应的用户消息；msgid 为 0（发送失败）时不记录
+func putMapping(msgid int, chatID int64, origID int) {
+	if msgid == 0 {
+		return
+	}
+	if err := store.PutMapping(msgid, mappingEntry{ChatID: chatID, Timestamp: time.Now().Unix(), UserMessageID: origID}); err != nil {
+		logWith(chatID, msgid).Errorf("记录消息 %d 的映射失败: %v", msgid, err)
+	}
+}
+
+// syncMappings 批量修改 BoltDB 中的映射后让存储后端重新同步，失败时只记录警告
+func syncMappings() {
+	if err := store.SyncMappings(); err != nil {
+		logWarnf("同步消息映射到存储后端失败: %v", err)
+	}
+}
+
+// putMappingAt 在写事务中记录转发消息ID对应的用户
+// 同一用户只保留一条并更新时间，不同用户则追加，由 resolveMapping 按时间选择
+func putMappingAt(tx *bolt.Tx, msgid int, entry mappingEntry) error {
+	b := tx.Bucket(bucketname)
+	key := []byte(strconv.Itoa(msgid))
+	entries := parseMapping(b.Get(key))
+	found := false
+	for i := range entries {
+		if entries[i].ChatID == entry.ChatID {
+			if entry.Timestamp > entries[i].Timestamp {
+				entries[i].Timestamp = entry.Timestamp
+			}
+			if entry.UserMessageID != 0 {
+				entries[i].UserMessageID = entry.UserMessageID
+			}
+			found = true
+		}
+	}
+	if !found {
+		entries = append(entries, entry)
+	}
+	return b.Put(key, encodeMapping(entries))
+}
+
+// resolveMapping 从映射记录中选出最新的一条，返回对应用户的聊天ID
+func resolveMapping(msgid int, entries []mappingEntry) int64 {
+	return latestMapping(msgid, entries).ChatID
+}
+
+// latestMapping 从映射记录中选出最新的一条，存在多个不同用户时记录警告
+func latestMapping(msgid int, entries []mappingEntry) mappingEntry {
+	if len(entries) == 0 {
+		return mappingEntry{}
+	}
+	latest := entries[0]
+	for _, e := range entries[1:] {
+		if e.Timestamp > latest.Timestamp {
+			latest = e
+		}
+	}
+	if len(entries) > 1 {
+		logWarnf("消息 %d 对应多个用户 %v, 使用最新的 %d", msgid, entries, latest.ChatID)
+	}
+	return latest
+}
+
+// mappingStats 返回消息ID映射的条数以及涉及的用户数
+func mappingStats() (entries, chats int) {
+	seen := make(map[int64]bool)
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketname).ForEach(func(k, v []byte) error {
+			entries++
+			for _, e := range parseMapping(v) {
+				seen[e.ChatID] = true
+			}
+			return nil
+		})
+	})
+	return entries, len(seen)
+}
+
+// pruneMappings 删除用户已不在 users bucket 中的映射，返回删除的条数
+func pruneMappings() (removed int, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		b := tx.Bucket(bucketname)
+		// 遍历时删除会打乱游标，先收集再删除
+		var orphans [][]byte
+		b.ForEach(func(k, v []byte) error {
+			orphan := true
+			for _, e := range parseMapping(v) {
+				if users.Get([]byte(strconv.FormatInt(e.ChatID, 10))) != nil {
+					orphan = false
+				}
+			}
+			if orphan {
+				orphans = append(orphans, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range orphans {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(orphans)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if removed > 0 {
+		syncMappings()
+	}
+	logInfof("清理孤立的消息映射 %d 条", removed)
+	return removed, nil
+}
+
+// mappingSweepInterval 自动清理过期映射的间隔
+const mappingSweepInterval = time.Hour
+
+// mappingMaxAge 返回映射的保留时间，默认 30 天
+func mappingMaxAge() time.Duration {
+	if getConfig().Mapping.MaxAgeDays <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(getConfig().Mapping.MaxAgeDays) * 24 * time.Hour
+}
+
+// quoteTarget 返回管理员回复时应引用的用户原消息ID，不引用时返回 0
+// 未开启引用、旧数据没有记录原消息或原消息超过保留天数时都不引用
+func quoteTarget(entry mappingEntry, now time.Time) int {
+	if !getConfig().QuoteReply.Enabled || entry.UserMessageID == 0 || entry.Timestamp == 0 {
+		return 0
+	}
+	maxAge := 7 * 24 * time.Hour
+	if getConfig().QuoteReply.MaxAgeDays > 0 {
+		maxAge = time.Duration(getConfig().QuoteReply.MaxAgeDays) * 24 * time.Hour
+	}
+	if now.Sub(time.Unix(entry.Timestamp, 0)) > maxAge {
+		return 0
+	}
+	return entry.UserMessageID
+}
+
+// sweepMappings 在一个写事务中删除早于 cutoff 的映射记录，返回删除的消息映射条数
+// 旧数据和恢复的记录没有时间：同一消息有带时间的记录时直接丢弃（不影响 resolveMapping 的结果），
+// 否则记为当前时间，之后按正常规则过期
+// 用户原始消息到转发消息的记录（orig2msg）在同一事务中跟随映射过期：转发消息已没有映射时删除
+func sweepMappings(cutoff, now time.Time) (removed int, err error) {
+	changed := false
+	origRemoved := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketname)
+		updates := make(map[string][]mappingEntry)
+		b.ForEach(func(k, v []byte) error {
+			entries := parseMapping(v)
+			dated := false
+			for _, e := range entries {
+				if e.Timestamp != 0 {
+					dated = true
+				}
+			}
+			var kept []mappingEntry
+			changed := false
+			for _, e := range entries {
+				switch {
+				case e.Timestamp == 0 && dated:
+					changed = true
+				case e.Timestamp == 0:
+					e.Timestamp = now.Unix()
+					kept = append(kept, e)
+					changed = true
+				case e.Timestamp < cutoff.Unix():
+					changed = true
+				default:
+					kept = append(kept, e)
+				}
+			}
+			// 旧格式的记录即使没有变化也一并升级
+			if changed || len(entries) == 0 || isLegacyMapping(v) {
+				updates[string(k)] = kept
+			}
+			return nil
+		})
+		// 遍历时修改会打乱游标，先收集再写入
+		changed = len(updates) > 0
+		for k, kept := range updates {
+			if len(kept) == 0 {
+				if err := b.Delete([]byte(k)); err != nil {
+					return err
+				}
+				removed++
+				continue
+			}
+			if err := b.Put([]byte(k), encodeMapping(kept)); err != nil {
+				return err
+			}
+		}
+
+		orig := tx.Bucket(origBucketname)
+		var stale [][]byte
+		orig.ForEach(func(k, v []byte) error {
+			if b.Get(v) == nil {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := orig.Delete(k); err != nil {
+				return err
+			}
+		}
+		origRemoved = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if changed {
+		syncMappings()
+	}
+	if removed > 0 || origRemoved > 0 {
+		logInfof("清理过期的消息映射 %d 条, 原始消息记录 %d 条", removed, origRemoved)
+	}
+	return removed, nil
+}
+
+// mappingSweepLoop 定期清理过期的消息映射
+func mappingSweepLoop() {
+	ticker := time.NewTicker(mappingSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		if _, err := sweepMappings(now.Add(-mappingMaxAge()), now); err != nil {
+			logErrorf("清理过期的消息映射失败: %v", err)
+		}
+		if err := sweepSent(now.Add(-mappingMaxAge())); err != nil {
+			logErrorf("清理过期的已发送消息记录失败: %v", err)
+		}
+	}
+}
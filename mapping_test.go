@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// mappedChats 返回 msgid 对应的全部聊天ID
+func mappedChats(t *testing.T, msgid int) []int64 {
+	t.Helper()
+	entries, err := store.GetMapping(msgid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chats []int64
+	for _, e := range entries {
+		chats = append(chats, e.ChatID)
+	}
+	return chats
+}
+
+func TestPruneMappingsRemovesOnlyOrphans(t *testing.T) {
+	setupTestDB(t)
+	newFakeTelegram(t)
+	touchUser(privateMsg(2840, 1, "hi"))
+
+	putMapping(500, 2840, 1) // 已知用户
+	putMapping(501, 2849, 1) // 不在 users bucket 中
+	putMapping(502, 2849, 2) // 同一条消息对应两个用户，其中一个已知
+	putMapping(502, 2840, 2)
+
+	removed, err := pruneMappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed = %d, want 1", removed)
+	}
+	if got := mappedChats(t, 500); len(got) != 1 || got[0] != 2840 {
+		t.Errorf("mapping 500 = %v, want [2840]", got)
+	}
+	if got := mappedChats(t, 501); len(got) != 0 {
+		t.Errorf("orphan mapping 501 = %v, want removed", got)
+	}
+	if got := mappedChats(t, 502); len(got) != 2 {
+		t.Errorf("mapping 502 = %v, want both entries kept", got)
+	}
+
+	if removed, err := pruneMappings(); err != nil || removed != 0 {
+		t.Errorf("second prune removed %d, %v, want 0", removed, err)
+	}
+}
+
+func TestLatestMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []mappingEntry
+		want    int64
+	}{
+		{"none", nil, 0},
+		{"single", []mappingEntry{{ChatID: 1, Timestamp: 10}}, 1},
+		{"newest last", []mappingEntry{{ChatID: 1, Timestamp: 10}, {ChatID: 2, Timestamp: 20}}, 2},
+		{"newest first", []mappingEntry{{ChatID: 3, Timestamp: 30}, {ChatID: 2, Timestamp: 20}, {ChatID: 1, Timestamp: 10}}, 3},
+		{"legacy without time", []mappingEntry{{ChatID: 1}, {ChatID: 2, Timestamp: 5}}, 2},
+		{"tie keeps first", []mappingEntry{{ChatID: 1, Timestamp: 10}, {ChatID: 2, Timestamp: 10}}, 1},
+	}
+	for _, tt := range tests {
+		if got := latestMapping(1, tt.entries).ChatID; got != tt.want {
+			t.Errorf("%s: latestMapping = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLookupAmbiguousMappingByRecency(t *testing.T) {
+	setupTestDB(t)
+
+	// 恢复或合并备份后，同一消息ID可能对应多个用户
+	for _, e := range []mappingEntry{
+		{ChatID: 2890, Timestamp: 100, UserMessageID: 7},
+		{ChatID: 2891, Timestamp: 300, UserMessageID: 8},
+		{ChatID: 2892, Timestamp: 200, UserMessageID: 9},
+	} {
+		if err := store.PutMapping(600, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := lookupMapping(600); got.ChatID != 2891 || got.UserMessageID != 8 {
+		t.Errorf("lookupMapping = %+v, want chat 2891 message 8", got)
+	}
+
+	// 较早的用户重新写入时成为最新
+	if err := store.PutMapping(600, mappingEntry{ChatID: 2890, Timestamp: 400}); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupMapping(600); got.ChatID != 2890 || got.UserMessageID != 7 {
+		t.Errorf("lookupMapping = %+v, want chat 2890 keeping message 7", got)
+	}
+	// 更旧的时间不会覆盖已有的时间
+	if err := store.PutMapping(600, mappingEntry{ChatID: 2891, Timestamp: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupChatID(600); got != 2890 {
+		t.Errorf("lookupChatID = %d, want 2890", got)
+	}
+}
+
+func TestLookupLegacyMappingByRecency(t *testing.T) {
+	setupTestDB(t)
+	db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketname).Put([]byte("601"), []byte("2893:500:3,2894:100:4,2895"))
+	})
+
+	if got := lookupMapping(601); got.ChatID != 2893 || got.UserMessageID != 3 {
+		t.Errorf("lookupMapping = %+v, want chat 2893 message 3", got)
+	}
+	// 读取后升级为 JSON 格式，结果不变
+	if got := mappedChats(t, 601); len(got) != 3 {
+		t.Fatalf("migrated mapping = %v, want 3 entries", got)
+	}
+	if got := lookupChatID(601); got != 2893 {
+		t.Errorf("lookupChatID after migration = %d, want 2893", got)
+	}
+}
+
+func TestSweepMappingsExpiresOrigRecords(t *testing.T) {
+	setupTestDB(t)
+	now := time.Now()
+	cutoff := now.Add(-24 * time.Hour)
+
+	store.PutMapping(700, mappingEntry{ChatID: 2950, Timestamp: cutoff.Add(-time.Hour).Unix(), UserMessageID: 1})
+	store.PutMapping(701, mappingEntry{ChatID: 2950, Timestamp: now.Unix(), UserMessageID: 2})
+	db.Update(func(tx *bolt.Tx) error {
+		orig := tx.Bucket(origBucketname)
+		orig.Put(origKey(2950, 1), []byte("700"))
+		orig.Put(origKey(2950, 2), []byte("701"))
+		return nil
+	})
+
+	removed, err := sweepMappings(cutoff, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed = %d, want 1", removed)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		orig := tx.Bucket(origBucketname)
+		if v := orig.Get(origKey(2950, 1)); v != nil {
+			t.Errorf("expired orig record still maps to %s", v)
+		}
+		if v := orig.Get(origKey(2950, 2)); string(v) != "701" {
+			t.Errorf("fresh orig record = %q, want 701", v)
+		}
+		return nil
+	})
+}
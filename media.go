@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// errMediaBackupDisabled 未配置媒体备份目录
+var errMediaBackupDisabled = errors.New("媒体备份未启用（media_backup.dir 为空）")
+
+// mediaBackupDir 返回用户的媒体备份目录
+func mediaBackupDir(chatID int64) string {
+	return filepath.Join(getConfig().MediaBackup.Dir, strconv.FormatInt(chatID, 10))
+}
+
+// backupMedia 在后台将用户发来的图片、视频和文件下载到本地备份目录
+// 配置在调用方的 goroutine 中读取，后台下载不再读取配置，避免与重新加载配置竞争
+func backupMedia(msg SimpleMsg) {
+	if getConfig().MediaBackup.Dir == "" {
+		return
+	}
+	fileID, name := msg.FileID, msg.FileName
+	switch {
+	case msg.PhotoID != "":
+		fileID, name = msg.PhotoID, "photo.jpg"
+	case msg.VideoID != "":
+		fileID, name = msg.VideoID, "video.mp4"
+	}
+	if fileID == "" {
+		return
+	}
+	go downloadMedia(msg, fileID, name, mediaBackupDir(msg.ChatId))
+}
+
+// downloadMedia 下载一个媒体文件到 dir
+// Bot API 只能下载 20MB 以内的文件，更大的文件会记录日志后跳过
+func downloadMedia(msg SimpleMsg, fileID, name, dir string) {
+	url, err := getBot().GetFileDirectURL(fileID)
+	if err != nil {
+		logWith(msg.ChatId, msg.MessageID).Errorf("获取 %d 的媒体下载地址失败: %v", msg.ChatId, err)
+		return
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		logWith(msg.ChatId, msg.MessageID).Errorf("下载 %d 的媒体失败: %v", msg.ChatId, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logErrorf("创建媒体备份目录失败: %v", err)
+		return
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d_%s", msg.MessageID, filepath.Base(name)))
+	file, err := os.Create(path)
+	if err != nil {
+		logErrorf("创建媒体备份文件失败: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		logErrorf("写入媒体备份文件失败: %v", err)
+		return
+	}
+	logWith(msg.ChatId, msg.MessageID).Infof("已备份 %d 的媒体到 %s", msg.ChatId, path)
+}
+
+// purgeMedia 删除用户的全部媒体备份，返回删除的文件数和释放的字节数
+func purgeMedia(chatID int64) (files int, freed int64, err error) {
+	if getConfig().MediaBackup.Dir == "" {
+		return 0, 0, errMediaBackupDisabled
+	}
+	dir := mediaBackupDir(chatID)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files++
+			freed += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, 0, err
+	}
+	logInfof("已删除 %d 的媒体备份: %d 个文件, %d 字节", chatID, files, freed)
+	return files, freed, nil
+}
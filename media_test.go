@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeMediaFile 在用户的媒体备份目录中写入 size 字节的文件
+func writeMediaFile(t *testing.T, chatID int64, name string, size int) {
+	t.Helper()
+	path := filepath.Join(mediaBackupDir(chatID), name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeMedia(t *testing.T) {
+	setupTestDB(t)
+	getConfig().MediaBackup.Dir = t.TempDir()
+	writeMediaFile(t, 2900, "1_photo.jpg", 1000)
+	writeMediaFile(t, 2900, "2_video.mp4", 2500)
+	writeMediaFile(t, 2900, "sub/3_doc.pdf", 24)
+	writeMediaFile(t, 2901, "1_photo.jpg", 10)
+
+	files, freed, err := purgeMedia(2900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 3 || freed != 3524 {
+		t.Errorf("purgeMedia = %d files, %d bytes, want 3, 3524", files, freed)
+	}
+	if _, err := os.Stat(mediaBackupDir(2900)); !os.IsNotExist(err) {
+		t.Errorf("backup directory still exists: %v", err)
+	}
+	// 其他用户的备份不受影响
+	if _, err := os.Stat(filepath.Join(mediaBackupDir(2901), "1_photo.jpg")); err != nil {
+		t.Errorf("other user's backup was removed: %v", err)
+	}
+}
+
+func TestPurgeMediaMissingDirectory(t *testing.T) {
+	setupTestDB(t)
+	getConfig().MediaBackup.Dir = t.TempDir()
+
+	files, freed, err := purgeMedia(2902)
+	if err != nil || files != 0 || freed != 0 {
+		t.Errorf("purgeMedia = %d, %d, %v, want 0, 0, nil", files, freed, err)
+	}
+}
+
+func TestPurgeMediaDisabled(t *testing.T) {
+	setupTestDB(t)
+
+	if _, _, err := purgeMedia(2903); !errors.Is(err, errMediaBackupDisabled) {
+		t.Errorf("purgeMedia err = %v, want errMediaBackupDisabled", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// mediaGroupWait 相册最后一条消息到达后等待的时间，超过后整体转发
+const mediaGroupWait = 1500 * time.Millisecond
+
+// mediaGroupMu 保护 mediaGroups
+var mediaGroupMu sync.Mutex
+
+// mediaGroups 按相册ID缓冲的消息
+var mediaGroups = make(map[string]*pendingGroup)
+
+// pendingGroup 一个正在缓冲的相册
+type pendingGroup struct {
+	msgs  []SimpleMsg
+	timer *time.Timer
+}
+
+// bufferMediaGroup 缓冲相册中的消息，相册接收完毕后整体转发给管理员
+func bufferMediaGroup(msg SimpleMsg) {
+	mediaGroupMu.Lock()
+	defer mediaGroupMu.Unlock()
+
+	group, ok := mediaGroups[msg.MediaGroupID]
+	if !ok {
+		group = &pendingGroup{}
+		mediaGroups[msg.MediaGroupID] = group
+		// 计时器在自己的 goroutine 中触发，交给该用户的 worker 转发，与用户的其他消息保持顺序
+		id, chatID := msg.MediaGroupID, msg.ChatId
+		group.timer = time.AfterFunc(mediaGroupWait, func() {
+			runOnWorker(chatID, "转发相册", func() { flushMediaGroup(id) })
+		})
+	} else {
+		group.timer.Reset(mediaGroupWait)
+	}
+	group.msgs = append(group.msgs, msg)
+}
+
+// flushMediaGroup 将缓冲的相册一起发送给管理员并存储所有消息ID映射
+func flushMediaGroup(id string) {
+	mediaGroupMu.Lock()
+	group := mediaGroups[id]
+	delete(mediaGroups, id)
+	mediaGroupMu.Unlock()
+	if group == nil || len(group.msgs) == 0 {
+		return
+	}
+
+	first := group.msgs[0]
+	for _, m := range group.msgs {
+		backupMedia(m)
+	}
+	printIncoming(first.ChatId, first.Name, fmt.Sprintf("album of %d", len(group.msgs)))
+	lastreplyid.Store(first.ChatId)
+	headerid := sendHeader(first)
+
+	msgids := SendMediaGroup(getConfig().Account.Owner, buildMediaGroup(group.msgs))
+	if msgids == nil {
+		// 相册发送失败（例如文件和图片混合），退回逐条转发
+		for _, m := range group.msgs {
+			msgids = append(msgids, RelayMsg(getConfig().Account.Owner, m.ChatId, m.MessageID))
+		}
+	}
+
+	putMapping(headerid, first.ChatId, first.MessageID)
+	for i, msgid := range msgids {
+		origID := first.MessageID
+		if i < len(group.msgs) {
+			origID = group.msgs[i].MessageID
+		}
+		putMapping(msgid, first.ChatId, origID)
+	}
+	logWith(first.ChatId, first.MessageID).Debugf("store chatid %d for album messages %v", first.ChatId, msgids)
+	db.Update(func(tx *bolt.Tx) error {
+		for i, m := range group.msgs {
+			if i < len(msgids) {
+				tx.Bucket(origBucketname).Put(origKey(m.ChatId, m.MessageID), []byte(strconv.Itoa(msgids[i])))
+			}
+			if err := recordStats(tx, directionIn, m.ChatId, time.Now()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	for _, m := range group.msgs {
+		saveTranscript(m.ChatId, newTranscriptEntry(directionIn, m))
+	}
+	logWith(first.ChatId, first.MessageID).Debugf("收到相册来自 %d, 共 %d 条, 消息 id %v", first.ChatId, len(group.msgs), msgids)
+}
+
+// buildMediaGroup 将缓冲的消息转换为相册中的媒体
+func buildMediaGroup(msgs []SimpleMsg) []interface{} {
+	media := make([]interface{}, 0, len(msgs))
+	for _, m := range msgs {
+		switch {
+		case m.PhotoID != "":
+			item := tgbotapi.NewInputMediaPhoto(tgbotapi.FileID(m.PhotoID))
+			item.Caption = m.Caption
+			media = append(media, item)
+		case m.VideoID != "":
+			item := tgbotapi.NewInputMediaVideo(tgbotapi.FileID(m.VideoID))
+			item.Caption = m.Caption
+			media = append(media, item)
+		case m.FileID != "":
+			item := tgbotapi.NewInputMediaDocument(tgbotapi.FileID(m.FileID))
+			item.Caption = m.Caption
+			media = append(media, item)
+		}
+	}
+	return media
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestMediaGroupFlushedOnUserWorker(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+
+	release := make(chan struct{})
+	pool := newUpdatePool(2, func(update tgbotapi.Update) { <-release })
+	currentPool.Store(pool)
+	t.Cleanup(func() { currentPool.Store(nil) })
+
+	// 用户的 worker 正忙时相册等待，处理完之前的更新后再转发
+	pool.dispatch(userUpdate(281200, 2812, 1, "busy"))
+	for i := 2; i <= 3; i++ {
+		msg := privateMsg(2812, i, "")
+		msg.MediaGroupID, msg.PhotoID, msg.ContentType = "album", "photo", "photo"
+		bufferMediaGroup(msg)
+	}
+	time.Sleep(mediaGroupWait + 300*time.Millisecond)
+	if got := tg.callsTo("sendMediaGroup"); len(got) != 0 {
+		t.Fatalf("album flushed while the user's worker was busy: %v", got)
+	}
+
+	close(release)
+	pool.close()
+	if got := tg.callsTo("sendMediaGroup"); len(got) != 1 {
+		t.Errorf("sendMediaGroup called %d times after the worker was free, want 1", len(got))
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// messagesFile 可选的文案配置文件，不存在时使用内置文案
+const messagesFile = "messages.yaml"
+
+// MessageSet 发送给用户的欢迎、帮助和教程文案，均为 MarkdownV2 格式
+type MessageSet struct {
+	Welcome       string // /start 欢迎消息
+	Help          string // /help 帮助信息
+	TokenTutorial string // token 登录教程
+	TwoFaTutorial string // 2FA 登录教程
+}
+
+// messageOverrides messages.yaml 中的一组文案，未填写的项为 nil
+type messageOverrides struct {
+	Welcome       *string `yaml:"welcome"`
+	Help          *string `yaml:"help"`
+	TokenTutorial *string `yaml:"token_tutorial"`
+	TwoFaTutorial *string `yaml:"twofa_tutorial"`
+}
+
+// messagesConfig messages.yaml 的结构：顶层为默认文案，languages 下按语言代码（zh、en、ru 等）覆盖
+type messagesConfig struct {
+	messageOverrides `yaml:",inline"`
+	Languages        map[string]messageOverrides `yaml:"languages"`
+}
+
+// defaultMessages 内置文案
+var defaultMessages = MessageSet{
+	Welcome:       welcomeMsg,
+	Help:          helpMsg,
+	TokenTutorial: tokenTutorial,
+	TwoFaTutorial: twoFaTutorial,
+}
+
+var (
+	messagesMu sync.RWMutex
+	// messageSets 按语言代码存储的文案，空字符串为默认文案
+	messageSets = map[string]MessageSet{"": defaultMessages}
+)
+
+// normalizeLanguage 把 Telegram 的语言代码（如 en-US、zh-hans）归一为主语言代码（en、zh）
+func normalizeLanguage(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	return code
+}
+
+// messagesFor 返回指定语言的文案，没有该语言时返回默认文案
+func messagesFor(lang string) MessageSet {
+	messagesMu.RLock()
+	defer messagesMu.RUnlock()
+	if set, ok := messageSets[normalizeLanguage(lang)]; ok {
+		return set
+	}
+	return messageSets[""]
+}
+
+// apply 用填写了的项覆盖 base，填写了但内容为空白的项视为错误
+func (o messageOverrides) apply(base MessageSet) (MessageSet, error) {
+	fields := []struct {
+		key   string
+		value *string
+		dst   *string
+	}{
+		{"welcome", o.Welcome, &base.Welcome},
+		{"help", o.Help, &base.Help},
+		{"token_tutorial", o.TokenTutorial, &base.TokenTutorial},
+		{"twofa_tutorial", o.TwoFaTutorial, &base.TwoFaTutorial},
+	}
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		if strings.TrimSpace(*f.value) == "" {
+			return base, fmt.Errorf("文案项 %s 内容为空", f.key)
+		}
+		*f.dst = *f.value
+	}
+	return base, nil
+}
+
+// parseMessages 解析文案配置，未填写的项依次使用默认文案和内置文案
+func parseMessages(data []byte) (map[string]MessageSet, error) {
+	var cfg messagesConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	base, err := cfg.messageOverrides.apply(defaultMessages)
+	if err != nil {
+		return nil, err
+	}
+	sets := map[string]MessageSet{"": base}
+	for code, overrides := range cfg.Languages {
+		lang := normalizeLanguage(code)
+		if lang == "" {
+			return nil, fmt.Errorf("语言代码 %q 无效", code)
+		}
+		set, err := overrides.apply(base)
+		if err != nil {
+			return nil, fmt.Errorf("语言 %s: %v", code, err)
+		}
+		sets[lang] = set
+	}
+	return sets, nil
+}
+
+// loadMessages 从 messages.yaml 加载文案，文件不存在时恢复内置文案，出错时保留当前文案
+func loadMessages() error {
+	data, err := os.ReadFile(messagesFile)
+	if os.IsNotExist(err) {
+		messagesMu.Lock()
+		messageSets = map[string]MessageSet{"": defaultMessages}
+		messagesMu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取 %s 失败: %v", messagesFile, err)
+	}
+	sets, err := parseMessages(data)
+	if err != nil {
+		return fmt.Errorf("解析 %s 失败: %v", messagesFile, err)
+	}
+	messagesMu.Lock()
+	messageSets = sets
+	messagesMu.Unlock()
+	logInfof("已加载文案 %s, 共 %d 种语言", messagesFile, len(sets)-1)
+	return nil
+}
+
+// languageNotices 切换语言后用对应语言回复的确认消息
+var languageNotices = map[string]string{
+	"zh": "语言已设置为中文",
+	"en": "Language set to English",
+	"ru": "Язык изменён на русский",
+}
+
+// isLanguageCode 判断是否为两到三个字母的语言代码
+func isLanguageCode(code string) bool {
+	if len(code) < 2 || len(code) > 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// setLanguage 处理 /lang 命令，保存用户指定的语言并用该语言确认
+func setLanguage(msg SimpleMsg, args []string) {
+	if len(args) != 1 {
+		current := userLanguage(msg.ChatId)
+		if current == "" {
+			current = "auto"
+		}
+		SendPlain(msg.ChatId, fmt.Sprintf("当前语言 / Current language: %s\n用法 / Usage: /lang <zh|en|ru|...> 或 /lang auto", current))
+		return
+	}
+	recordUser(msg)
+	if strings.ToLower(args[0]) == "auto" {
+		if err := setLanguageOverride(msg.ChatId, ""); err != nil {
+			logErrorf("清除用户 %d 的语言设置失败: %v", msg.ChatId, err)
+			return
+		}
+		SendPlain(msg.ChatId, "已恢复自动检测语言 / Language detection restored")
+		return
+	}
+	lang := normalizeLanguage(args[0])
+	if !isLanguageCode(lang) {
+		SendPlain(msg.ChatId, "无效的语言代码 / Invalid language code")
+		return
+	}
+	if err := setLanguageOverride(msg.ChatId, lang); err != nil {
+		logErrorf("保存用户 %d 的语言设置失败: %v", msg.ChatId, err)
+		return
+	}
+	logInfof("用户 %d 设置语言为 %s", msg.ChatId, lang)
+	notice, ok := languageNotices[lang]
+	if !ok {
+		notice = fmt.Sprintf("Language set to %s", lang)
+	}
+	SendPlain(msg.ChatId, notice)
+}
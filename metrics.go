@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	messagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "messages_received_total",
+		Help: "收到的用户消息总数",
+	})
+	messagesSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "messages_sent_total",
+		Help: "管理员发给用户的消息总数",
+	})
+	sendErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "send_errors_total",
+		Help: "调用 Telegram 发送接口失败的次数",
+	})
+	activeUsers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "active_users",
+		Help: "已知且未屏蔽机器人的用户数",
+	})
+	panicsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "panics_total",
+		Help: "处理更新时恢复的 panic 次数",
+	})
+	sendLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "send_duration_seconds",
+		Help:    "调用 Telegram 发送接口的耗时",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(messagesReceived, messagesSent, sendErrors, activeUsers, panicsTotal, sendLatency)
+}
+
+// startMetricsServer 在配置的端口上启动 /metrics 服务，端口为 0 时不启动
+func startMetricsServer(port int) {
+	if port == 0 {
+		return
+	}
+	refreshActiveUsers()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	logInfof("启动 metrics 服务, 端口: %d", port)
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+			logErrorf("metrics 服务退出: %v", err)
+		}
+	}()
+}
+
+// refreshActiveUsers 重新计算 active_users
+func refreshActiveUsers() {
+	db.View(func(tx *bolt.Tx) error {
+		inactive := tx.Bucket(inactiveBucket)
+		n := 0
+		tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			if inactive.Get(k) == nil {
+				n++
+			}
+			return nil
+		})
+		activeUsers.Set(float64(n))
+		return nil
+	})
+}
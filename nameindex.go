@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// namesBucket 用户名到聊天ID的索引，键为小写的显示名或 @username，值为逗号分隔的聊天ID
+// 用户发来消息时更新，命令行 @name 据此查找用户
+var namesBucket = []byte("names")
+
+// nameKey 生成索引键，忽略大小写、首尾空白和 @ 前缀
+func nameKey(name string) []byte {
+	return []byte(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "@")))
+}
+
+// parseNameIDs 解析索引中的聊天ID列表
+func parseNameIDs(v []byte) []int64 {
+	var ids []int64
+	for _, s := range strings.Split(string(v), ",") {
+		if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// encodeNameIDs 编码聊天ID列表
+func encodeNameIDs(ids []int64) []byte {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return []byte(strings.Join(parts, ","))
+}
+
+// indexName 在已有的写事务中把名字指向用户
+func indexName(tx *bolt.Tx, name string, chatID int64) error {
+	key := nameKey(name)
+	if len(key) == 0 {
+		return nil
+	}
+	b := tx.Bucket(namesBucket)
+	ids := parseNameIDs(b.Get(key))
+	for _, id := range ids {
+		if id == chatID {
+			return nil
+		}
+	}
+	return b.Put(key, encodeNameIDs(append(ids, chatID)))
+}
+
+// unindexName 在已有的写事务中移除名字到用户的索引，用于用户改名
+func unindexName(tx *bolt.Tx, name string, chatID int64) error {
+	key := nameKey(name)
+	if len(key) == 0 {
+		return nil
+	}
+	b := tx.Bucket(namesBucket)
+	var kept []int64
+	for _, id := range parseNameIDs(b.Get(key)) {
+		if id != chatID {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) == 0 {
+		return b.Delete(key)
+	}
+	return b.Put(key, encodeNameIDs(kept))
+}
+
+// updateNameIndex 用户改名时把索引从旧名字移到新名字
+func updateNameIndex(tx *bolt.Tx, chatID int64, oldName, newName string) error {
+	if string(nameKey(oldName)) == string(nameKey(newName)) {
+		return indexName(tx, newName, chatID)
+	}
+	if err := unindexName(tx, oldName, chatID); err != nil {
+		return err
+	}
+	return indexName(tx, newName, chatID)
+}
+
+// lookupName 查找显示名或 @username 对应的聊天ID
+func lookupName(name string) []int64 {
+	var ids []int64
+	db.View(func(tx *bolt.Tx) error {
+		ids = parseNameIDs(tx.Bucket(namesBucket).Get(nameKey(name)))
+		return nil
+	})
+	return ids
+}
+
+// resolveNameArgs 解析命令行 @name text，显示名可能包含空格，因此从最长的前缀开始匹配
+// 返回匹配到的名字、聊天ID和剩余的消息内容，没有匹配时 ids 为空
+func resolveNameArgs(words []string) (name string, ids []int64, text string) {
+	for n := len(words) - 1; n >= 1; n-- {
+		candidate := strings.Join(words[:n], " ")
+		if ids := lookupName(candidate); len(ids) > 0 {
+			return candidate, ids, strings.Join(words[n:], " ")
+		}
+	}
+	return "", nil, ""
+}
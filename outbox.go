@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// outboxBucket 存储因网络或服务器错误发送失败、等待重发的管理员消息，键为递增序号
+var outboxBucket = []byte("outbox")
+
+// 重发队列的默认值
+const (
+	outboxInterval           = 30 * time.Second // 检查待重发消息的间隔
+	outboxMaxBackoff         = time.Hour        // 两次重试的最大间隔
+	defaultOutboxMaxAttempts = 10
+)
+
+// 待发送消息的类型
+const (
+	outboxText  = "text"
+	outboxPhoto = "photo"
+	outboxVideo = "video"
+	outboxFile  = "file"
+)
+
+// errQueued 发送失败但已加入重发队列，稍后会自动重试
+var errQueued = errors.New("已加入重发队列，稍后自动重试")
+
+// outboxItem 一条待发送的消息
+type outboxItem struct {
+	ID          uint64 `json:"-"`
+	ChatID      int64  `json:"chat_id"`
+	Kind        string `json:"kind"`                 // text、photo、video 或 file
+	Text        string `json:"text,omitempty"`       // 文本内容
+	ParseMode   string `json:"parse_mode,omitempty"` // 文本的解析模式
+	FileID      string `json:"file_id,omitempty"`    // 图片、视频或文件的 file id
+	FileName    string `json:"file_name,omitempty"`  // 文件名，作为文件的说明
+	ReplyTo     int    `json:"reply_to,omitempty"`   // 引用的用户原消息ID，0 表示不引用
+	Attempts    int    `json:"attempts"`             // 已失败的次数
+	NextAttempt int64  `json:"next_attempt"`         // 下次重试的时间（unix 秒）
+	LastError   string `json:"last_error,omitempty"` // 最近一次失败的原因
+	Dead        bool   `json:"dead,omitempty"`       // 超过最大重试次数，不再自动重试
+}
+
+// send 发送这条消息，并记录发出的消息ID以便之后编辑
+func (it outboxItem) send() error {
+	var msgids []int
+	var id int
+	var err error
+	switch it.Kind {
+	case outboxText:
+		msgids, err = sendMsgReply(it.ChatID, it.Text, it.ParseMode, it.ReplyTo)
+	case outboxPhoto:
+		id, err = SendExistingPhoto(it.ChatID, it.FileID, it.ReplyTo)
+	case outboxVideo:
+		id, err = SendExistingVideo(it.ChatID, it.FileID, it.ReplyTo)
+	case outboxFile:
+		id, err = SendExistingFile(it.ChatID, it.FileID, it.FileName, it.ReplyTo)
+	default:
+		return fmt.Errorf("未知的消息类型: %s", it.Kind)
+	}
+	if id != 0 {
+		msgids = append(msgids, id)
+	}
+	recordSent(it.ChatID, msgids)
+	return err
+}
+
+// describe 返回消息内容的简短描述，用于列表和审计记录
+func (it outboxItem) describe() string {
+	if it.Kind == outboxText {
+		return it.Text
+	}
+	return strings.TrimSpace("[" + it.Kind + "] " + it.FileName)
+}
+
+// isTransientError 判断发送错误是否可能在重试后恢复：网络错误、限流和 Telegram 服务器错误
+// 用户屏蔽机器人、消息格式错误等 4xx 错误重试也不会成功
+func isTransientError(err error) bool {
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) {
+		return tgErr.Code == 429 || tgErr.Code >= 500
+	}
+	return err != nil
+}
+
+// outboxMaxAttempts 返回最大重试次数
+func outboxMaxAttempts() int {
+	if getConfig().Outbox.MaxAttempts <= 0 {
+		return defaultOutboxMaxAttempts
+	}
+	return getConfig().Outbox.MaxAttempts
+}
+
+// outboxBackoff 返回第 attempts 次失败后的等待时间，从 30 秒开始每次翻倍，最长 1 小时
+func outboxBackoff(attempts int) time.Duration {
+	wait := outboxInterval
+	for i := 1; i < attempts && wait < outboxMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > outboxMaxBackoff {
+		wait = outboxMaxBackoff
+	}
+	return wait
+}
+
+// outboxKey 把序号编码为按顺序排列的键
+func outboxKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// putOutbox 保存待发送的消息，ID 为 0 时分配新的序号
+func putOutbox(it *outboxItem) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		if it.ID == 0 {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			it.ID = id
+		}
+		data, err := json.Marshal(it)
+		if err != nil {
+			return err
+		}
+		return b.Put(outboxKey(it.ID), data)
+	})
+}
+
+// deleteOutbox 删除待发送的消息
+func deleteOutbox(id uint64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		if b.Get(outboxKey(id)) == nil {
+			return fmt.Errorf("重发队列中没有 %d", id)
+		}
+		return b.Delete(outboxKey(id))
+	})
+}
+
+// listOutbox 按加入顺序返回重发队列中的所有消息
+func listOutbox() []outboxItem {
+	var items []outboxItem
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			var it outboxItem
+			if len(k) == 8 && json.Unmarshal(v, &it) == nil {
+				it.ID = binary.BigEndian.Uint64(k)
+				items = append(items, it)
+			}
+			return nil
+		})
+	})
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// hasPendingOutbox 判断该用户是否还有等待重发的消息，有则新消息也排在后面，保证顺序
+func hasPendingOutbox(chatID int64) bool {
+	for _, it := range listOutbox() {
+		if it.ChatID == chatID && !it.Dead {
+			return true
+		}
+	}
+	return false
+}
+
+// sendOrQueue 发送消息，遇到可恢复的错误时加入重发队列并返回 errQueued
+// 该用户已有等待重发的消息时直接排队，避免后发的消息先到达
+func sendOrQueue(it outboxItem) error {
+	var err error
+	if hasPendingOutbox(it.ChatID) {
+		err = errors.New("之前的消息还在等待重发")
+	} else if err = it.send(); err == nil || !isTransientError(err) {
+		return err
+	}
+	it.Attempts = 1
+	it.LastError = err.Error()
+	it.NextAttempt = time.Now().Add(outboxBackoff(it.Attempts)).Unix()
+	if qerr := putOutbox(&it); qerr != nil {
+		logErrorf("加入重发队列失败: %v", qerr)
+		return err
+	}
+	logWith(it.ChatID, 0).Warnf("发送给 %d 失败, 已加入重发队列 #%d: %v", it.ChatID, it.ID, err)
+	return errQueued
+}
+
+// processOutbox 重发已到时间的消息，超过最大重试次数时标记为放弃并通知管理员
+func processOutbox(now time.Time) {
+	blocked := make(map[int64]bool)
+	for _, it := range listOutbox() {
+		// 同一用户前面的消息还没发出时，后面的消息不能先发
+		if it.Dead || blocked[it.ChatID] {
+			continue
+		}
+		if it.NextAttempt > now.Unix() {
+			blocked[it.ChatID] = true
+			continue
+		}
+		err := it.send()
+		if err == nil {
+			if err := deleteOutbox(it.ID); err != nil {
+				logErrorf("删除重发队列中的 #%d 失败: %v", it.ID, err)
+			}
+			logWith(it.ChatID, 0).Infof("重发队列 #%d 已发送给 %d", it.ID, it.ChatID)
+			continue
+		}
+		blocked[it.ChatID] = true
+		it.Attempts++
+		it.LastError = err.Error()
+		it.NextAttempt = now.Add(outboxBackoff(it.Attempts)).Unix()
+		if !isTransientError(err) || it.Attempts >= outboxMaxAttempts() {
+			it.Dead = true
+		}
+		if err := putOutbox(&it); err != nil {
+			logErrorf("更新重发队列中的 #%d 失败: %v", it.ID, err)
+		}
+		if it.Dead {
+			logWith(it.ChatID, 0).Errorf("发给 %d 的消息 #%d 重试 %d 次仍失败, 已放弃: %v", it.ChatID, it.ID, it.Attempts, err)
+			SendPlain(getConfig().Account.Owner, fmt.Sprintf("发给 %d 的消息重试 %d 次仍失败，已放弃: %v\n可在命令行使用 outbox retry %d 重新发送", it.ChatID, it.Attempts, err, it.ID))
+		}
+	}
+}
+
+// retryOutbox 把放弃的消息重新加入队列，下一轮立即重试
+func retryOutbox(id uint64) error {
+	for _, it := range listOutbox() {
+		if it.ID == id {
+			it.Dead = false
+			it.Attempts = 0
+			it.NextAttempt = 0
+			return putOutbox(&it)
+		}
+	}
+	return fmt.Errorf("重发队列中没有 %d", id)
+}
+
+// outboxLoop 定期重发队列中的消息，机器人重启后继续发送之前未发出的消息
+func outboxLoop() {
+	for {
+		time.Sleep(outboxInterval)
+		if getBot() == nil {
+			continue
+		}
+		processOutbox(time.Now())
+	}
+}
+
+// formatOutboxItem 生成命令行列表中的一行
+func formatOutboxItem(it outboxItem) string {
+	state := fmt.Sprintf("next %s", time.Unix(it.NextAttempt, 0).Format("2006-01-02 15:04:05"))
+	if it.Dead {
+		state = "dead"
+	}
+	return fmt.Sprintf("#%d (%d) attempts %d, %s: %s (%s)", it.ID, it.ChatID, it.Attempts, state, it.describe(), it.LastError)
+}
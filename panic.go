@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// panicAlertInterval 同一种错误两次提醒管理员的最小间隔，期间的错误只记录日志
+const panicAlertInterval = time.Minute
+
+// panicAlert 某一种错误最近一次提醒的时间，以及之后省略提醒的次数
+type panicAlert struct {
+	last       time.Time
+	suppressed int
+}
+
+// panicAlertMu 保护 panicAlerts
+var panicAlertMu sync.Mutex
+
+// panicAlerts 按错误特征（错误内容和发生位置）记录提醒状态
+var panicAlerts = make(map[string]*panicAlert)
+
+// recoverPanic 在 defer 中调用，恢复 panic 并记录，where 说明出错时正在做什么
+// 每次都写日志并计数，同一种错误每分钟最多提醒管理员一次
+func recoverPanic(where string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	hint := panicLocation(string(stack))
+	logErrorf("%s时发生错误: %v (%s)\n%s", where, r, hint, stack)
+	panicsTotal.Inc()
+	db.Update(func(tx *bolt.Tx) error {
+		return recordPanic(tx, time.Now())
+	})
+	// 提醒之后再判断是否需要自动重启，管理员先收到出错原因
+	defer notePanic(time.Now())
+
+	suppressed, ok := claimPanicAlert(fmt.Sprintf("%v@%s", r, hint), time.Now())
+	if !ok {
+		return
+	}
+	text := fmt.Sprintf("%s时出现错误: %v\n位置: %s", where, r, hint)
+	if suppressed > 0 {
+		text += fmt.Sprintf("\n（上次提醒后同样的错误又出现了 %d 次）", suppressed)
+	}
+	SendPlain(getConfig().Account.Owner, text+"\n请查看日志了解详情。")
+}
+
+// claimPanicAlert 判断这种错误现在是否应该提醒管理员，返回上次提醒后省略的次数
+func claimPanicAlert(signature string, now time.Time) (suppressed int, ok bool) {
+	panicAlertMu.Lock()
+	defer panicAlertMu.Unlock()
+	a := panicAlerts[signature]
+	if a == nil {
+		a = &panicAlert{}
+		panicAlerts[signature] = a
+	}
+	if now.Sub(a.last) < panicAlertInterval {
+		a.suppressed++
+		return 0, false
+	}
+	suppressed = a.suppressed
+	a.last, a.suppressed = now, 0
+	return suppressed, true
+}
+
+// panicLocation 从 debug.Stack 的输出中找出触发 panic 的函数和文件行号，如 "main.formatMessage bot.go:812"
+// 栈中每帧占两行（函数、文件），panic 调用之后第一个不属于 runtime 的帧就是出错的位置，找不到时返回 "unknown"
+func panicLocation(stack string) string {
+	lines := strings.Split(stack, "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic(") {
+			start = i + 2
+			break
+		}
+	}
+	if start < 0 {
+		return "unknown"
+	}
+	for i := start; i+1 < len(lines); i += 2 {
+		fn := lines[i]
+		if strings.HasPrefix(fn, "runtime.") {
+			continue
+		}
+		if p := strings.LastIndex(fn, "("); p > 0 {
+			fn = fn[:p]
+		}
+		file := strings.TrimSpace(lines[i+1])
+		if p := strings.LastIndex(file, " +0x"); p > 0 {
+			file = file[:p]
+		}
+		if p := strings.LastIndex(file, "/"); p >= 0 {
+			file = file[p+1:]
+		}
+		return fn + " " + file
+	}
+	return "unknown"
+}
+
+// recordPanic 在已有的写事务中把当天的错误次数加一，日报和 /stats 中显示
+func recordPanic(tx *bolt.Tx, t time.Time) error {
+	day, err := tx.Bucket(statsBucket).CreateBucketIfNotExists([]byte(t.Format("2006-01-02")))
+	if err != nil {
+		return err
+	}
+	return incrCounter(day, "panic")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// 默认的文件名，与早期版本保持一致
+const (
+	defaultDBFile  = "bot.db"
+	defaultLogFile = "bot.log"
+	mapFile        = "bot.map"
+	sqliteFile     = "bot.sqlite"
+	historyFile    = "cli_history"
+)
+
+// dataPath 把相对路径放到 data_dir 下，绝对路径保持不变
+func dataPath(name string) string {
+	if filepath.IsAbs(name) || getConfig().Paths.DataDir == "" {
+		return name
+	}
+	return filepath.Join(getConfig().Paths.DataDir, name)
+}
+
+// dbPath 返回数据库文件路径
+func dbPath() string {
+	if getConfig().Paths.DBPath != "" {
+		return dataPath(getConfig().Paths.DBPath)
+	}
+	return dataPath(defaultDBFile)
+}
+
+// dbLockPath 返回数据库锁文件路径
+func dbLockPath() string {
+	return dbPath() + ".lock"
+}
+
+// logPath 返回日志文件路径，轮转的旧日志为 <log_path>.1、<log_path>.2 ...
+func logPath() string {
+	if getConfig().Paths.LogPath != "" {
+		return dataPath(getConfig().Paths.LogPath)
+	}
+	return dataPath(defaultLogFile)
+}
+
+// sqlitePath 返回 storage.backend 为 sqlite 时的 SQLite 数据库文件路径
+func sqlitePath() string {
+	if getConfig().Storage.SQLitePath != "" {
+		return dataPath(getConfig().Storage.SQLitePath)
+	}
+	return dataPath(sqliteFile)
+}
+
+// historyPath 返回命令行历史文件路径
+func historyPath() string {
+	return dataPath(historyFile)
+}
+
+// mapPath 返回消息ID映射备份文件路径
+func mapPath() string {
+	return dataPath(mapFile)
+}
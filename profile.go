@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// accountAgeHints 按用户ID估计账号注册时间，Telegram 不提供注册时间，ID 大致随时间递增，只能作为参考
+var accountAgeHints = []struct {
+	minID int64
+	hint  string
+}{
+	{7000000000, "约 2024 年以后注册"},
+	{5000000000, "约 2021–2023 年注册"},
+	{1000000000, "约 2020–2021 年注册"},
+	{0, "2020 年以前注册的老账号"},
+}
+
+// accountAgeHint 返回按ID估计的账号注册时间
+func accountAgeHint(chatID int64) string {
+	for _, h := range accountAgeHints {
+		if chatID >= h.minID {
+			return h.hint
+		}
+	}
+	return ""
+}
+
+// claimProfileCard 判断是否需要为用户发送资料卡，并记录到 users bucket 中，每个用户只发送一次
+// 升级前已有对话记录的老用户只记录，不发送
+func claimProfileCard(chatID int64) bool {
+	show := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		key := []byte(strconv.FormatInt(chatID, 10))
+		v := b.Get(key)
+		if v == nil {
+			return nil
+		}
+		var info UserInfo
+		if err := json.Unmarshal(v, &info); err != nil || info.ProfileShown {
+			return err
+		}
+		info.ProfileShown = true
+		if t := tx.Bucket(messagesBucket).Bucket(key); t == nil {
+			show = true
+		} else if k, _ := t.Cursor().First(); k == nil {
+			show = true
+		}
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+	if err != nil {
+		logErrorf("记录用户 %d 的资料卡状态失败: %v", chatID, err)
+		return false
+	}
+	return show
+}
+
+// formatProfileCard 生成新对话的资料卡
+func formatProfileCard(msg SimpleMsg) string {
+	lines := []string{"🆕 新对话"}
+	if name := strings.TrimSpace(msg.Name); name != "" {
+		lines = append(lines, "名字: "+name)
+	}
+	if msg.UserName != "" {
+		lines = append(lines, "用户名: @"+msg.UserName)
+	}
+	lines = append(lines, fmt.Sprintf("ID: %d", msg.ChatId))
+	if lang := normalizeLanguage(msg.LanguageCode); lang != "" {
+		lines = append(lines, "语言: "+lang)
+	}
+	if hint := accountAgeHint(msg.ChatId); hint != "" {
+		lines = append(lines, "账号: "+hint+"（按 ID 估计）")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sendProfileCard 用户第一次有消息转发给管理员时，先发送一张资料卡，帮助区分陌生人和老客户
+func sendProfileCard(msg SimpleMsg) {
+	if !claimProfileCard(msg.ChatId) {
+		return
+	}
+	card := tgbotapi.NewMessage(getConfig().Account.Owner, formatProfileCard(msg))
+	card.DisableNotification = quietRelay()
+	m, err := sendWithRetry(card)
+	if err != nil {
+		logErrorf("发送 %d 的资料卡失败: %v", msg.ChatId, err)
+		return
+	}
+	// 资料卡也记录映射，管理员回复它同样能找到用户
+	putMapping(m.MessageID, msg.ChatId, msg.MessageID)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// quickActionPrefix 快捷操作按钮回调数据的前缀，格式为 qa:<action>:<chatid>[:<模板名>]
+const quickActionPrefix = "qa:"
+
+// 快捷操作类型
+const (
+	quickActionBan      = "ban"      // 拉黑用户
+	quickActionResolve  = "resolve"  // 标记已解决，结束对话并释放名额
+	quickActionTemplate = "template" // 发送模板
+)
+
+// QuickActionConfig 配置中的一个快捷操作按钮
+type QuickActionConfig struct {
+	Label    string `yaml:"label"`    // 按钮文字
+	Action   string `yaml:"action"`   // ban、resolve 或 template
+	Template string `yaml:"template"` // action 为 template 时发送的模板名
+}
+
+// defaultQuickActions 未配置按钮时使用的快捷操作
+var defaultQuickActions = []QuickActionConfig{
+	{Label: "拉黑", Action: quickActionBan},
+	{Label: "已解决", Action: quickActionResolve},
+}
+
+// validateQuickActions 检查快捷操作配置
+func validateQuickActions(actions []QuickActionConfig) error {
+	for i, a := range actions {
+		if a.Label == "" {
+			return fmt.Errorf("第 %d 个快捷操作缺少 label", i+1)
+		}
+		switch a.Action {
+		case quickActionBan, quickActionResolve:
+		case quickActionTemplate:
+			if err := validateTemplateName(a.Template); err != nil {
+				return fmt.Errorf("第 %d 个快捷操作的模板名无效: %v", i+1, err)
+			}
+		default:
+			return fmt.Errorf("第 %d 个快捷操作的 action 无效: %s", i+1, a.Action)
+		}
+	}
+	return nil
+}
+
+// quickActionMarkup 生成附在发送者信息上的快捷操作按钮，未启用时返回 nil
+func quickActionMarkup(chatID int64) *tgbotapi.InlineKeyboardMarkup {
+	if !getConfig().QuickActions.Enabled {
+		return nil
+	}
+	actions := getConfig().QuickActions.Buttons
+	if len(actions) == 0 {
+		actions = defaultQuickActions
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	for _, a := range actions {
+		data := fmt.Sprintf("%s%s:%d", quickActionPrefix, a.Action, chatID)
+		if a.Action == quickActionTemplate {
+			data += ":" + a.Template
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(a.Label, data))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &markup
+}
+
+// parseQuickAction 解析快捷操作的回调数据
+func parseQuickAction(data string) (action string, chatID int64, template string, ok bool) {
+	rest := strings.TrimPrefix(data, quickActionPrefix)
+	if rest == data {
+		return "", 0, "", false
+	}
+	parts := strings.SplitN(rest, ":", 3)
+	if len(parts) < 2 {
+		return "", 0, "", false
+	}
+	chatID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, "", false
+	}
+	if len(parts) == 3 {
+		template = parts[2]
+	}
+	return parts[0], chatID, template, true
+}
+
+// handleQuickAction 执行管理员点击的快捷操作，结果以回调提示的形式显示
+func handleQuickAction(callback *tgbotapi.CallbackQuery) {
+	var result string
+	action, chatID, template, ok := parseQuickAction(callback.Data)
+	switch {
+	case callback.From == nil || !isAdmin(callback.From.ID):
+		result = "该操作仅限管理员使用"
+	case !ok:
+		result = "无效的操作"
+	case action == quickActionBan && !allowed(callback.From.ID, auditBan, chatID):
+		result = permissionDenied
+	case action == quickActionBan:
+		if err := banUser(chatID); err != nil {
+			result = fmt.Sprintf("拉黑失败: %v", err)
+		} else {
+			recordAudit(callback.From.ID, auditBan, chatID, "quick action")
+			result = fmt.Sprintf("已拉黑用户 %d", chatID)
+		}
+	case action == quickActionResolve:
+		if err := resolveTicket(chatID); err != nil {
+			result = err.Error()
+		} else {
+			result = fmt.Sprintf("与 %d 的对话已标记为解决", chatID)
+		}
+	case action == quickActionTemplate:
+		err := sendTemplate(chatID, template)
+		recordAudit(callback.From.ID, auditReply, chatID, auditResult("template "+template, err))
+		if err != nil {
+			result = err.Error()
+		} else {
+			result = fmt.Sprintf("已发送模板 %s 给 %d", template, chatID)
+		}
+	default:
+		result = "未知的操作: " + action
+	}
+	if _, err := getBot().Request(tgbotapi.NewCallback(callback.ID, result)); err != nil {
+		logErrorf("处理回调请求失败: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultGlobalRate Telegram 对单个机器人的全局发送限制约为每秒 30 条
+const defaultGlobalRate = 30
+
+// tokenBucket 简单的令牌桶限流器，容量等于每秒速率
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的令牌数
+	tokens float64
+	last   time.Time
+}
+
+// globalLimiter 所有发送路径（转发、广播、自动回复）共用的全局限流器
+var globalLimiter = newTokenBucket(defaultGlobalRate)
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// SetRate 修改每秒速率，rate <= 0 时使用默认值
+func (tb *tokenBucket) SetRate(rate float64) {
+	if rate <= 0 {
+		rate = defaultGlobalRate
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.rate = rate
+	if tb.tokens > rate {
+		tb.tokens = rate
+	}
+}
+
+// Wait 阻塞直到取得一个令牌
+func (tb *tokenBucket) Wait() {
+	for {
+		tb.mu.Lock()
+		now := time.Now()
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.rate {
+			tb.tokens = tb.rate
+		}
+		tb.last = now
+		if tb.tokens >= 1 {
+			tb.tokens--
+			tb.mu.Unlock()
+			return
+		}
+		wait := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+		tb.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketThrottlesConcurrentWaits(t *testing.T) {
+	const rate, workers, perWorker = 20, 8, 5
+	tb := newTokenBucket(rate)
+
+	// 桶中初始有 rate 个令牌，其余 20 个按每秒 20 个补充，总共约需 1 秒
+	start := time.Now()
+	var done atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				tb.Wait()
+				done.Add(1)
+			}
+		}()
+	}
+
+	// 半秒时最多取得初始的令牌加半秒补充的令牌
+	time.Sleep(500 * time.Millisecond)
+	if n := done.Load(); n > rate+rate/2+1 {
+		t.Errorf("%d waits returned after 0.5s, want at most %d", n, rate+rate/2+1)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if elapsed < 900*time.Millisecond {
+		t.Errorf("%d waits took %v, want about 1s", workers*perWorker, elapsed)
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("%d waits took %v, limiter is too slow", workers*perWorker, elapsed)
+	}
+}
+
+func TestTokenBucketSetRate(t *testing.T) {
+	tb := newTokenBucket(100)
+	tb.SetRate(5)
+
+	// 降低速率后多余的令牌被丢弃，第 6 个需要等待约 0.2 秒
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		tb.Wait()
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("6 waits at 5/s took %v, want about 200ms", elapsed)
+	}
+
+	tb.SetRate(0)
+	tb.mu.Lock()
+	rate := tb.rate
+	tb.mu.Unlock()
+	if rate != defaultGlobalRate {
+		t.Errorf("SetRate(0) rate = %v, want default %v", rate, defaultGlobalRate)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// tgbotapi v5.5.1 不支持 message_reaction 更新，以下类型按 Bot API 文档自行解析
+
+// reactionType 一个回应，type 为 emoji 或 custom_emoji
+type reactionType struct {
+	Type          string `json:"type"`
+	Emoji         string `json:"emoji,omitempty"`
+	CustomEmojiID string `json:"custom_emoji_id,omitempty"`
+}
+
+// String 返回回应的显示文字，自定义表情无法在文本中显示，以 [custom emoji] 代替
+func (r reactionType) String() string {
+	if r.Type == "emoji" {
+		return r.Emoji
+	}
+	return "[" + strings.ReplaceAll(r.Type, "_", " ") + "]"
+}
+
+// messageReactionUpdated 用户修改了对某条消息的回应
+type messageReactionUpdated struct {
+	Chat        tgbotapi.Chat  `json:"chat"`
+	MessageID   int            `json:"message_id"`
+	User        *tgbotapi.User `json:"user,omitempty"`
+	Date        int            `json:"date"`
+	OldReaction []reactionType `json:"old_reaction"`
+	NewReaction []reactionType `json:"new_reaction"`
+}
+
+// rawUpdate tgbotapi.Update 不包含的更新类型
+type rawUpdate struct {
+	MessageReaction *messageReactionUpdated `json:"message_reaction,omitempty"`
+}
+
+// decodeUpdate 解析一条更新，同时取出 tgbotapi 不支持的 message_reaction
+func decodeUpdate(data []byte) (tgbotapi.Update, *messageReactionUpdated, error) {
+	var update tgbotapi.Update
+	if err := json.Unmarshal(data, &update); err != nil {
+		return update, nil, err
+	}
+	var raw rawUpdate
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return update, nil, err
+	}
+	return update, raw.MessageReaction, nil
+}
+
+// isBotSent 判断消息是否为机器人发给该用户的回复
+func isBotSent(chatID int64, msgid int) bool {
+	found := false
+	db.View(func(tx *bolt.Tx) error {
+		for _, e := range parseMapping(tx.Bucket(sentBucket).Get([]byte(strconv.Itoa(msgid)))) {
+			if e.ChatID == chatID {
+				found = true
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// handleReaction 把用户在私聊中新加的回应告诉管理员，取消回应和管理员自己的回应不通知
+func handleReaction(r *messageReactionUpdated) {
+	defer recoverPanic("处理回应")
+
+	chatID := r.Chat.ID
+	if r.Chat.Type != "private" || isAdmin(chatID) || isBanned(chatID) {
+		return
+	}
+	// 只通知新加的回应
+	old := make(map[reactionType]bool)
+	for _, o := range r.OldReaction {
+		old[o] = true
+	}
+	var added []string
+	for _, n := range r.NewReaction {
+		if !old[n] {
+			added = append(added, n.String())
+		}
+	}
+	if len(added) == 0 {
+		logWith(chatID, r.MessageID).Debugf("用户 %d 取消了对消息 %d 的回应", chatID, r.MessageID)
+		return
+	}
+
+	name := strings.TrimSpace(r.Chat.FirstName + " " + r.Chat.LastName)
+	target := fmt.Sprintf("消息 %d", r.MessageID)
+	if isBotSent(chatID, r.MessageID) {
+		target = fmt.Sprintf("你的回复 %d", r.MessageID)
+	}
+	logWith(chatID, r.MessageID).Infof("用户 %d 对消息 %d 回应了 %s", chatID, r.MessageID, strings.Join(added, ""))
+	text := fmt.Sprintf("(%d)%s 对%s回应了 %s", chatID, name, target, strings.Join(added, ""))
+	if err := SendPlain(getConfig().Account.Owner, text); err != nil {
+		logErrorf("通知管理员回应失败: %v", err)
+	}
+}
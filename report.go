@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// statsBucket 按天存储统计计数器，每天一个子 bucket（键为日期）
+// 子 bucket 中 in/out 为收发消息数，hNN 为每小时收到的消息数，u:<chatid> 标记当天联系过的用户，panic 为处理消息出错的次数
+var statsBucket = []byte("stats")
+
+// lastReportKey 记录最后一次发送日报的日期，避免重启后重复发送
+var lastReportKey = []byte("last_report")
+
+// reportCheckInterval 检查是否到了发送日报时间的间隔
+const reportCheckInterval = time.Minute
+
+// incrCounter 将 bucket 中的计数器加一
+func incrCounter(b *bolt.Bucket, key string) error {
+	n, _ := strconv.Atoi(string(b.Get([]byte(key))))
+	return b.Put([]byte(key), []byte(strconv.Itoa(n+1)))
+}
+
+// recordStats 在已有的写事务中更新当天的统计计数器
+func recordStats(tx *bolt.Tx, direction string, chatID int64, t time.Time) error {
+	day, err := tx.Bucket(statsBucket).CreateBucketIfNotExists([]byte(t.Format("2006-01-02")))
+	if err != nil {
+		return err
+	}
+	if direction == directionOut {
+		return incrCounter(day, "out")
+	}
+	if err := incrCounter(day, "in"); err != nil {
+		return err
+	}
+	if err := incrCounter(day, fmt.Sprintf("h%02d", t.Hour())); err != nil {
+		return err
+	}
+	return day.Put([]byte("u:"+strconv.FormatInt(chatID, 10)), []byte{1})
+}
+
+// dailyStats 某一天的统计数据
+type dailyStats struct {
+	Date         string
+	Users        int
+	Incoming     int
+	Outgoing     int
+	BusiestHour  int // -1 表示当天没有收到消息
+	BusiestCount int
+	Panics       int // 处理消息时恢复的 panic 次数
+}
+
+// loadDailyStats 读取某一天的统计数据
+func loadDailyStats(date string) dailyStats {
+	stats := dailyStats{Date: date, BusiestHour: -1}
+	db.View(func(tx *bolt.Tx) error {
+		day := tx.Bucket(statsBucket).Bucket([]byte(date))
+		if day == nil {
+			return nil
+		}
+		return day.ForEach(func(k, v []byte) error {
+			key := string(k)
+			n, _ := strconv.Atoi(string(v))
+			switch {
+			case key == "in":
+				stats.Incoming = n
+			case key == "out":
+				stats.Outgoing = n
+			case key == "panic":
+				stats.Panics = n
+			case strings.HasPrefix(key, "u:"):
+				stats.Users++
+			case strings.HasPrefix(key, "h"):
+				if hour, err := strconv.Atoi(key[1:]); err == nil && n > stats.BusiestCount {
+					stats.BusiestHour = hour
+					stats.BusiestCount = n
+				}
+			}
+			return nil
+		})
+	})
+	return stats
+}
+
+// formatDailyStats 生成发送给管理员的日报内容
+func formatDailyStats(stats dailyStats) string {
+	busiest := "无"
+	if stats.BusiestHour >= 0 {
+		busiest = fmt.Sprintf("%02d:00-%02d:00（%d 条）", stats.BusiestHour, (stats.BusiestHour+1)%24, stats.BusiestCount)
+	}
+	text := fmt.Sprintf("%s 日报\n联系用户数: %d\n收到消息: %d\n发出消息: %d\n最繁忙时段: %s",
+		stats.Date, stats.Users, stats.Incoming, stats.Outgoing, busiest)
+	if stats.Panics > 0 {
+		text += fmt.Sprintf("\n程序错误: %d 次（详见日志）", stats.Panics)
+	}
+	return text
+}
+
+// reportDue 判断现在是否应该发送日报，返回要汇报的日期（前一天）
+func reportDue(now time.Time) (string, bool) {
+	// 格式已在加载配置时校验，这里只需处理未设置的情况
+	reportTime := getConfig().Report.Time
+	if reportTime == "" {
+		reportTime = "09:00"
+	}
+	at, _ := time.Parse("15:04", reportTime)
+	if now.Hour()*60+now.Minute() < at.Hour()*60+at.Minute() {
+		return "", false
+	}
+	today := now.Format("2006-01-02")
+	var last string
+	db.View(func(tx *bolt.Tx) error {
+		last = string(tx.Bucket(statsBucket).Get(lastReportKey))
+		return nil
+	})
+	if last == today {
+		return "", false
+	}
+	return now.AddDate(0, 0, -1).Format("2006-01-02"), true
+}
+
+// reportLoop 每天在配置的时间把前一天的统计发送给管理员
+func reportLoop() {
+	ticker := time.NewTicker(reportCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !getConfig().Report.Enabled {
+			continue
+		}
+		now := time.Now()
+		date, due := reportDue(now)
+		if !due {
+			continue
+		}
+		if err := SendPlain(getConfig().Account.Owner, formatDailyStats(loadDailyStats(date))); err != nil {
+			logErrorf("发送日报失败: %v", err)
+			continue
+		}
+		db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(statsBucket).Put(lastReportKey, []byte(now.Format("2006-01-02")))
+		})
+		logInfof("已发送 %s 的日报", date)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+)
+
+// 管理员角色
+const (
+	roleSuperadmin = "superadmin" // 可以执行所有操作
+	roleOperator   = "operator"   // 可以回复用户，不能广播、拉黑、解除拉黑、置顶或查看日志
+)
+
+// superadminActions 只有 superadmin 可以执行的操作
+var superadminActions = map[string]bool{
+	auditBroadcast: true,
+	auditBan:       true,
+	auditUnban:     true,
+	auditDelete:    true,
+	auditPin:       true,
+	auditUnpin:     true,
+	auditLog:       true,
+}
+
+// permissionDenied 没有权限时回复管理员的提示
+const permissionDenied = "permission denied: 该操作仅限 superadmin"
+
+// AdminConfig 配置中 owner 以外的一个管理员
+type AdminConfig struct {
+	ID   int64  `yaml:"id"`   // 管理员的 Telegram ID
+	Role string `yaml:"role"` // superadmin 或 operator
+}
+
+// validateAdmins 检查管理员配置，ID 不能与 owner 或其他管理员重复
+func validateAdmins(admins []AdminConfig, owner int64) error {
+	seen := map[int64]bool{owner: true}
+	for i, a := range admins {
+		if a.ID <= 0 {
+			return fmt.Errorf("第 %d 个管理员的 id 无效: %d", i+1, a.ID)
+		}
+		if seen[a.ID] {
+			return fmt.Errorf("管理员 %d 重复配置", a.ID)
+		}
+		seen[a.ID] = true
+		if a.Role != roleSuperadmin && a.Role != roleOperator {
+			return fmt.Errorf("管理员 %d 的角色无效: %q（可选 superadmin、operator）", a.ID, a.Role)
+		}
+	}
+	return nil
+}
+
+// adminRole 返回用户的管理员角色，不是管理员时返回空字符串
+// owner 和命令行始终是 superadmin
+func adminRole(id int64) string {
+	if id == actorCLI || id == getConfig().Account.Owner {
+		return roleSuperadmin
+	}
+	for _, a := range getConfig().Admins {
+		if a.ID == id {
+			return a.Role
+		}
+	}
+	return ""
+}
+
+// isAdmin 判断 Telegram 用户是否为管理员
+func isAdmin(id int64) bool {
+	return id != actorCLI && adminRole(id) != ""
+}
+
+// allowed 判断管理员是否有权执行操作，没有权限时把这次尝试记入审计日志
+func allowed(actor int64, action string, chatID int64) bool {
+	switch adminRole(actor) {
+	case roleSuperadmin:
+		return true
+	case roleOperator:
+		if !superadminActions[action] {
+			return true
+		}
+	}
+	logWarnf("管理员 %s 没有权限执行 %s", actorName(actor), action)
+	recordAudit(actor, auditDenied, chatID, action)
+	return false
+}
+
+// adminIDs 返回 owner 和所有管理员的 Telegram ID
+func adminIDs() []int64 {
+	ids := []int64{getConfig().Account.Owner}
+	for _, a := range getConfig().Admins {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// scheduleBucket 存储定时发送的消息，键为递增序号
+var scheduleBucket = []byte("schedule")
+
+// scheduleInterval 检查到期定时消息的间隔
+const scheduleInterval = 15 * time.Second
+
+// scheduledMsg 一条定时消息
+type scheduledMsg struct {
+	ID      uint64     `json:"-"`
+	At      int64      `json:"at"`      // 发送时间（unix 秒）
+	Item    outboxItem `json:"item"`    // 发送的内容，支持文本、图片、视频和文件
+	Created int64      `json:"created"` // 创建时间（unix 秒）
+}
+
+// botLocation 返回配置的时区，定时消息的时间按此时区解析和显示
+func botLocation() *time.Location {
+	if getConfig().location != nil {
+		return getConfig().location
+	}
+	return time.Local
+}
+
+// parseScheduleTime 解析定时消息的发送时间
+// 支持 +30m、+2h 等相对时间，15:04（今天，已过则为明天），以及 2006-01-02T15:04
+func parseScheduleTime(s string, now time.Time) (time.Time, error) {
+	loc := botLocation()
+	now = now.In(loc)
+	if strings.HasPrefix(s, "+") {
+		d, err := time.ParseDuration(s[1:])
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("无效的相对时间: %s", s)
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.ParseInLocation("15:04", s, loc); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05"} {
+		if at, err := time.ParseInLocation(layout, s, loc); err == nil {
+			if !at.After(now) {
+				return time.Time{}, fmt.Errorf("时间 %s 已经过去", s)
+			}
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %s（支持 +30m、15:04、2006-01-02T15:04）", s)
+}
+
+// parseScheduleChatID 解析目标聊天ID，允许与直接发送相同的 *chatid 写法
+func parseScheduleChatID(s string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(s, "*"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("无效的聊天ID: %s", s)
+	}
+	return id, nil
+}
+
+// scheduleMessage 保存定时消息，返回其序号
+func scheduleMessage(at time.Time, item outboxItem) (uint64, error) {
+	if err := checkOutgoing(item.Text); err != nil {
+		return 0, err
+	}
+	s := scheduledMsg{At: at.Unix(), Item: item, Created: time.Now().Unix()}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(scheduleBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		s.ID = id
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return b.Put(outboxKey(id), data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	logWith(item.ChatID, 0).Infof("定时消息 #%d 将于 %s 发送给 %d", s.ID, at.In(botLocation()).Format("2006-01-02 15:04"), item.ChatID)
+	return s.ID, nil
+}
+
+// listScheduled 按发送时间返回所有定时消息
+func listScheduled() []scheduledMsg {
+	var list []scheduledMsg
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(scheduleBucket).ForEach(func(k, v []byte) error {
+			var s scheduledMsg
+			if len(k) == 8 && json.Unmarshal(v, &s) == nil {
+				s.ID = binary.BigEndian.Uint64(k)
+				list = append(list, s)
+			}
+			return nil
+		})
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].At < list[j].At })
+	return list
+}
+
+// cancelScheduled 取消定时消息，返回其目标聊天ID
+func cancelScheduled(id uint64) (int64, error) {
+	var chatID int64
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(scheduleBucket)
+		v := b.Get(outboxKey(id))
+		if v == nil {
+			return fmt.Errorf("没有定时消息 #%d", id)
+		}
+		var s scheduledMsg
+		if json.Unmarshal(v, &s) == nil {
+			chatID = s.Item.ChatID
+		}
+		return b.Delete(outboxKey(id))
+	})
+	return chatID, err
+}
+
+// scheduledDetail 返回定时消息在审计记录中的说明
+func scheduledDetail(id uint64, at time.Time, item outboxItem) string {
+	return fmt.Sprintf("scheduled #%d at %s: %s", id, at.Format("2006-01-02 15:04"), item.describe())
+}
+
+// fireScheduled 发送所有已到时间的定时消息，发送结果通知管理员
+// 先发送再删除，重启前已到期但未发送的消息会在启动后补发
+func fireScheduled(now time.Time) {
+	for _, s := range listScheduled() {
+		if s.At > now.Unix() {
+			break
+		}
+		var err error
+		if s.Item.Kind == outboxText {
+			err = sendText(s.Item.ChatID, s.Item.Text)
+		} else {
+			err = sendOrQueue(s.Item)
+		}
+		if _, derr := cancelScheduled(s.ID); derr != nil {
+			logErrorf("删除已发送的定时消息 #%d 失败: %v", s.ID, derr)
+		}
+		logWith(s.Item.ChatID, 0).Infof("定时消息 #%d 已发送给 %d: %v", s.ID, s.Item.ChatID, err)
+		SendPlain(getConfig().Account.Owner, fmt.Sprintf("定时消息 #%d %s", s.ID, deliveryStatus(s.Item.ChatID, err)))
+	}
+}
+
+// scheduleLoop 定期发送到期的定时消息
+func scheduleLoop() {
+	for {
+		time.Sleep(scheduleInterval)
+		if getBot() == nil {
+			continue
+		}
+		fireScheduled(time.Now())
+	}
+}
+
+// formatScheduled 生成定时消息列表中的一行
+func formatScheduled(s scheduledMsg) string {
+	return fmt.Sprintf("#%d %s (%d) %s", s.ID, time.Unix(s.At, 0).In(botLocation()).Format("2006-01-02 15:04"), s.Item.ChatID, s.Item.describe())
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// sentBucket 存储管理员回复时机器人发给用户的消息ID，键为消息ID，值的格式与消息ID映射相同
+// 不同用户的聊天中消息ID可能相同，因此一个消息ID可能对应多个用户
+var sentBucket = []byte("sent")
+
+var (
+	lastSentMu sync.Mutex
+	// lastSent 每个用户最近一条回复的消息ID，命令行发送成功后显示，便于之后编辑
+	lastSent = make(map[int64]int)
+)
+
+// recordSent 记录机器人发给用户的消息ID
+func recordSent(chatID int64, msgids []int) {
+	if len(msgids) == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sentBucket)
+		for _, id := range msgids {
+			key := []byte(strconv.Itoa(id))
+			entries := parseMapping(b.Get(key))
+			kept := entries[:0]
+			for _, e := range entries {
+				if e.ChatID != chatID {
+					kept = append(kept, e)
+				}
+			}
+			kept = append(kept, mappingEntry{ChatID: chatID, Timestamp: now})
+			if err := b.Put(key, encodeMapping(kept)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logErrorf("记录发给 %d 的消息ID失败: %v", chatID, err)
+	}
+	lastSentMu.Lock()
+	lastSent[chatID] = msgids[len(msgids)-1]
+	lastSentMu.Unlock()
+}
+
+// lastSentID 返回最近一次回复该用户的消息ID，没有时返回 0
+func lastSentID(chatID int64) int {
+	lastSentMu.Lock()
+	defer lastSentMu.Unlock()
+	return lastSent[chatID]
+}
+
+// parseSentRef 解析 <message_id> 或 <chatid>:<message_id>
+func parseSentRef(ref string) (chatID int64, msgid int, ok bool) {
+	if i := strings.IndexByte(ref, ':'); i >= 0 {
+		var err error
+		if chatID, err = strconv.ParseInt(ref[:i], 10, 64); err != nil {
+			return 0, 0, false
+		}
+		ref = ref[i+1:]
+	}
+	msgid, err := strconv.Atoi(ref)
+	if err != nil || msgid <= 0 {
+		return 0, 0, false
+	}
+	return chatID, msgid, true
+}
+
+// resolveSent 查找机器人发出的消息所在的聊天，指定了 chatID 时只检查该用户
+// 多个用户的聊天中都有这个消息ID时要求指定用户
+func resolveSent(chatID int64, msgid int) (int64, error) {
+	var entries []mappingEntry
+	db.View(func(tx *bolt.Tx) error {
+		entries = parseMapping(tx.Bucket(sentBucket).Get([]byte(strconv.Itoa(msgid))))
+		return nil
+	})
+	var chats []string
+	for _, e := range entries {
+		if chatID != 0 && e.ChatID == chatID {
+			return chatID, nil
+		}
+		chats = append(chats, strconv.FormatInt(e.ChatID, 10))
+	}
+	switch {
+	case chatID != 0 || len(entries) == 0:
+		return 0, fmt.Errorf("没有找到机器人发出的消息 %d", msgid)
+	case len(entries) > 1:
+		return 0, fmt.Errorf("消息 %d 在多个用户的聊天中都存在（%s），请使用 <chatid>:%d 指定", msgid, strings.Join(chats, ", "), msgid)
+	}
+	return entries[0].ChatID, nil
+}
+
+// editSent 修改机器人之前发给用户的文本消息
+func editSent(chatID int64, msgid int, text string) error {
+	if err := checkOutgoing(text); err != nil {
+		return err
+	}
+	edit := tgbotapi.NewEditMessageText(chatID, msgid, text)
+	edit.ParseMode = getConfig().ParseMode
+	return withRetry(func() error {
+		_, err := getBot().Request(edit)
+		return err
+	})
+}
+
+// deleteSent 删除机器人之前发给用户的消息，Telegram 只允许删除 48 小时内的消息
+func deleteSent(chatID int64, msgid int) error {
+	err := withRetry(func() error {
+		_, err := getBot().Request(tgbotapi.NewDeleteMessage(chatID, msgid))
+		return err
+	})
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) {
+		switch {
+		case strings.Contains(tgErr.Message, "message can't be deleted"):
+			return fmt.Errorf("消息无法删除，可能已超过 48 小时或没有删除权限: %v", err)
+		case strings.Contains(tgErr.Message, "message to delete not found"):
+			forgetSent(chatID, msgid)
+			return fmt.Errorf("消息不存在，可能已被删除: %v", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	forgetSent(chatID, msgid)
+	return nil
+}
+
+// parsePinArgs 解析 pin/unpin 的参数 <chatid> [message_id]，pin 必须指定消息ID
+func parsePinArgs(args []string, pin bool) (chatID int64, msgid int, ok bool) {
+	if len(args) < 1 || len(args) > 2 || (pin && len(args) != 2) {
+		return 0, 0, false
+	}
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if len(args) == 2 {
+		if msgid, err = strconv.Atoi(args[1]); err != nil || msgid <= 0 {
+			return 0, 0, false
+		}
+	}
+	return chatID, msgid, true
+}
+
+// pinMessage 在用户的聊天中置顶或取消置顶消息，取消置顶时 msgid 为 0 表示最近置顶的消息
+func pinMessage(chatID int64, msgid int, pin bool) error {
+	var c tgbotapi.Chattable = tgbotapi.UnpinChatMessageConfig{ChatID: chatID, MessageID: msgid}
+	if pin {
+		c = tgbotapi.PinChatMessageConfig{ChatID: chatID, MessageID: msgid, DisableNotification: true}
+	}
+	err := withRetry(func() error {
+		_, err := getBot().Request(c)
+		return err
+	})
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) {
+		switch {
+		case strings.Contains(tgErr.Message, "not enough rights"):
+			return fmt.Errorf("没有置顶消息的权限: %v", err)
+		case strings.Contains(tgErr.Message, "message to pin not found"), strings.Contains(tgErr.Message, "message to unpin not found"):
+			return fmt.Errorf("消息 %d 不存在: %v", msgid, err)
+		}
+	}
+	return err
+}
+
+// forgetSent 删除一条已发送消息的记录
+func forgetSent(chatID int64, msgid int) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sentBucket)
+		key := []byte(strconv.Itoa(msgid))
+		var kept []mappingEntry
+		for _, e := range parseMapping(b.Get(key)) {
+			if e.ChatID != chatID {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			return b.Delete(key)
+		}
+		return b.Put(key, encodeMapping(kept))
+	})
+	if err != nil {
+		logErrorf("清除消息 %d 的记录失败: %v", msgid, err)
+	}
+}
+
+// sweepSent 删除早于 cutoff 的消息ID记录
+func sweepSent(cutoff time.Time) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sentBucket)
+		updates := make(map[string][]mappingEntry)
+		b.ForEach(func(k, v []byte) error {
+			entries := parseMapping(v)
+			var kept []mappingEntry
+			for _, e := range entries {
+				if e.Timestamp >= cutoff.Unix() {
+					kept = append(kept, e)
+				}
+			}
+			if len(kept) != len(entries) {
+				updates[string(k)] = kept
+			}
+			return nil
+		})
+		// 遍历时修改会打乱游标，先收集再写入
+		for k, kept := range updates {
+			var err error
+			if len(kept) == 0 {
+				err = b.Delete([]byte(k))
+			} else {
+				err = b.Put([]byte(k), encodeMapping(kept))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// spamBucket 存储被判定为疑似垃圾消息的用户及暂缓转发的消息，键为聊天ID
+var spamBucket = []byte("spam")
+
+// spamPrefix 审核按钮回调数据的前缀，格式为 spam:<approve|ban>:<chatid>
+const spamPrefix = "spam:"
+
+// 反垃圾的默认值
+const (
+	defaultSpamMaxURLs      = 3
+	defaultSpamRepeatCount  = 3
+	defaultSpamRepeatWindow = 10 // 分钟
+)
+
+// 用户的审核状态
+const (
+	spamHeld     = "held"     // 消息暂缓转发，等待管理员审核
+	spamApproved = "approved" // 管理员已放行，之后不再检查
+)
+
+// SpamRecord 用户的审核状态和暂缓转发的消息
+type SpamRecord struct {
+	ChatID  int64       `json:"chat_id"`
+	State   string      `json:"state"`          // held 或 approved
+	Reason  string      `json:"reason"`         // 被拦截的原因
+	Flagged int64       `json:"flagged"`        // 被拦截的时间（unix 秒）
+	Held    []SimpleMsg `json:"held,omitempty"` // 暂缓转发的消息，放行后按顺序转发
+}
+
+var (
+	// spamURLPattern 匹配消息中的链接
+	spamURLPattern = regexp.MustCompile(`(?i)https?://|www\.|t\.me/`)
+	// spamMentionPattern 匹配 @username
+	spamMentionPattern = regexp.MustCompile(`@[A-Za-z][A-Za-z0-9_]{4,}`)
+)
+
+var (
+	recentTextsMu sync.Mutex
+	// recentTexts 每个用户最近发送的文本和时间，用于检测重复刷屏
+	recentTexts = make(map[int64][]recentText)
+)
+
+// recentText 用户最近发送的一条文本
+type recentText struct {
+	text string
+	at   time.Time
+}
+
+// spamReason 检查消息是否疑似垃圾消息，返回原因，正常消息返回空字符串
+// isNew 表示这是用户的第一条消息
+func spamReason(msg SimpleMsg, isNew bool, now time.Time) string {
+	text := strings.TrimSpace(msg.Text + " " + msg.Caption)
+	if text == "" {
+		return ""
+	}
+	maxURLs := getConfig().AntiSpam.MaxURLs
+	if maxURLs <= 0 {
+		maxURLs = defaultSpamMaxURLs
+	}
+	if n := len(spamURLPattern.FindAllString(text, -1)); n > maxURLs {
+		return fmt.Sprintf("包含 %d 个链接", n)
+	}
+	if isNew && getConfig().AntiSpam.FirstMessageLinks && (spamURLPattern.MatchString(text) || spamMentionPattern.MatchString(text)) {
+		return "第一条消息包含链接或 @ 提及"
+	}
+	if n := countRepeats(msg.ChatId, text, now); n >= spamRepeatCount() {
+		return fmt.Sprintf("%d 分钟内重复发送相同内容 %d 次", spamRepeatWindow()/time.Minute, n)
+	}
+	return ""
+}
+
+// spamRepeatCount 返回判定刷屏的重复次数
+func spamRepeatCount() int {
+	if getConfig().AntiSpam.RepeatCount <= 0 {
+		return defaultSpamRepeatCount
+	}
+	return getConfig().AntiSpam.RepeatCount
+}
+
+// spamRepeatWindow 返回检测重复的时间窗口
+func spamRepeatWindow() time.Duration {
+	if getConfig().AntiSpam.RepeatWindow <= 0 {
+		return defaultSpamRepeatWindow * time.Minute
+	}
+	return time.Duration(getConfig().AntiSpam.RepeatWindow) * time.Minute
+}
+
+// countRepeats 记录用户发送的文本，返回时间窗口内相同文本（忽略大小写和空白）出现的次数
+func countRepeats(chatID int64, text string, now time.Time) int {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	cutoff := now.Add(-spamRepeatWindow())
+	recentTextsMu.Lock()
+	defer recentTextsMu.Unlock()
+	var kept []recentText
+	count := 1
+	for _, r := range recentTexts[chatID] {
+		if r.at.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, r)
+		if r.text == text {
+			count++
+		}
+	}
+	recentTexts[chatID] = append(kept, recentText{text: text, at: now})
+	return count
+}
+
+// loadSpamRecord 读取用户的审核状态，不存在时返回 nil
+func loadSpamRecord(tx *bolt.Tx, chatID int64) *SpamRecord {
+	v := tx.Bucket(spamBucket).Get([]byte(strconv.FormatInt(chatID, 10)))
+	if v == nil {
+		return nil
+	}
+	var rec SpamRecord
+	if json.Unmarshal(v, &rec) != nil {
+		return nil
+	}
+	return &rec
+}
+
+// putSpamRecord 保存用户的审核状态
+func putSpamRecord(tx *bolt.Tx, rec *SpamRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(spamBucket).Put([]byte(strconv.FormatInt(rec.ChatID, 10)), data)
+}
+
+// holdSpam 检查用户消息，疑似垃圾消息时暂缓转发并通知管理员审核，返回 true 表示消息已被拦截
+// 已被拦截的用户后续的消息同样暂缓转发，管理员放行过的用户不再检查
+func holdSpam(msg SimpleMsg, isNew bool) bool {
+	if !getConfig().AntiSpam.Enabled {
+		return false
+	}
+	var held, notify bool
+	var reason string
+	err := db.Update(func(tx *bolt.Tx) error {
+		rec := loadSpamRecord(tx, msg.ChatId)
+		if rec != nil && rec.State == spamApproved {
+			return nil
+		}
+		if rec == nil {
+			if reason = spamReason(msg, isNew, time.Now()); reason == "" {
+				return nil
+			}
+			rec = &SpamRecord{ChatID: msg.ChatId, State: spamHeld, Reason: reason, Flagged: time.Now().Unix()}
+			notify = true
+		}
+		held = true
+		rec.Held = append(rec.Held, msg)
+		return putSpamRecord(tx, rec)
+	})
+	if err != nil {
+		logWith(msg.ChatId, msg.MessageID).Errorf("保存 %d 的审核状态失败: %v", msg.ChatId, err)
+		return false
+	}
+	if !held {
+		return false
+	}
+	logWith(msg.ChatId, msg.MessageID).Warnf("暂缓转发 %d 的疑似垃圾消息", msg.ChatId)
+	if notify {
+		notifySpam(msg, reason)
+	}
+	return true
+}
+
+// notifySpam 通知管理员有用户被拦截，附带放行和拉黑按钮
+func notifySpam(msg SimpleMsg, reason string) {
+	text := fmt.Sprintf("疑似垃圾消息，已暂缓转发\n用户: %s @%s (%d)\n原因: %s\n内容: %s", strings.TrimSpace(msg.Name), msg.UserName, msg.ChatId, reason, msgInfo(msg))
+	notice := tgbotapi.NewMessage(getConfig().Account.Owner, text)
+	notice.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("放行", fmt.Sprintf("%sapprove:%d", spamPrefix, msg.ChatId)),
+		tgbotapi.NewInlineKeyboardButtonData("拉黑", fmt.Sprintf("%sban:%d", spamPrefix, msg.ChatId)),
+	))
+	notice.DisableWebPagePreview = true
+	if _, err := sendWithRetry(notice); err != nil {
+		logErrorf("通知管理员审核 %d 失败: %v", msg.ChatId, err)
+	}
+}
+
+// approveSpam 放行用户，暂缓的消息在该用户的 worker 中按顺序重新处理，之后该用户的消息不再检查
+func approveSpam(chatID int64) (int, error) {
+	var held []SimpleMsg
+	err := db.Update(func(tx *bolt.Tx) error {
+		rec := loadSpamRecord(tx, chatID)
+		if rec == nil || rec.State != spamHeld {
+			return fmt.Errorf("用户 %d 没有待审核的消息", chatID)
+		}
+		held = rec.Held
+		rec.State, rec.Held = spamApproved, nil
+		return putSpamRecord(tx, rec)
+	})
+	if err != nil {
+		return 0, err
+	}
+	// 已经标记为放行，relayIncomingMsg 不会再次暂缓这些消息
+	runOnWorker(chatID, "放行暂缓消息", func() {
+		for _, msg := range held {
+			relayIncomingMsg(msg, false)
+		}
+	})
+	logInfof("放行用户 %d, 处理 %d 条暂缓的消息", chatID, len(held))
+	return len(held), nil
+}
+
+// rejectSpam 拉黑用户并丢弃暂缓的消息
+func rejectSpam(chatID int64) error {
+	if err := banUser(chatID); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(spamBucket).Delete([]byte(strconv.FormatInt(chatID, 10)))
+	})
+}
+
+// heldSpam 返回所有等待审核的用户，用于命令行查看
+func heldSpam() []SpamRecord {
+	var records []SpamRecord
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(spamBucket).ForEach(func(k, v []byte) error {
+			var rec SpamRecord
+			if json.Unmarshal(v, &rec) == nil && rec.State == spamHeld {
+				records = append(records, rec)
+			}
+			return nil
+		})
+	})
+	return records
+}
+
+// handleSpamCallback 处理管理员点击的放行或拉黑按钮，结果以回调提示的形式显示
+func handleSpamCallback(callback *tgbotapi.CallbackQuery) {
+	var result string
+	parts := strings.SplitN(strings.TrimPrefix(callback.Data, spamPrefix), ":", 2)
+	var chatID int64
+	var err error
+	if len(parts) == 2 {
+		chatID, err = strconv.ParseInt(parts[1], 10, 64)
+	}
+	switch {
+	case callback.From == nil || !isAdmin(callback.From.ID):
+		result = "该操作仅限管理员使用"
+	case len(parts) != 2 || err != nil:
+		result = "无效的操作"
+	case parts[0] == "ban" && !allowed(callback.From.ID, auditBan, chatID):
+		result = permissionDenied
+	case parts[0] == "approve":
+		if n, err := approveSpam(chatID); err != nil {
+			result = err.Error()
+		} else {
+			result = fmt.Sprintf("已放行 %d, 转发 %d 条消息", chatID, n)
+		}
+	case parts[0] == "ban":
+		if err := rejectSpam(chatID); err != nil {
+			result = fmt.Sprintf("拉黑失败: %v", err)
+		} else {
+			recordAudit(callback.From.ID, auditBan, chatID, "spam")
+			result = fmt.Sprintf("已拉黑用户 %d", chatID)
+		}
+	default:
+		result = "未知的操作: " + parts[0]
+	}
+	if _, err := getBot().Request(tgbotapi.NewCallback(callback.ID, result)); err != nil {
+		logErrorf("处理回调请求失败: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestApproveSpamRelaysOnUserWorker(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	getConfig().AntiSpam.Enabled = true
+	getConfig().AntiSpam.FirstMessageLinks = true
+	getConfig().AutoReply = []AutoReplyRule{{Keywords: []string{"price"}, Reply: "see the price list", Forward: true}}
+
+	pool := newUpdatePool(2, handleUpdate)
+	currentPool.Store(pool)
+	t.Cleanup(func() { currentPool.Store(nil) })
+
+	pool.dispatch(userUpdate(324200, 3242, 1, "price? https://example.com"))
+	pool.dispatch(userUpdate(324201, 3242, 2, "hello?"))
+
+	// 管理员在自己的 worker 中放行，暂缓的消息交给用户的 worker 处理
+	approved := make(chan int)
+	pool.run(testOwner, "测试", func() {
+		n, err := approveSpam(3242)
+		if err != nil {
+			t.Error(err)
+		}
+		approved <- n
+	})
+	if n := <-approved; n != 2 {
+		t.Fatalf("approveSpam released %d messages, want 2", n)
+	}
+	pool.close()
+
+	forwards := tg.callsTo("forwardMessage")
+	if len(forwards) != 2 || forwards[0].Params.Get("message_id") != "1" || forwards[1].Params.Get("message_id") != "2" {
+		t.Errorf("forwards after approval = %v, want messages 1 and 2 in order", forwards)
+	}
+	// 放行的消息与平时一样经过自动回复
+	if got := tg.sentTo(3242); len(got) != 1 || got[0] != "see the price list" {
+		t.Errorf("auto-replies after approval = %q", got)
+	}
+}
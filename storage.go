@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// 存储后端
+const (
+	storageBolt   = "bolt"
+	storageSQLite = "sqlite"
+)
+
+// Storage 消息映射、对话记录、用户列表和拉黑名单的存储
+// BoltDB 始终是主存储，所有读取都来自 BoltDB；sqliteStorage 在此基础上把这几类数据的写入镜像到 SQLite，便于用 SQL 做报表
+// 其他数据（orig2msg、统计、工单、重发队列等）只在 BoltDB 中
+type Storage interface {
+	PutMapping(msgid int, entry mappingEntry) error                // 记录转发给管理员的消息对应的用户
+	GetMapping(msgid int) ([]mappingEntry, error)                  // 读取转发给管理员的消息对应的全部映射记录
+	AppendTranscript(chatID int64, entry TranscriptEntry) error    // 追加一条对话记录
+	LoadTranscript(chatID int64, n int) ([]TranscriptEntry, error) // 读取最近 n 条对话记录，按时间正序
+	ListUsers() ([]UserInfo, error)                                // 列出所有联系过机器人的用户
+	SetBanned(chatID int64, banned bool) error                     // 拉黑或解除拉黑用户
+	IsBanned(chatID int64) (bool, error)                           // 判断用户是否被拉黑
+	SyncMappings() error                                           // 直接修改了 BoltDB 中的映射（过期清理、孤立清理、恢复备份）后重新同步全部映射
+	SyncUser(chatID int64) error                                   // 修改了 BoltDB 中某个用户的信息或标记后同步该用户
+	SyncUsers() error                                              // 批量修改 BoltDB 中的用户（导入）后重新同步全部用户
+	Close() error
+}
+
+// store 当前使用的存储，由 openStorage 在 initDB 之后设置
+var store Storage = boltStorage{}
+
+// openStorage 按配置打开存储后端，BoltDB 必须已经打开
+func openStorage() (Storage, error) {
+	switch getConfig().Storage.Backend {
+	case "", storageBolt:
+		return boltStorage{}, nil
+	case storageSQLite:
+		return openSQLiteStorage(sqlitePath())
+	default:
+		return nil, fmt.Errorf("未知的存储后端: %s", getConfig().Storage.Backend)
+	}
+}
+
+// boltStorage 使用全局 BoltDB 实例的存储
+type boltStorage struct{}
+
+func (boltStorage) PutMapping(msgid int, entry mappingEntry) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putMappingAt(tx, msgid, entry)
+	})
+}
+
+// GetMapping 读到旧版本的字符串格式时顺便升级为 JSON 格式
+func (boltStorage) GetMapping(msgid int) ([]mappingEntry, error) {
+	key := []byte(strconv.Itoa(msgid))
+	var entries []mappingEntry
+	legacy := false
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketname).Get(key)
+		entries, legacy = parseMapping(v), isLegacyMapping(v)
+		return nil
+	})
+	if err == nil && legacy {
+		migrateMapping(bucketname, key)
+	}
+	return entries, err
+}
+
+func (boltStorage) AppendTranscript(chatID int64, entry TranscriptEntry) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return appendTranscript(tx, chatID, entry)
+	})
+}
+
+func (boltStorage) LoadTranscript(chatID int64, n int) ([]TranscriptEntry, error) {
+	var entries []TranscriptEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket).Bucket([]byte(strconv.FormatInt(chatID, 10)))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && (n <= 0 || len(entries) < n); k, v = c.Prev() {
+			var entry TranscriptEntry
+			if json.Unmarshal(v, &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	// 游标是倒序读取的，翻转为正序
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, err
+}
+
+func (boltStorage) ListUsers() ([]UserInfo, error) {
+	var users []UserInfo
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var info UserInfo
+			if json.Unmarshal(v, &info) != nil {
+				return nil
+			}
+			// 早期版本的用户信息可能没有 chat_id，以键为准
+			if id, err := strconv.ParseInt(string(k), 10, 64); err == nil {
+				info.ChatID = id
+			}
+			users = append(users, info)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (boltStorage) SetBanned(chatID int64, banned bool) error {
+	return setUserFlag(bannedBucket, chatID, banned)
+}
+
+func (boltStorage) IsBanned(chatID int64) (bool, error) {
+	return hasUserFlag(bannedBucket, chatID), nil
+}
+
+// SyncMappings 映射只在 BoltDB 中，不需要同步
+func (boltStorage) SyncMappings() error {
+	return nil
+}
+
+// SyncUser 用户只在 BoltDB 中，不需要同步
+func (boltStorage) SyncUser(chatID int64) error {
+	return nil
+}
+
+// SyncUsers 用户只在 BoltDB 中，不需要同步
+func (boltStorage) SyncUsers() error {
+	return nil
+}
+
+// Close BoltDB 由 cleanup 关闭，这里不做任何事
+func (boltStorage) Close() error {
+	return nil
+}
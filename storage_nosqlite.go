@@ -0,0 +1,10 @@
+//go:build !sqlite
+
+package main
+
+import "errors"
+
+// openSQLiteStorage 没有使用 sqlite 构建标签编译时不包含 SQLite 驱动，默认的 BoltDB 版本不需要额外依赖
+func openSQLiteStorage(path string) (Storage, error) {
+	return nil, errors.New("当前程序编译时没有启用 SQLite，请使用 go build -tags sqlite 重新编译")
+}
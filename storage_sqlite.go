@@ -0,0 +1,359 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema SQLite 中的表结构，与 BoltDB 中的数据一一对应
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS mappings (
+	msgid           INTEGER NOT NULL,
+	chat_id         INTEGER NOT NULL,
+	user_message_id INTEGER NOT NULL DEFAULT 0,
+	timestamp       INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (msgid, chat_id)
+);
+CREATE TABLE IF NOT EXISTS transcripts (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id   INTEGER NOT NULL,
+	time      INTEGER NOT NULL,
+	direction TEXT NOT NULL,
+	type      TEXT NOT NULL,
+	text      TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS transcripts_chat ON transcripts (chat_id, id);
+CREATE TABLE IF NOT EXISTS banned (
+	chat_id INTEGER PRIMARY KEY,
+	time    INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS users (
+	chat_id    INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	username   TEXT NOT NULL,
+	first_seen INTEGER NOT NULL,
+	last_seen  INTEGER NOT NULL,
+	language   TEXT NOT NULL,
+	optout     INTEGER NOT NULL DEFAULT 0,
+	subscribed INTEGER NOT NULL DEFAULT 0,
+	inactive   INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// sqliteSchemaVersion 记录在 PRAGMA user_version 中，为 0 表示还没有从 BoltDB 导入已有数据
+// 版本 2 增加了 users 表，从版本 1 升级时只导入用户
+const sqliteSchemaVersion = 2
+
+// sqliteStorage 是 BoltDB 的只写镜像：映射、对话记录、用户和拉黑名单写入 BoltDB 后同步写入 SQLite
+// 所有读取仍来自 BoltDB，其他数据（orig2msg、统计、工单、重发队列等）只在 BoltDB 中；
+// BoltDB 的写入失败以 BoltDB 为准，SQLite 写入失败只记录警告，不影响消息转发
+type sqliteStorage struct {
+	boltStorage
+	sql *sql.DB
+}
+
+// openSQLiteStorage 打开 SQLite 数据库并创建表，第一次使用时导入 BoltDB 中已有的数据
+func openSQLiteStorage(path string) (Storage, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("创建数据目录失败: %v", err)
+		}
+	}
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("打开 SQLite 数据库失败: %v", err)
+	}
+	// 同一时间只有一个写入者，避免 database is locked
+	conn.SetMaxOpenConns(1)
+	if _, err := conn.Exec(sqliteSchema); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("创建 SQLite 表失败: %v", err)
+	}
+	s := &sqliteStorage{sql: conn}
+	var version int
+	if err := conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("读取 SQLite 版本失败: %v", err)
+	}
+	switch version {
+	case 0:
+		if err := s.importBolt(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("从 BoltDB 导入数据失败: %v", err)
+		}
+	case 1:
+		if err := s.SyncUsers(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("从 BoltDB 导入用户失败: %v", err)
+		}
+		if _, err := conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("更新 SQLite 版本失败: %v", err)
+		}
+	}
+	logInfof("使用 SQLite 存储: %s", path)
+	return s, nil
+}
+
+// importBolt 在一个 SQLite 事务中导入 BoltDB 中已有的映射、对话记录、用户和拉黑名单
+func (s *sqliteStorage) importBolt() error {
+	tx, err := s.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var mappings, transcripts, users int
+	err = db.View(func(btx *bolt.Tx) error {
+		var err error
+		if mappings, err = copyMappings(tx, btx); err != nil {
+			return err
+		}
+		if users, err = copyUsers(tx, btx); err != nil {
+			return err
+		}
+		err = btx.Bucket(messagesBucket).ForEach(func(k, v []byte) error {
+			chatID, err := strconv.ParseInt(string(k), 10, 64)
+			b := btx.Bucket(messagesBucket).Bucket(k)
+			if err != nil || b == nil {
+				return nil
+			}
+			return b.ForEach(func(_, v []byte) error {
+				var entry TranscriptEntry
+				if json.Unmarshal(v, &entry) != nil {
+					return nil
+				}
+				transcripts++
+				return insertTranscript(tx, chatID, entry)
+			})
+		})
+		if err != nil {
+			return err
+		}
+		return btx.Bucket(bannedBucket).ForEach(func(k, v []byte) error {
+			chatID, err := strconv.ParseInt(string(k), 10, 64)
+			if err != nil {
+				return nil
+			}
+			ts, _ := strconv.ParseInt(string(v), 10, 64)
+			_, err = tx.Exec("INSERT OR REPLACE INTO banned (chat_id, time) VALUES (?, ?)", chatID, ts)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logInfof("从 BoltDB 导入 %d 条映射、%d 条对话记录和 %d 个用户到 SQLite", mappings, transcripts, users)
+	return nil
+}
+
+// copyMappings 把 BoltDB 中的全部映射写入 SQLite，返回写入的记录数
+func copyMappings(tx *sql.Tx, btx *bolt.Tx) (int, error) {
+	n := 0
+	err := btx.Bucket(bucketname).ForEach(func(k, v []byte) error {
+		msgid, err := strconv.Atoi(string(k))
+		if err != nil {
+			return nil
+		}
+		for _, e := range parseMapping(v) {
+			if err := upsertMapping(tx, msgid, e); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// upsertMapping 写入一条映射记录，同一消息和用户只保留一条，规则与 putMappingAt 相同
+func upsertMapping(tx *sql.Tx, msgid int, e mappingEntry) error {
+	_, err := tx.Exec(`INSERT INTO mappings (msgid, chat_id, user_message_id, timestamp) VALUES (?, ?, ?, ?)
+		ON CONFLICT (msgid, chat_id) DO UPDATE SET
+			timestamp = MAX(timestamp, excluded.timestamp),
+			user_message_id = CASE WHEN excluded.user_message_id != 0 THEN excluded.user_message_id ELSE user_message_id END`,
+		msgid, e.ChatID, e.UserMessageID, e.Timestamp)
+	return err
+}
+
+// copyUsers 把 BoltDB 中的全部用户写入 SQLite，返回写入的用户数
+func copyUsers(tx *sql.Tx, btx *bolt.Tx) (int, error) {
+	n := 0
+	err := btx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+		chatID, err := strconv.ParseInt(string(k), 10, 64)
+		if err != nil {
+			return nil
+		}
+		n++
+		return upsertUser(tx, btx, chatID)
+	})
+	return n, err
+}
+
+// upsertUser 按 BoltDB 中的用户信息、语言设置和广播标记写入一个用户，BoltDB 中没有该用户时删除
+func upsertUser(tx *sql.Tx, btx *bolt.Tx, chatID int64) error {
+	key := []byte(strconv.FormatInt(chatID, 10))
+	v := btx.Bucket(usersBucket).Get(key)
+	if v == nil {
+		_, err := tx.Exec("DELETE FROM users WHERE chat_id = ?", chatID)
+		return err
+	}
+	var info UserInfo
+	if err := json.Unmarshal(v, &info); err != nil {
+		return nil
+	}
+	lang := info.Language
+	if override := btx.Bucket(languageBucket).Get(key); override != nil {
+		lang = string(override)
+	}
+	flag := func(bucket []byte) bool { return btx.Bucket(bucket).Get(key) != nil }
+	_, err := tx.Exec(`INSERT OR REPLACE INTO users (chat_id, name, username, first_seen, last_seen, language, optout, subscribed, inactive)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		chatID, info.Name, info.UserName, info.FirstSeen, info.LastSeen, lang,
+		flag(optoutBucket), flag(subscribedBucket), flag(inactiveBucket))
+	return err
+}
+
+// insertTranscript 追加一条对话记录
+func insertTranscript(tx *sql.Tx, chatID int64, entry TranscriptEntry) error {
+	_, err := tx.Exec("INSERT INTO transcripts (chat_id, time, direction, type, text) VALUES (?, ?, ?, ?, ?)",
+		chatID, entry.Time, entry.Direction, entry.Type, entry.Text)
+	return err
+}
+
+// mirror 在 SQLite 事务中执行 fn，失败时只记录警告
+func (s *sqliteStorage) mirror(what string, fn func(tx *sql.Tx) error) {
+	tx, err := s.sql.Begin()
+	if err == nil {
+		if err = fn(tx); err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err != nil {
+		logWarnf("同步%s到 SQLite 失败: %v", what, err)
+	}
+}
+
+func (s *sqliteStorage) PutMapping(msgid int, entry mappingEntry) error {
+	if err := s.boltStorage.PutMapping(msgid, entry); err != nil {
+		return err
+	}
+	s.mirror("消息映射", func(tx *sql.Tx) error {
+		return upsertMapping(tx, msgid, entry)
+	})
+	return nil
+}
+
+func (s *sqliteStorage) AppendTranscript(chatID int64, entry TranscriptEntry) error {
+	if err := s.boltStorage.AppendTranscript(chatID, entry); err != nil {
+		return err
+	}
+	s.mirror("对话记录", func(tx *sql.Tx) error {
+		return insertTranscript(tx, chatID, entry)
+	})
+	return nil
+}
+
+func (s *sqliteStorage) SetBanned(chatID int64, banned bool) error {
+	if err := s.boltStorage.SetBanned(chatID, banned); err != nil {
+		return err
+	}
+	s.mirror("拉黑名单", func(tx *sql.Tx) error {
+		var err error
+		if banned {
+			_, err = tx.Exec("INSERT OR REPLACE INTO banned (chat_id, time) VALUES (?, ?)", chatID, time.Now().Unix())
+		} else {
+			_, err = tx.Exec("DELETE FROM banned WHERE chat_id = ?", chatID)
+		}
+		return err
+	})
+	return nil
+}
+
+// SyncMappings 清空 SQLite 中的映射表并从 BoltDB 重新导入，过期、孤立和恢复的映射都与 BoltDB 保持一致
+func (s *sqliteStorage) SyncMappings() error {
+	tx, err := s.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM mappings"); err != nil {
+		return err
+	}
+	var n int
+	err = db.View(func(btx *bolt.Tx) error {
+		n, err = copyMappings(tx, btx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logDebugf("重新同步 %d 条映射到 SQLite", n)
+	return nil
+}
+
+// SyncUser 按 BoltDB 中的当前状态重写一个用户
+func (s *sqliteStorage) SyncUser(chatID int64) error {
+	tx, err := s.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = db.View(func(btx *bolt.Tx) error {
+		return upsertUser(tx, btx, chatID)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SyncUsers 清空 SQLite 中的用户表并从 BoltDB 重新导入
+func (s *sqliteStorage) SyncUsers() error {
+	tx, err := s.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM users"); err != nil {
+		return err
+	}
+	var n int
+	err = db.View(func(btx *bolt.Tx) error {
+		n, err = copyUsers(tx, btx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logDebugf("重新同步 %d 个用户到 SQLite", n)
+	return nil
+}
+
+// Close 关闭 SQLite 数据库
+func (s *sqliteStorage) Close() error {
+	return s.sql.Close()
+}
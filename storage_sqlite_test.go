@@ -0,0 +1,163 @@
+//go:build sqlite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupSQLiteStorage 在 setupTestDB 的基础上改用 SQLite 存储
+func setupSQLiteStorage(t *testing.T) *sqliteStorage {
+	t.Helper()
+	setupTestDB(t)
+	s, err := openSQLiteStorage(filepath.Join(getConfig().Paths.DataDir, sqliteFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = s
+	t.Cleanup(func() { s.Close() })
+	return s.(*sqliteStorage)
+}
+
+// sqliteMappings 返回 SQLite 中的映射，键为转发消息ID
+func sqliteMappings(t *testing.T, s *sqliteStorage) map[int]int64 {
+	t.Helper()
+	rows, err := s.sql.Query("SELECT msgid, chat_id FROM mappings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	m := make(map[int]int64)
+	for rows.Next() {
+		var msgid int
+		var chatID int64
+		if err := rows.Scan(&msgid, &chatID); err != nil {
+			t.Fatal(err)
+		}
+		m[msgid] = chatID
+	}
+	return m
+}
+
+func TestSQLiteMappingsFollowSweep(t *testing.T) {
+	s := setupSQLiteStorage(t)
+	now := time.Now()
+	store.PutMapping(1, mappingEntry{ChatID: 61, Timestamp: now.Add(-40 * 24 * time.Hour).Unix()})
+	store.PutMapping(2, mappingEntry{ChatID: 62, Timestamp: now.Unix()})
+
+	if _, err := sweepMappings(now.Add(-30*24*time.Hour), now); err != nil {
+		t.Fatal(err)
+	}
+	if got := sqliteMappings(t, s); len(got) != 1 || got[2] != 62 {
+		t.Errorf("SQLite mappings after sweep = %v, want only 2 -> 62", got)
+	}
+}
+
+func TestSQLiteMappingsFollowPruneAndRestore(t *testing.T) {
+	s := setupSQLiteStorage(t)
+	touchUser(privateMsg(71, 1, "hi"))
+	store.PutMapping(1, mappingEntry{ChatID: 71, Timestamp: time.Now().Unix()})
+	store.PutMapping(2, mappingEntry{ChatID: 72, Timestamp: time.Now().Unix()})
+
+	if _, err := pruneMappings(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sqliteMappings(t, s); len(got) != 1 || got[1] != 71 {
+		t.Errorf("SQLite mappings after prune = %v, want only 1 -> 71", got)
+	}
+
+	if err := restoreMappings(map[int]int64{3: 73}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sqliteMappings(t, s); len(got) != 2 || got[3] != 73 {
+		t.Errorf("SQLite mappings after restore = %v, want 1 -> 71 and 3 -> 73", got)
+	}
+}
+
+// sqliteUser SQLite users 表中的一行
+type sqliteUser struct {
+	Name, UserName, Language     string
+	OptOut, Subscribed, Inactive bool
+}
+
+// sqliteUsers 返回 SQLite 中的用户，键为聊天ID
+func sqliteUsers(t *testing.T, s *sqliteStorage) map[int64]sqliteUser {
+	t.Helper()
+	rows, err := s.sql.Query("SELECT chat_id, name, username, language, optout, subscribed, inactive FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	m := make(map[int64]sqliteUser)
+	for rows.Next() {
+		var chatID int64
+		var u sqliteUser
+		if err := rows.Scan(&chatID, &u.Name, &u.UserName, &u.Language, &u.OptOut, &u.Subscribed, &u.Inactive); err != nil {
+			t.Fatal(err)
+		}
+		m[chatID] = u
+	}
+	return m
+}
+
+func TestSQLiteUsersMirrorBolt(t *testing.T) {
+	s := setupSQLiteStorage(t)
+	newFakeTelegram(t)
+
+	msg := privateMsg(81, 1, "hi")
+	msg.UserName = "alice"
+	touchUser(msg)
+	commander(privateMsg(82, 1, "/subscribe"))
+	commander(privateMsg(81, 2, "/stop"))
+	commander(privateMsg(81, 3, "/lang ru"))
+	if _, err := importUsers([]importEntry{{ChatID: 83, Name: "Imported"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := sqliteUsers(t, s)
+	want := map[int64]sqliteUser{
+		81: {Name: "Test User", UserName: "alice", Language: "ru", OptOut: true},
+		82: {Name: "Test User", Subscribed: true},
+		83: {Name: "Imported"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("SQLite users = %+v, want %+v", got, want)
+	}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("SQLite user %d = %+v, want %+v", id, got[id], w)
+		}
+	}
+
+	markUnreachable(82)
+	if u := sqliteUsers(t, s)[82]; !u.Inactive {
+		t.Errorf("SQLite user 82 = %+v, want inactive", u)
+	}
+}
+
+func TestSQLiteUpgradeImportsUsers(t *testing.T) {
+	s := setupSQLiteStorage(t)
+	touchUser(privateMsg(91, 1, "hi"))
+	// 模拟版本 1 的数据库：没有用户
+	if _, err := s.sql.Exec("DELETE FROM users; PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	reopened, err := openSQLiteStorage(filepath.Join(getConfig().Paths.DataDir, sqliteFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := reopened.(*sqliteStorage)
+	defer s2.Close()
+	if got := sqliteUsers(t, s2); len(got) != 1 || got[91].Name != "Test User" {
+		t.Errorf("SQLite users after upgrade = %+v, want user 91", got)
+	}
+	var version int
+	s2.sql.QueryRow("PRAGMA user_version").Scan(&version)
+	if version != sqliteSchemaVersion {
+		t.Errorf("user_version = %d, want %d", version, sqliteSchemaVersion)
+	}
+}
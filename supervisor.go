@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 自动重启的默认值
+const (
+	defaultSupervisorWindow  = 10 // 统计 panic 次数的时间窗口（分钟）
+	defaultSupervisorHealthy = 30 // 多久没有 panic 后清零计数（分钟）
+)
+
+var (
+	// supervisorMu 保护 recentPanics
+	supervisorMu sync.Mutex
+	// recentPanics 时间窗口内 panic 发生的时间
+	recentPanics []time.Time
+	// restartOnce 保证只重启一次
+	restartOnce sync.Once
+)
+
+// supervisorWindow 返回统计 panic 次数的时间窗口
+func supervisorWindow() time.Duration {
+	if getConfig().Supervisor.Window <= 0 {
+		return defaultSupervisorWindow * time.Minute
+	}
+	return time.Duration(getConfig().Supervisor.Window) * time.Minute
+}
+
+// supervisorHealthyPeriod 返回清零计数所需的无 panic 时长
+func supervisorHealthyPeriod() time.Duration {
+	if getConfig().Supervisor.HealthyPeriod <= 0 {
+		return defaultSupervisorHealthy * time.Minute
+	}
+	return time.Duration(getConfig().Supervisor.HealthyPeriod) * time.Minute
+}
+
+// notePanic 记录一次恢复的 panic，时间窗口内达到 supervisor.max_panics 次时在后台重启进程
+// max_panics 为 0 时不自动重启
+func notePanic(now time.Time) {
+	limit := getConfig().Supervisor.MaxPanics
+	if limit <= 0 {
+		return
+	}
+	supervisorMu.Lock()
+	// 距上次 panic 已经正常运行了足够久，之前的次数不再计入
+	if n := len(recentPanics); n > 0 && now.Sub(recentPanics[n-1]) >= supervisorHealthyPeriod() {
+		recentPanics = nil
+	}
+	cutoff := now.Add(-supervisorWindow())
+	kept := recentPanics[:0]
+	for _, t := range recentPanics {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	recentPanics = append(kept, now)
+	count := len(recentPanics)
+	supervisorMu.Unlock()
+
+	if count >= limit {
+		// 在单独的 goroutine 中重启：stopBot 会等待处理中的更新完成，其中包括当前这个
+		go restartSelf(fmt.Sprintf("%v 内发生了 %d 次错误", supervisorWindow(), count))
+	}
+}
+
+// restartSelf 停止接收更新、关闭 webhook 服务和数据库后以相同的参数重新执行程序
+// 重新执行失败时以非 0 状态退出，交给 systemd 等外部进程管理器重启
+func restartSelf(reason string) {
+	restartOnce.Do(func() {
+		logErrorf("%s, 重启进程", reason)
+		SendPlain(getConfig().Account.Owner, fmt.Sprintf("%s，机器人正在自动重启", reason))
+		beginShutdown()
+		stopBot()
+		cleanup()
+
+		exe, err := os.Executable()
+		if err == nil {
+			err = syscall.Exec(exe, os.Args, os.Environ())
+		}
+		logErrorf("重新执行程序失败: %v", err)
+		fmt.Printf("重新执行程序失败: %v\n", err)
+		os.Exit(1)
+	})
+}
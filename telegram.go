@@ -1,166 +1,717 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 // SimpleMsg 定义了消息的基本结构
 type SimpleMsg struct {
-	Type      string // 消息类型：private, group 等
-	FromID    int64  // 发送者ID
-	MessageID int    // 消息ID
-	ReplyID   int    // 回复消息ID（如果有）
-	Text      string // 消息文本内容
-	PhotoID   string // 图片ID（如果有）
-	VideoID   string // 视频ID（如果有）
-	FileID    string // 文件ID（如果有）
-	FileName  string // 文件名称（如果有）
-	ChatId    int64  // 聊天ID
-	Name      string // 发送者名称
+	Type         string // 消息类型：private, group 等
+	FromID       int64  // 发送者ID
+	MessageID    int    // 消息ID
+	ReplyID      int    // 回复消息ID（如果有）
+	ReplyText    string // 被回复消息的内容摘要（如果有）
+	ForwardFrom  string // 用户转发的消息的原作者（如果是转发的消息）
+	Text         string // 消息文本内容
+	Caption      string // 媒体消息的说明文字（如果有）
+	PhotoID      string // 图片ID（如果有）
+	VideoID      string // 视频ID（如果有）
+	FileID       string // 文件ID（如果有）
+	FileName     string // 文件名称（如果有）
+	MediaGroupID string // 相册ID（如果是相册中的一条）
+	ContentType  string // 内容类型：text、photo、video、document、sticker、poll 等，无法识别时为 unknown
+	ChatId       int64  // 聊天ID
+	Name         string // 发送者名称
+	UserName     string // 发送者的 @username（如果有）
+	LanguageCode string // 发送者客户端的语言代码（如果有）
 	//SourceForwardId int64
 }
 
 // BotHandler 定义了更新事件处理函数类型
 type BotHandler func(update tgbotapi.Update)
 
-// emptyLogger 定义了一个空日志记录器
+// emptyLogger 定义了一个空日志记录器，非 debug 级别时屏蔽 tgbotapi 的日志
 type emptyLogger struct{}
 
 func (l *emptyLogger) Printf(format string, args ...interface{}) {}
 func (l *emptyLogger) Println(args ...interface{})               {}
 
-// InitBot 初始化 Telegram 机器人
+// defaultAllowedUpdates 未配置 allowed_updates 时请求的更新类型，即机器人实际处理的类型
+var defaultAllowedUpdates = []string{"message", "edited_message", "callback_query", "message_reaction"}
+
+// allowedUpdates 返回向 Telegram 请求的更新类型
+func allowedUpdates() []string {
+	if len(getConfig().AllowedUpdates) == 0 {
+		return defaultAllowedUpdates
+	}
+	return getConfig().AllowedUpdates
+}
+
+// webhookListenPath 返回 webhook 服务注册处理函数的路径
+// 设置了 override 时使用 override，否则使用 endpoint 中的路径（https://host/botpath 为 /botpath），没有路径时为 /
+func webhookListenPath(endpoint, override string) string {
+	if override != "" {
+		return override
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// secretTokenHeader Telegram 在 webhook 请求中携带 secret_token 的请求头
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// secretTokenPattern Telegram 允许的 secret_token 格式
+var secretTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// validSecretToken 判断 webhook 请求是否带有配置的 secret_token，未配置时全部接受
+func validSecretToken(r *http.Request) bool {
+	secret := getConfig().Account.SecretToken
+	if secret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(secret)) == 1
+}
+
+// webhookHandler 返回接收 webhook 更新的处理函数，只接受 path 上带有正确 secret_token 的 POST 请求
+func webhookHandler(pool *updatePool, path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 路径为 / 时 ServeMux 会匹配所有路径，只接受完全相同的路径
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validSecretToken(r) {
+			logWarnf("拒绝 secret_token 不正确的 webhook 请求（%s）", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = receiveUpdate(pool, data)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+// pendingUpdateCount 返回 Telegram 上等待机器人接收的更新数量，获取失败时返回 -1
+func pendingUpdateCount(bot *tgbotapi.BotAPI) int {
+	info, err := bot.GetWebhookInfo()
+	if err != nil {
+		logWarnf("获取待处理的更新数量失败: %v", err)
+		return -1
+	}
+	return info.PendingUpdateCount
+}
+
+// skipPendingUpdates 跳过 polling 模式下积压的更新，返回开始接收的 offset
+func skipPendingUpdates(bot *tgbotapi.BotAPI) (int, error) {
+	pending := pendingUpdateCount(bot)
+	// offset 为 -1 时只返回最新的一条更新，下次从它之后开始接收，之前的更新都会被确认丢弃
+	updates, err := bot.GetUpdates(tgbotapi.UpdateConfig{Offset: -1, Limit: 1, AllowedUpdates: allowedUpdates()})
+	if err != nil {
+		return 0, err
+	}
+	if len(updates) == 0 {
+		return 0, nil
+	}
+	logInfof("已丢弃启动前积压的 %d 条更新", pending)
+	return updates[0].UpdateID + 1, nil
+}
+
+// setWebhook 向 Telegram 注册 webhook 地址
+// tgbotapi 的 WebhookConfig 不支持 secret_token，因此直接构造参数
+func setWebhook(bot *tgbotapi.BotAPI, endpoint string) error {
+	params := tgbotapi.Params{"url": endpoint}
+	if err := params.AddInterface("allowed_updates", allowedUpdates()); err != nil {
+		return fmt.Errorf("创建webhook失败: %w", err)
+	}
+	params.AddNonEmpty("secret_token", getConfig().Account.SecretToken)
+	if getConfig().Account.DropPendingUpdates {
+		params.AddBool("drop_pending_updates", true)
+		logInfof("设置 webhook 时丢弃积压的 %d 条更新", pendingUpdateCount(bot))
+	}
+	if _, err := bot.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("设置webhook失败: %w", err)
+	}
+
+	info, err := bot.GetWebhookInfo()
+	if err != nil {
+		return fmt.Errorf("获取webhook信息失败: %w", err)
+	}
+	if info.LastErrorDate != 0 {
+		logWarnf("Webhook最后错误: %s", info.LastErrorMessage)
+	}
+	return nil
+}
+
+// isUnauthorized 判断是否为 token 无效或已被撤销导致的错误，格式错误的 token 会返回 404
+func isUnauthorized(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && (tgErr.Code == 401 || tgErr.Code == 404)
+}
+
+// InitBot 接收并处理 Telegram 更新，直到 ctx 被取消，webhook 模式需要先调用 setWebhook
+// ctx 取消后停止接收更新、关闭 webhook 服务，并等待正在处理的更新完成后返回
+// bot: 已创建的 Bot API 实例
 // mode: polling 或 webhook
-// token: Telegram Bot Token
 // endpoint: webhook 模式的回调地址
 // port: webhook 模式的端口
 // handler: 更新事件处理函数
-func InitBot(mode, token, endpoint string, port int, handler BotHandler) {
-	tgbotapi.SetLogger(&emptyLogger{})
-	log.Printf("初始化机器人，模式: %s", mode)
+func InitBot(ctx context.Context, bot *tgbotapi.BotAPI, mode, endpoint string, port int, handler BotHandler) {
+	logInfof("初始化机器人，模式: %s, 更新类型: %s", mode, strings.Join(allowedUpdates(), ","))
 
-	var err error
-	bot, err = tgbotapi.NewBotAPI(token)
-	if err != nil {
-		log.Printf("创建机器人实例失败: %v", err)
-		panic("创建机器人失败: " + err.Error())
-	}
+	pool := newUpdatePool(getConfig().Workers, handler)
+	defer pool.close()
+	currentPool.Store(pool)
+	defer currentPool.CompareAndSwap(pool, nil)
 
 	if mode == "webhook" {
-		wh, err := tgbotapi.NewWebhook(endpoint)
-		if err != nil {
-			log.Printf("创建webhook失败: %v", err)
-			panic("创建webhook失败: " + err.Error())
-		}
+		// 使用独立的 ServeMux 和 http.Server，便于关闭和在新端口上重新启动
+		mux := http.NewServeMux()
+		path := webhookListenPath(endpoint, getConfig().Account.WebhookPath)
+		logInfof("webhook 监听路径: %s", path)
+		mux.HandleFunc(path, telegramOnly(webhookHandler(pool, path)))
+		mux.HandleFunc("/health", healthHandler)
+		// webhook 模式下 /health 由 webhook 服务提供，关闭单独的健康检查服务
+		startHealthServer(0)
+		srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logErrorf("webhook 服务退出: %v", err)
+			}
+		}()
 
-		_, err = bot.Request(wh)
-		if err != nil {
-			log.Printf("设置webhook失败: %v", err)
-			panic("设置webhook失败: " + err.Error())
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logErrorf("关闭 webhook 服务失败: %v", err)
 		}
+		logInfof("webhook 服务已关闭")
+	} else {
+		startHealthServer(getConfig().Health.Port)
 
-		info, err := bot.GetWebhookInfo()
-		if err != nil {
-			log.Printf("获取webhook信息失败: %v", err)
-			panic("获取webhook信息失败: " + err.Error())
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 60
+		u.AllowedUpdates = allowedUpdates()
+		if getConfig().Account.DropPendingUpdates {
+			offset, err := skipPendingUpdates(bot)
+			if err != nil {
+				logErrorf("丢弃积压的更新失败: %v", err)
+			}
+			u.Offset = offset
 		}
 
-		if info.LastErrorDate != 0 {
-			log.Printf("Webhook最后错误: %s", info.LastErrorMessage)
+		updates := pollUpdates(ctx, bot, u)
+
+		for {
+			select {
+			case <-ctx.Done():
+				logInfof("已停止接收更新")
+				return
+			case data := <-updates:
+				if err := receiveUpdate(pool, data); err != nil {
+					logWarnf("解析更新失败: %v", err)
+				}
+			}
 		}
+	}
+}
 
-		updates := bot.ListenForWebhook("/")
-		go http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+// pollRetryDelay 获取更新失败后重试的间隔
+const pollRetryDelay = 3 * time.Second
 
-		for update := range updates {
-			handler(update)
-		}
-	} else {
-		u := tgbotapi.NewUpdate(0)
-		u.Timeout = 60
+// waitRetry 等待 pollRetryDelay，ctx 被取消时返回 false
+func waitRetry(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(pollRetryDelay):
+		return true
+	}
+}
 
-		updates := bot.GetUpdatesChan(u)
+// pollUpdates 在后台通过 getUpdates 长轮询接收更新，直到 ctx 被取消
+// 与 GetUpdatesChan 不同，返回未解析的原始数据，以便解析 tgbotapi 不支持的更新类型
+func pollUpdates(ctx context.Context, bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) <-chan json.RawMessage {
+	ch := make(chan json.RawMessage, bot.Buffer)
+	go func() {
+		for ctx.Err() == nil {
+			resp, err := bot.Request(config)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logWarnf("获取更新失败, %v 后重试: %v", pollRetryDelay, err)
+				if !waitRetry(ctx) {
+					return
+				}
+				continue
+			}
+			var updates []json.RawMessage
+			if err := json.Unmarshal(resp.Result, &updates); err != nil {
+				// 同样等待后重试，否则 Telegram 持续返回无法解析的结果时会不停地请求
+				logErrorf("解析更新列表失败, %v 后重试: %v", pollRetryDelay, err)
+				if !waitRetry(ctx) {
+					return
+				}
+				continue
+			}
+			for _, data := range updates {
+				var head struct {
+					UpdateID int `json:"update_id"`
+				}
+				if json.Unmarshal(data, &head) == nil && head.UpdateID >= config.Offset {
+					config.Offset = head.UpdateID + 1
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- data:
+				}
+			}
+		}
+	}()
+	return ch
+}
 
-		for update := range updates {
-			handler(update)
+// receiveUpdate 解析一条更新，message_reaction 直接处理，其他更新交给 worker
+func receiveUpdate(pool *updatePool, data []byte) error {
+	update, reaction, err := decodeUpdate(data)
+	if err != nil {
+		return err
+	}
+	if reaction != nil {
+		if seenUpdates.add(update.UpdateID) {
+			handleReaction(reaction)
 		}
+		return nil
 	}
+	pool.dispatch(update)
+	return nil
 }
 
 // FormatMsg 将 Telegram 更新事件转换为 SimpleMsg 格式
 func FormatMsg(update tgbotapi.Update) SimpleMsg {
+	return formatMessage(update.Message)
+}
+
+// formatMessage 将 Telegram 消息转换为 SimpleMsg 格式
+func formatMessage(message *tgbotapi.Message) SimpleMsg {
 	msg := SimpleMsg{}
-	if update.Message == nil {
+	if message == nil {
 		return msg
 	}
-	if update.Message.Chat != nil {
-		msg.Type = update.Message.Chat.Type
-		msg.ChatId = update.Message.Chat.ID
+	if message.Chat != nil {
+		msg.Type = message.Chat.Type
+		msg.ChatId = message.Chat.ID
 	}
-	if update.Message.From != nil {
-		msg.FromID = update.Message.From.ID
+	if message.From != nil {
+		msg.FromID = message.From.ID
+		msg.Name = fmt.Sprintf("%s %s", message.From.FirstName, message.From.LastName)
+		msg.UserName = message.From.UserName
+		msg.LanguageCode = message.From.LanguageCode
 	}
-	msg.MessageID = update.Message.MessageID
-	msg.Text = update.Message.Text
-	msg.Name = fmt.Sprintf("%s %s", update.Message.From.FirstName, update.Message.From.LastName)
-	if update.Message.ReplyToMessage != nil {
-		msg.ReplyID = update.Message.ReplyToMessage.MessageID
+	msg.MessageID = message.MessageID
+	msg.Text = message.Text
+	msg.Caption = message.Caption
+	msg.MediaGroupID = message.MediaGroupID
+	msg.ContentType = contentType(message)
+	msg.ForwardFrom = forwardOrigin(message)
+	if message.ReplyToMessage != nil {
+		msg.ReplyID = message.ReplyToMessage.MessageID
+		msg.ReplyText = replySummary(message.ReplyToMessage)
 	}
-	if update.Message.Photo != nil {
-		if len(update.Message.Photo) > 0 {
-			msg.PhotoID = update.Message.Photo[0].FileID
+	if message.Photo != nil {
+		if len(message.Photo) > 0 {
+			// 最后一个尺寸是原图
+			msg.PhotoID = message.Photo[len(message.Photo)-1].FileID
 		}
 	}
-	if update.Message.Video != nil {
-		msg.VideoID = update.Message.Video.FileID
+	if message.Video != nil {
+		msg.VideoID = message.Video.FileID
 	}
 
-	if update.Message.Document != nil {
-		msg.FileID = update.Message.Document.FileID
-		msg.FileName = update.Message.Document.FileName
+	if message.Document != nil {
+		msg.FileID = message.Document.FileID
+		msg.FileName = message.Document.FileName
 	}
 	return msg
 }
 
-// SendMsg 发送文本消息
-func SendMsg(chatID int64, text string) {
-	msg := tgbotapi.NewMessage(chatID, text)
-	bot.Send(msg)
+// contentType 返回消息的内容类型，用于日志和识别无法解析的消息
+func contentType(m *tgbotapi.Message) string {
+	switch {
+	case m.Text != "":
+		return "text"
+	case m.Photo != nil:
+		return "photo"
+	case m.Video != nil:
+		return "video"
+	// 动图同时带有 Document，需要先判断
+	case m.Animation != nil:
+		return "animation"
+	case m.Document != nil:
+		return "document"
+	case m.Sticker != nil:
+		return "sticker"
+	case m.Voice != nil:
+		return "voice"
+	case m.Audio != nil:
+		return "audio"
+	case m.VideoNote != nil:
+		return "video_note"
+	case m.Contact != nil:
+		return "contact"
+	// 地点同时带有 Location，需要先判断
+	case m.Venue != nil:
+		return "venue"
+	case m.Location != nil:
+		return "location"
+	case m.Poll != nil:
+		return "poll"
+	case m.Dice != nil:
+		return "dice"
+	case m.Game != nil:
+		return "game"
+	case m.Invoice != nil:
+		return "invoice"
+	}
+	return "unknown"
+}
+
+// forwardOrigin 返回转发消息的原作者，不是转发的消息时返回空字符串
+// 原作者隐藏了账号时只有 ForwardSenderName
+func forwardOrigin(m *tgbotapi.Message) string {
+	switch {
+	case m.ForwardFrom != nil:
+		origin := strings.TrimSpace(m.ForwardFrom.FirstName + " " + m.ForwardFrom.LastName)
+		if m.ForwardFrom.UserName != "" {
+			origin += " @" + m.ForwardFrom.UserName
+		}
+		return fmt.Sprintf("%s, id %d", origin, m.ForwardFrom.ID)
+	case m.ForwardFromChat != nil:
+		origin := m.ForwardFromChat.Title
+		if m.ForwardFromChat.UserName != "" {
+			origin += " @" + m.ForwardFromChat.UserName
+		}
+		if m.ForwardSignature != "" {
+			origin += " (" + m.ForwardSignature + ")"
+		}
+		return fmt.Sprintf("%s, id %d", origin, m.ForwardFromChat.ID)
+	case m.ForwardSenderName != "":
+		return m.ForwardSenderName + " (hidden account)"
+	}
+	return ""
+}
+
+// maxReplySummary 被回复消息摘要的最大字符数
+const maxReplySummary = 100
+
+// replySummary 生成被回复消息的单行摘要，媒体消息没有说明文字时显示类型
+func replySummary(m *tgbotapi.Message) string {
+	text := m.Text
+	if text == "" {
+		text = m.Caption
+	}
+	switch {
+	case text != "":
+	case m.Photo != nil:
+		text = "[图片]"
+	case m.Video != nil:
+		text = "[视频]"
+	case m.Document != nil:
+		text = "[文件] " + m.Document.FileName
+	default:
+		text = "[消息]"
+	}
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) > maxReplySummary {
+		return string(runes[:maxReplySummary]) + "…"
+	}
+	return string(runes)
+}
+
+// sendWithRetry 发送消息，遇到 429 限流时按 RetryAfter 等待后重试一次
+// 所有发送函数都应通过它调用 getBot().Send
+func sendWithRetry(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var m tgbotapi.Message
+	err := withRetry(func() (err error) {
+		m, err = getBot().Send(c)
+		return err
+	})
+	if isBlockedError(err) {
+		markUnreachable(chattableChatID(c))
+	}
+	return m, err
 }
 
-// ReplyMsg 回复文本消息
-func ReplyMsg(chatID int64, text string, replyTo int) {
+// isBlockedError 判断是否为用户屏蔽机器人（或注销账号）导致的 403 错误
+func isBlockedError(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) || tgErr.Code != 403 {
+		return false
+	}
+	return strings.Contains(tgErr.Message, "blocked by the user") || strings.Contains(tgErr.Message, "user is deactivated")
+}
+
+// chattableChatID 取出发送请求的目标聊天ID，无法识别时返回 0
+func chattableChatID(c tgbotapi.Chattable) int64 {
+	switch v := c.(type) {
+	case tgbotapi.MessageConfig:
+		return v.ChatID
+	case tgbotapi.PhotoConfig:
+		return v.ChatID
+	case tgbotapi.VideoConfig:
+		return v.ChatID
+	case tgbotapi.DocumentConfig:
+		return v.ChatID
+	case tgbotapi.ForwardConfig:
+		return v.ChatID
+	}
+	return 0
+}
+
+// withRetry 执行一次 API 调用，遇到 429 限流时等待后重试一次
+func withRetry(call func() error) error {
+	err := timedCall(call)
+	if wait := retryAfter(err); wait > 0 {
+		logWarnf("触发 Telegram 限流, %v 后重试", wait)
+		time.Sleep(wait)
+		err = timedCall(call)
+	}
+	if err != nil {
+		sendErrors.Inc()
+	}
+	return err
+}
+
+// timedCall 经过全局限流后执行 API 调用并记录耗时
+func timedCall(call func() error) error {
+	globalLimiter.Wait()
+	start := time.Now()
+	err := call()
+	sendLatency.Observe(time.Since(start).Seconds())
+	return err
+}
+
+// retryAfter 从 429 错误中取出需要等待的时间，其他错误返回 0
+func retryAfter(err error) time.Duration {
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) && tgErr.Code == 429 && tgErr.RetryAfter > 0 {
+		return time.Duration(tgErr.RetryAfter) * time.Second
+	}
+	return 0
+}
+
+// maxMessageLength Telegram 单条文本消息的最大字符数
+const maxMessageLength = 4096
+
+// SendMsg 按配置的 parse_mode 发送管理员撰写的内容（回复、广播、自动回复等）
+func SendMsg(chatID int64, text string) error {
+	_, err := sendMsgMode(chatID, text, getConfig().ParseMode)
+	return err
+}
+
+// SendPlain 以纯文本发送系统提示，内容中可能含有错误信息、用户名等不可信文本
+func SendPlain(chatID int64, text string) error {
+	_, err := sendMsgMode(chatID, text, "")
+	return err
+}
+
+// sendMsgMode 发送文本消息，超过长度限制时拆分为多条按顺序发送
+// 返回已发出各段的消息ID；任意一段发送失败时停止并返回错误
+// 使用解析模式时拆分可能截断格式标记，过长的消息需自行注意
+func sendMsgMode(chatID int64, text, parseMode string) ([]int, error) {
+	return sendMsgReply(chatID, text, parseMode, 0)
+}
+
+// sendMsgReply 与 sendMsgMode 相同，replyTo 不为 0 时第一段引用该消息
+// 被引用的消息已不存在时照常发送，不引用
+func sendMsgReply(chatID int64, text, parseMode string, replyTo int) ([]int, error) {
+	var msgids []int
+	for i, chunk := range splitMessage(text, maxMessageLength) {
+		msg := tgbotapi.NewMessage(chatID, chunk)
+		msg.ParseMode = parseMode
+		if i == 0 {
+			setReplyTo(&msg.BaseChat, replyTo)
+		}
+		m, err := sendWithRetry(msg)
+		if err != nil {
+			return msgids, err
+		}
+		msgids = append(msgids, m.MessageID)
+	}
+	return msgids, nil
+}
+
+// markdownV2Escaper 转义 MarkdownV2 中所有保留字符
+var markdownV2Escaper = strings.NewReplacer(
+	"\\", "\\\\", "_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+	"~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=",
+	"|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!",
+)
+
+// escapeMarkdownV2 转义插入到 MarkdownV2 模板中的不可信文本（如用户名）
+func escapeMarkdownV2(text string) string {
+	return markdownV2Escaper.Replace(text)
+}
+
+// splitMessage 将文本拆分为不超过 limit 个字符的片段
+// 优先在换行处拆分，其次在空格处，都没有时按字符硬拆，不会拆开 UTF-8 字符
+func splitMessage(text string, limit int) []string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return []string{text}
+	}
+	var chunks []string
+	for len(runes) > limit {
+		cut := lastIndexRune(runes[:limit], '\n')
+		if cut <= 0 {
+			cut = lastIndexRune(runes[:limit], ' ')
+		}
+		if cut <= 0 {
+			cut = limit
+		}
+		chunks = append(chunks, string(runes[:cut]))
+		runes = runes[cut:]
+		// 拆分处的换行或空格不带到下一段开头
+		if len(runes) > 0 && (runes[0] == '\n' || runes[0] == ' ') {
+			runes = runes[1:]
+		}
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
+
+// lastIndexRune 返回 r 在 runes 中最后一次出现的位置，不存在返回 -1
+func lastIndexRune(runes []rune, r rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// sendTyping 向对方显示"正在输入"，只是提示，失败时不重试
+// 需要在发送消息之前同步调用，否则提示可能晚于消息到达，消息发出后仍显示正在输入
+func sendTyping(chatID int64) {
+	if _, err := getBot().Request(tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping)); err != nil {
+		logWith(chatID, 0).Debugf("发送输入状态给 %d 失败: %v", chatID, err)
+	}
+}
+
+// ReplyMsg 回复文本消息，返回发出的消息ID
+func ReplyMsg(chatID int64, text string, replyTo int) int {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyToMessageID = replyTo
-	bot.Send(msg)
+	returinfo, _ := sendWithRetry(msg)
+	return returinfo.MessageID
 }
 
-// SendExistingPhoto 转发已存在的图片
-func SendExistingPhoto(chatID int64, photoID string) {
+// setReplyTo 引用 replyTo 对应的消息，消息已不存在时 Telegram 照常发送；replyTo 为 0 时不引用
+func setReplyTo(chat *tgbotapi.BaseChat, replyTo int) {
+	if replyTo == 0 {
+		return
+	}
+	chat.ReplyToMessageID = replyTo
+	chat.AllowSendingWithoutReply = true
+}
+
+// SendExistingPhoto 转发已存在的图片，replyTo 不为 0 时引用该消息
+func SendExistingPhoto(chatID int64, photoID string, replyTo int) (int, error) {
 	msg := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(photoID))
-	bot.Send(msg)
+	setReplyTo(&msg.BaseChat, replyTo)
+	m, err := sendWithRetry(msg)
+	return m.MessageID, err
 }
 
-// SendExistingVideo 转发已存在的视频
-func SendExistingVideo(chatID int64, videoID string) {
+// SendExistingVideo 转发已存在的视频，replyTo 不为 0 时引用该消息
+func SendExistingVideo(chatID int64, videoID string, replyTo int) (int, error) {
 	msg := tgbotapi.NewVideo(chatID, tgbotapi.FileID(videoID))
-	bot.Send(msg)
+	setReplyTo(&msg.BaseChat, replyTo)
+	m, err := sendWithRetry(msg)
+	return m.MessageID, err
 }
 
-// SendExistingFile 转发已存在的文件
-func SendExistingFile(chatID int64, fileID string, fileName string) {
+// SendExistingFile 转发已存在的文件，replyTo 不为 0 时引用该消息
+func SendExistingFile(chatID int64, fileID string, fileName string, replyTo int) (int, error) {
 	msg := tgbotapi.NewDocument(chatID, tgbotapi.FileID(fileID))
 	msg.Caption = fileName
-	bot.Send(msg)
+	setReplyTo(&msg.BaseChat, replyTo)
+	m, err := sendWithRetry(msg)
+	return m.MessageID, err
 }
 
 // ForwardMsg 转发消息
 func ForwardMsg(chatID int64, fromChatID int64, messageID int) int {
 	msg := tgbotapi.NewForward(chatID, fromChatID, messageID)
-	returinfo, _ := bot.Send(msg)
+	msg.DisableNotification = quietRelay()
+	returinfo, _ := sendWithRetry(msg)
+	return returinfo.MessageID
+}
+
+// CopyMsg 复制消息，接收方看不到转发来源
+func CopyMsg(chatID int64, fromChatID int64, messageID int) int {
+	msg := tgbotapi.NewCopyMessage(chatID, fromChatID, messageID)
+	msg.DisableNotification = quietRelay()
+	var returinfo tgbotapi.MessageID
+	withRetry(func() (err error) {
+		returinfo, err = getBot().CopyMessage(msg)
+		return err
+	})
 	return returinfo.MessageID
 }
+
+// RelayMsg 按配置的 relay_mode 转发或复制消息，返回新消息ID
+func RelayMsg(chatID int64, fromChatID int64, messageID int) int {
+	if getConfig().RelayMode == "copy" {
+		return CopyMsg(chatID, fromChatID, messageID)
+	}
+	return ForwardMsg(chatID, fromChatID, messageID)
+}
+
+// SendMediaGroup 以相册形式发送多个已存在的媒体，返回发出的消息ID
+func SendMediaGroup(chatID int64, media []interface{}) []int {
+	group := tgbotapi.NewMediaGroup(chatID, media)
+	group.DisableNotification = quietRelay()
+	var msgs []tgbotapi.Message
+	err := withRetry(func() (err error) {
+		msgs, err = getBot().SendMediaGroup(group)
+		return err
+	})
+	if err != nil {
+		logErrorf("发送相册失败: %v", err)
+		return nil
+	}
+	ids := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.MessageID)
+	}
+	return ids
+}
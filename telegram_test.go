@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestSplitMessageLongText(t *testing.T) {
+	// 10000 个字符，混合中文、emoji 和 ASCII，没有换行和空格，只能按字符硬拆
+	text := strings.Repeat("中a😀b", 2500)
+	chunks := splitMessage(text, maxMessageLength)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+	total := 0
+	for i, c := range chunks {
+		if !utf8.ValidString(c) {
+			t.Errorf("chunk %d is not valid UTF-8", i)
+		}
+		n := utf8.RuneCountInString(c)
+		if n > maxMessageLength {
+			t.Errorf("chunk %d has %d characters, limit %d", i, n, maxMessageLength)
+		}
+		total += n
+	}
+	if total != 10000 || strings.Join(chunks, "") != text {
+		t.Errorf("chunks do not add up to the original text (%d characters)", total)
+	}
+}
+
+func TestSplitMessageBoundaries(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"short", "hello", 10, []string{"hello"}},
+		{"newline", "aaaa bb\ncccc", 9, []string{"aaaa bb", "cccc"}},
+		{"space", "aaaa bbbb cccc", 10, []string{"aaaa bbbb", "cccc"}},
+		{"hard", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"runes", "一二三四五六", 4, []string{"一二三四", "五六"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.limit)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMsgSplitsLongText(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+
+	if err := SendMsg(81, strings.Repeat("word ", 2000)); err != nil {
+		t.Fatal(err)
+	}
+	got := tg.sentTo(81)
+	if len(got) != 3 {
+		t.Fatalf("sent %d messages, want 3", len(got))
+	}
+	for i, text := range got {
+		if n := utf8.RuneCountInString(text); n > maxMessageLength {
+			t.Errorf("message %d has %d characters", i, n)
+		}
+	}
+}
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	// Telegram 文档列出的全部保留字符，以及转义符本身
+	tests := []struct {
+		in, want string
+	}{
+		{"_", `\_`},
+		{"*", `\*`},
+		{"[", `\[`},
+		{"]", `\]`},
+		{"(", `\(`},
+		{")", `\)`},
+		{"~", `\~`},
+		{"`", "\\`"},
+		{">", `\>`},
+		{"#", `\#`},
+		{"+", `\+`},
+		{"-", `\-`},
+		{"=", `\=`},
+		{"|", `\|`},
+		{"{", `\{`},
+		{"}", `\}`},
+		{".", `\.`},
+		{"!", `\!`},
+		{`\`, `\\`},
+		{"plain text 中文", "plain text 中文"},
+		{"a_b*c", `a\_b\*c`},
+		{`\*`, `\\\*`},
+		{"[link](http://x.y)", `\[link\]\(http://x\.y\)`},
+	}
+	for _, tt := range tests {
+		if got := escapeMarkdownV2(tt.in); got != tt.want {
+			t.Errorf("escapeMarkdownV2(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookListenPath(t *testing.T) {
+	tests := []struct {
+		endpoint, override, want string
+	}{
+		{"https://example.com/botpath", "", "/botpath"},
+		{"https://example.com/a/b/", "", "/a/b/"},
+		{"https://example.com:8443/hook?x=1", "", "/hook"},
+		{"https://example.com", "", "/"},
+		{"https://example.com/", "", "/"},
+		{"https://example.com/botpath", "/local", "/local"},
+		{"", "/local", "/local"},
+		{"://bad", "", "/"},
+	}
+	for _, tt := range tests {
+		if got := webhookListenPath(tt.endpoint, tt.override); got != tt.want {
+			t.Errorf("webhookListenPath(%q, %q) = %q, want %q", tt.endpoint, tt.override, got, tt.want)
+		}
+	}
+}
+
+func TestPollUpdatesWaitsAfterBadResult(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	// 结果不是更新列表时等待 pollRetryDelay 再重试，不能不停地请求
+	tg.respond("getUpdates", `{"unexpected":true}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pollUpdates(ctx, getBot(), tgbotapi.NewUpdate(0))
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+
+	if got := len(tg.callsTo("getUpdates")); got != 1 {
+		t.Errorf("getUpdates called %d times within 300ms, want 1", got)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// templatesBucket 存储管理员预设的回复模板，键为模板名
+var templatesBucket = []byte("templates")
+
+// templateCallbackPrefix 模板按钮回调数据的前缀，格式为 tpl:<模板名>:<按钮序号>
+const templateCallbackPrefix = "tpl:"
+
+// maxTemplateNameLen 限制模板名长度，保证回调数据不超过 Telegram 的 64 字节上限
+const maxTemplateNameLen = 32
+
+// TemplateButton 模板附带的内联按钮，设置了 URL 时为链接按钮，否则点击后通知管理员
+type TemplateButton struct {
+	Text string `json:"text"`          // 按钮文字
+	URL  string `json:"url,omitempty"` // 链接地址
+}
+
+// Template 回复模板
+type Template struct {
+	Name    string           `json:"name"`              // 模板名
+	Text    string           `json:"text"`              // 发送给用户的文本
+	Buttons []TemplateButton `json:"buttons,omitempty"` // 内联按钮，每个按钮一行
+}
+
+// validateTemplateName 检查模板名能否放入回调数据
+func validateTemplateName(name string) error {
+	if name == "" || len(name) > maxTemplateNameLen {
+		return fmt.Errorf("模板名长度须为 1-%d 字节", maxTemplateNameLen)
+	}
+	if strings.ContainsAny(name, ": \t\n") {
+		return errors.New("模板名不能包含冒号或空白字符")
+	}
+	return nil
+}
+
+// validateTemplate 检查模板名、内容和按钮是否有效
+func validateTemplate(t Template) error {
+	if err := validateTemplateName(t.Name); err != nil {
+		return err
+	}
+	if t.Text == "" {
+		return errors.New("模板内容不能为空")
+	}
+	for i, button := range t.Buttons {
+		if button.Text == "" {
+			return fmt.Errorf("第 %d 个按钮文字为空", i+1)
+		}
+		if button.URL != "" && !strings.HasPrefix(button.URL, "http://") && !strings.HasPrefix(button.URL, "https://") {
+			return fmt.Errorf("第 %d 个按钮链接不是 http(s) 地址: %s", i+1, button.URL)
+		}
+	}
+	return nil
+}
+
+// saveTemplate 保存模板，已存在时覆盖
+func saveTemplate(t Template) error {
+	if err := validateTemplate(t); err != nil {
+		return err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(templatesBucket).Put([]byte(t.Name), data)
+	})
+}
+
+// loadTemplate 读取模板
+func loadTemplate(name string) (Template, error) {
+	var t Template
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(templatesBucket).Get([]byte(name))
+		if v == nil {
+			return fmt.Errorf("模板 %s 不存在", name)
+		}
+		return json.Unmarshal(v, &t)
+	})
+	return t, err
+}
+
+// deleteTemplate 删除模板
+func deleteTemplate(name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(templatesBucket)
+		if b.Get([]byte(name)) == nil {
+			return fmt.Errorf("模板 %s 不存在", name)
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+// listTemplates 按模板名顺序列出所有模板
+func listTemplates() []Template {
+	var templates []Template
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(templatesBucket).ForEach(func(k, v []byte) error {
+			var t Template
+			if err := json.Unmarshal(v, &t); err != nil {
+				logErrorf("模板 %s 解析失败: %v", k, err)
+				return nil
+			}
+			templates = append(templates, t)
+			return nil
+		})
+	})
+	return templates
+}
+
+// addTemplateButton 给已有模板追加一个按钮
+func addTemplateButton(name string, button TemplateButton) error {
+	t, err := loadTemplate(name)
+	if err != nil {
+		return err
+	}
+	t.Buttons = append(t.Buttons, button)
+	return saveTemplate(t)
+}
+
+// exportTemplates 把全部模板（含按钮）导出为 JSON 文件，文件已存在时需要 force 才会覆盖
+func exportTemplates(path string, force bool) (int, error) {
+	if _, err := os.Stat(path); err == nil && !force {
+		return 0, fmt.Errorf("%s 已存在，确认覆盖请加 force", path)
+	}
+	templates := listTemplates()
+	if templates == nil {
+		templates = []Template{}
+	}
+	data, err := json.MarshalIndent(templates, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return 0, fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	return len(templates), nil
+}
+
+// parseTemplateImport 解析导出的模板文件，任何一个模板无效或重名时整体拒绝
+func parseTemplateImport(data []byte) ([]Template, error) {
+	var templates []Template
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&templates); err != nil {
+		return nil, fmt.Errorf("解析 JSON 失败: %v", err)
+	}
+	seen := make(map[string]bool)
+	for i, t := range templates {
+		if err := validateTemplate(t); err != nil {
+			return nil, fmt.Errorf("第 %d 个模板无效: %v", i+1, err)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("模板 %s 重复出现", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return templates, nil
+}
+
+// importTemplates 导入模板，与已有模板重名时不做任何修改并返回冲突的模板名，force 时直接覆盖
+func importTemplates(templates []Template, force bool) (conflicts []string, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(templatesBucket)
+		if !force {
+			for _, t := range templates {
+				if b.Get([]byte(t.Name)) != nil {
+					conflicts = append(conflicts, t.Name)
+				}
+			}
+			if len(conflicts) > 0 {
+				return nil
+			}
+		}
+		for _, t := range templates {
+			data, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(t.Name), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return conflicts, err
+}
+
+// templateMarkup 生成模板的内联键盘，没有按钮时返回 nil
+func templateMarkup(t Template) *tgbotapi.InlineKeyboardMarkup {
+	if len(t.Buttons) == 0 {
+		return nil
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, button := range t.Buttons {
+		if button.URL != "" {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(button.Text, button.URL)))
+			continue
+		}
+		data := fmt.Sprintf("%s%s:%d", templateCallbackPrefix, t.Name, i)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(button.Text, data)))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// sendTemplate 把模板连同按钮发送给用户
+func sendTemplate(chatID int64, name string) error {
+	t, err := loadTemplate(name)
+	if err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(chatID, t.Text)
+	if markup := templateMarkup(t); markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	if _, err := sendWithRetry(msg); err != nil {
+		return fmt.Errorf("发送模板 %s 给 %d 失败: %v", name, chatID, err)
+	}
+	messagesSent.Inc()
+	touchConversation(chatID)
+	recordOutgoing(chatID, SimpleMsg{Text: t.Text})
+	return nil
+}
+
+// parseTemplateCallback 解析模板按钮的回调数据
+func parseTemplateCallback(data string) (name string, index int, ok bool) {
+	rest := strings.TrimPrefix(data, templateCallbackPrefix)
+	if rest == data {
+		return "", 0, false
+	}
+	sep := strings.LastIndex(rest, ":")
+	if sep <= 0 {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(rest[sep+1:])
+	if err != nil || index < 0 {
+		return "", 0, false
+	}
+	return rest[:sep], index, true
+}
+
+// handleTemplateCallback 把用户点击模板按钮的事件转给管理员，管理员可直接回复该通知
+func handleTemplateCallback(callback *tgbotapi.CallbackQuery) {
+	name, index, ok := parseTemplateCallback(callback.Data)
+	if !ok || callback.From == nil {
+		logWarnf("无效的模板回调数据: %s", callback.Data)
+		return
+	}
+	label := fmt.Sprintf("#%d", index+1)
+	if t, err := loadTemplate(name); err == nil && index < len(t.Buttons) {
+		label = t.Buttons[index].Text
+	}
+
+	from := SimpleMsg{
+		ChatId:   callback.From.ID,
+		Name:     fmt.Sprintf("%s %s", callback.From.FirstName, callback.From.LastName),
+		UserName: callback.From.UserName,
+	}
+	text := fmt.Sprintf("%s\n点击了模板 %s 的按钮「%s」", formatHeader(from), escapeMarkdownV2(name), escapeMarkdownV2(label))
+	notice := tgbotapi.NewMessage(getConfig().Account.Owner, text)
+	notice.ParseMode = tgbotapi.ModeMarkdownV2
+	sent, err := sendWithRetry(notice)
+	if err != nil {
+		logErrorf("通知管理员模板按钮点击失败: %v", err)
+		return
+	}
+	printIncoming(from.ChatId, from.Name, fmt.Sprintf("[button] %s / %s", name, label))
+	lastreplyid.Store(from.ChatId)
+	putMapping(sent.MessageID, from.ChatId, 0)
+	logInfof("用户 %d 点击了模板 %s 的按钮 %s", from.ChatId, name, label)
+}
+
+// templatesUsage 模板命令的用法说明
+const templatesUsage = `usage: templates list
+       templates add <name> <text>
+       templates button <name> <label> [url]
+       templates send <chatid> <name>
+       templates del <name>
+       templates export <path> [force]
+       templates import <path> [force]`
+
+// templatesCommand 处理命令行的 templates 子命令
+func templatesCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(templatesUsage)
+		return
+	}
+	var err error
+	switch sub := args[0]; {
+	case sub == "list" && len(args) == 1:
+		for _, t := range listTemplates() {
+			fmt.Printf("[%s] %s\n", t.Name, t.Text)
+			for i, button := range t.Buttons {
+				if button.URL != "" {
+					fmt.Printf("  %d. %s -> %s\n", i+1, button.Text, button.URL)
+				} else {
+					fmt.Printf("  %d. %s\n", i+1, button.Text)
+				}
+			}
+		}
+		return
+	case sub == "add" && len(args) >= 3:
+		err = saveTemplate(Template{Name: args[1], Text: strings.Join(args[2:], " ")})
+	case sub == "button" && len(args) >= 3:
+		// 最后一个参数是 http(s) 链接时作为链接按钮，其余部分为按钮文字
+		label := args[2:]
+		button := TemplateButton{}
+		if last := label[len(label)-1]; len(label) > 1 && (strings.HasPrefix(last, "http://") || strings.HasPrefix(last, "https://")) {
+			button.URL = last
+			label = label[:len(label)-1]
+		}
+		button.Text = strings.Join(label, " ")
+		err = addTemplateButton(args[1], button)
+	case sub == "send" && len(args) == 3 && isNumber(args[1]):
+		chatid, _ := strconv.ParseInt(args[1], 10, 64)
+		err = sendTemplate(chatid, args[2])
+		recordAudit(actorCLI, auditReply, chatid, auditResult("template "+args[2], err))
+	case sub == "del" && len(args) == 2:
+		err = deleteTemplate(args[1])
+	case (sub == "export" || sub == "import") && (len(args) == 2 || len(args) == 3 && args[2] == "force"):
+		force := len(args) == 3
+		if sub == "export" {
+			n, err := exportTemplates(args[1], force)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("exported %d templates to %s\n", n, args[1])
+			return
+		}
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		templates, err := parseTemplateImport(data)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		conflicts, err := importTemplates(templates, force)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(conflicts) > 0 {
+			fmt.Printf("nothing imported, these templates already exist: %s\n", strings.Join(conflicts, ", "))
+			fmt.Printf("run \"templates import %s force\" to overwrite them\n", args[1])
+			return
+		}
+		fmt.Printf("imported %d templates from %s\n", len(templates), args[1])
+		logInfof("从 %s 导入 %d 个模板", args[1], len(templates))
+		return
+	default:
+		fmt.Println(templatesUsage)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("templates %s done\n", args[0])
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// promoTemplate 带一个链接按钮和一个回调按钮的模板
+var promoTemplate = Template{
+	Name: "promo",
+	Text: "Spring sale",
+	Buttons: []TemplateButton{
+		{Text: "Shop", URL: "https://example.com/shop"},
+		{Text: "Talk to us"},
+	},
+}
+
+func TestSendTemplateWithButtons(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	if err := saveTemplate(promoTemplate); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendTemplate(2930, "promo"); err != nil {
+		t.Fatal(err)
+	}
+	calls := tg.callsTo("sendMessage")
+	if len(calls) != 1 || calls[0].Params.Get("text") != "Spring sale" {
+		t.Fatalf("sendMessage calls = %v, want the template text", calls)
+	}
+	var markup tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(calls[0].Params.Get("reply_markup")), &markup); err != nil {
+		t.Fatal(err)
+	}
+	rows := markup.InlineKeyboard
+	if len(rows) != 2 || len(rows[0]) != 1 || len(rows[1]) != 1 {
+		t.Fatalf("keyboard = %+v, want one button per row", rows)
+	}
+	if b := rows[0][0]; b.Text != "Shop" || b.URL == nil || *b.URL != "https://example.com/shop" {
+		t.Errorf("first button = %+v, want a link to the shop", b)
+	}
+	if b := rows[1][0]; b.Text != "Talk to us" || b.CallbackData == nil || *b.CallbackData != "tpl:promo:1" {
+		t.Errorf("second button = %+v, want callback tpl:promo:1", b)
+	}
+
+	if err := sendTemplate(2930, "missing"); err == nil {
+		t.Error("sendTemplate succeeded for a missing template")
+	}
+}
+
+func TestTemplateCallbackRoutesToOwner(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	if err := saveTemplate(promoTemplate); err != nil {
+		t.Fatal(err)
+	}
+
+	handleCallback(&tgbotapi.CallbackQuery{
+		ID:   "cb1",
+		From: &tgbotapi.User{ID: 2931, FirstName: "Test", UserName: "tester"},
+		Data: "tpl:promo:1",
+	})
+
+	if acks := tg.callsTo("answerCallbackQuery"); len(acks) != 1 || acks[0].Params.Get("text") != "已通知客服" {
+		t.Errorf("callback answers = %v, want one notice to the user", acks)
+	}
+	notices := tg.sentTo(testOwner)
+	if len(notices) != 1 || !strings.Contains(notices[0], "promo") || !strings.Contains(notices[0], "Talk to us") {
+		t.Fatalf("owner notices = %q, want the template and button name", notices)
+	}
+	if got := lastreplyid.Load(); got != 2931 {
+		t.Errorf("lastreplyid = %d, want 2931", got)
+	}
+
+	// 管理员回复这条通知时发给点击按钮的用户
+	noticeID := tg.lastMessageID()
+	tg.reset()
+	reply := privateMsg(testOwner, 2, "How can we help?")
+	reply.ReplyID = noticeID
+	deliverOutgoingMsg(reply)
+	if got := tg.sentTo(2931); len(got) != 1 || got[0] != "How can we help?" {
+		t.Errorf("user received %q, want the owner's reply", got)
+	}
+}
+
+func TestParseTemplateCallback(t *testing.T) {
+	tests := []struct {
+		data  string
+		name  string
+		index int
+		ok    bool
+	}{
+		{"tpl:promo:1", "promo", 1, true},
+		{"tpl:a-b_c:0", "a-b_c", 0, true},
+		{"promo:1", "", 0, false},
+		{"tpl:promo", "", 0, false},
+		{"tpl::1", "", 0, false},
+		{"tpl:promo:-1", "", 0, false},
+		{"tpl:promo:x", "", 0, false},
+	}
+	for _, tt := range tests {
+		name, index, ok := parseTemplateCallback(tt.data)
+		if name != tt.name || index != tt.index || ok != tt.ok {
+			t.Errorf("parseTemplateCallback(%q) = %q, %d, %v, want %q, %d, %v", tt.data, name, index, ok, tt.name, tt.index, tt.ok)
+		}
+	}
+}
+
+func TestTemplateExportImportRoundTrip(t *testing.T) {
+	setupTestDB(t)
+	plain := Template{Name: "thanks", Text: "Thank you!"}
+	for _, tpl := range []Template{promoTemplate, plain} {
+		if err := saveTemplate(tpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "templates.json")
+	if n, err := exportTemplates(path, false); err != nil || n != 2 {
+		t.Fatalf("exportTemplates = %d, %v, want 2", n, err)
+	}
+	// 文件已存在时需要 force
+	if _, err := exportTemplates(path, false); err == nil {
+		t.Error("exportTemplates overwrote an existing file without force")
+	}
+	if _, err := exportTemplates(path, true); err != nil {
+		t.Errorf("exportTemplates with force: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := parseTemplateImport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 删除后重新导入，内容完全一致
+	for _, tpl := range templates {
+		if err := deleteTemplate(tpl.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := listTemplates(); len(got) != 0 {
+		t.Fatalf("templates left after delete: %v", got)
+	}
+	if conflicts, err := importTemplates(templates, false); err != nil || len(conflicts) != 0 {
+		t.Fatalf("importTemplates = %v, %v", conflicts, err)
+	}
+	for _, want := range []Template{promoTemplate, plain} {
+		got, err := loadTemplate(want.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("imported %s = %+v, want %+v", want.Name, got, want)
+		}
+	}
+}
+
+func TestTemplateImportConflicts(t *testing.T) {
+	setupTestDB(t)
+	if err := saveTemplate(Template{Name: "promo", Text: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	incoming := []Template{promoTemplate, {Name: "new", Text: "brand new"}}
+
+	conflicts, err := importTemplates(incoming, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0] != "promo" {
+		t.Errorf("conflicts = %v, want [promo]", conflicts)
+	}
+	// 有冲突时不做任何修改
+	if got, _ := loadTemplate("promo"); got.Text != "old" {
+		t.Errorf("promo = %q after a conflicting import, want it unchanged", got.Text)
+	}
+	if _, err := loadTemplate("new"); err == nil {
+		t.Error("template new was imported despite the conflict")
+	}
+
+	if conflicts, err := importTemplates(incoming, true); err != nil || len(conflicts) != 0 {
+		t.Fatalf("forced import = %v, %v", conflicts, err)
+	}
+	if got, _ := loadTemplate("promo"); got.Text != promoTemplate.Text || len(got.Buttons) != 2 {
+		t.Errorf("promo = %+v after a forced import", got)
+	}
+}
+
+func TestParseTemplateImportRejectsMalformed(t *testing.T) {
+	tests := []struct{ name, data string }{
+		{"not json", `not json`},
+		{"object", `{"name": "a", "text": "b"}`},
+		{"unknown field", `[{"name": "a", "text": "b", "color": "red"}]`},
+		{"empty name", `[{"name": "", "text": "b"}]`},
+		{"name with colon", `[{"name": "a:b", "text": "b"}]`},
+		{"empty text", `[{"name": "a", "text": ""}]`},
+		{"empty button", `[{"name": "a", "text": "b", "buttons": [{"text": ""}]}]`},
+		{"bad url", `[{"name": "a", "text": "b", "buttons": [{"text": "x", "url": "javascript:alert(1)"}]}]`},
+		{"duplicate", `[{"name": "a", "text": "b"}, {"name": "a", "text": "c"}]`},
+	}
+	for _, tt := range tests {
+		if templates, err := parseTemplateImport([]byte(tt.data)); err == nil {
+			t.Errorf("%s: parseTemplateImport accepted %s as %+v", tt.name, tt.data, templates)
+		}
+	}
+	if templates, err := parseTemplateImport([]byte(`[]`)); err != nil || len(templates) != 0 {
+		t.Errorf("parseTemplateImport([]) = %v, %v, want no templates", templates, err)
+	}
+}
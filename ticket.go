@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ticketsBucket 存储每个用户的工单状态，键为聊天ID
+var ticketsBucket = []byte("tickets")
+
+// 工单状态
+const (
+	ticketOpen     = "open"     // 用户发来消息，等待管理员处理
+	ticketPending  = "pending"  // 管理员已回复，等待用户回应
+	ticketResolved = "resolved" // 已解决
+)
+
+// Ticket 用户的工单状态
+type Ticket struct {
+	ChatID  int64  `json:"chat_id"`
+	State   string `json:"state"`   // open、pending 或 resolved
+	Updated int64  `json:"updated"` // 最后一条消息或状态变更的时间（unix 秒）
+}
+
+// putTicket 在已有的写事务中更新用户的工单状态
+func putTicket(tx *bolt.Tx, chatID int64, state string, t time.Time) error {
+	data, err := json.Marshal(Ticket{ChatID: chatID, State: state, Updated: t.Unix()})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(ticketsBucket).Put([]byte(strconv.FormatInt(chatID, 10)), data)
+}
+
+// ticketState 返回用户当前的工单状态，没有工单时返回空字符串
+func ticketState(chatID int64) string {
+	var t Ticket
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(ticketsBucket).Get([]byte(strconv.FormatInt(chatID, 10))); v != nil {
+			json.Unmarshal(v, &t)
+		}
+		return nil
+	})
+	return t.State
+}
+
+// setTicketState 更新用户的工单状态
+func setTicketState(chatID int64, state string) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		return putTicket(tx, chatID, state, time.Now())
+	})
+	if err != nil {
+		logErrorf("更新 %d 的工单状态失败: %v", chatID, err)
+	}
+}
+
+// resolveTicket 将用户的工单标记为已解决，并结束对话释放名额
+func resolveTicket(chatID int64) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(ticketsBucket).Get([]byte(strconv.FormatInt(chatID, 10))) == nil {
+			return fmt.Errorf("用户 %d 没有工单", chatID)
+		}
+		return putTicket(tx, chatID, ticketResolved, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	endConversation(chatID)
+	logInfof("工单 %d 已解决", chatID)
+	return nil
+}
+
+// openTickets 返回所有未解决的工单，等待最久的在前
+func openTickets() []Ticket {
+	var tickets []Ticket
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ticketsBucket).ForEach(func(k, v []byte) error {
+			var t Ticket
+			if json.Unmarshal(v, &t) == nil && t.State != ticketResolved {
+				tickets = append(tickets, t)
+			}
+			return nil
+		})
+	})
+	sort.Slice(tickets, func(i, j int) bool { return tickets[i].Updated < tickets[j].Updated })
+	return tickets
+}
+
+// formatTicket 生成工单列表中的一行，包含用户名和最后消息时间
+func formatTicket(t Ticket) string {
+	var info UserInfo
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(usersBucket).Get([]byte(strconv.FormatInt(t.ChatID, 10))); v != nil {
+			json.Unmarshal(v, &info)
+		}
+		return nil
+	})
+	return fmt.Sprintf("(%d)%s  %-7s  %s", t.ChatID, info.Name, t.State, time.Unix(t.Updated, 0).Format("2006-01-02 15:04:05"))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 外部工单系统推送的默认值
+const (
+	defaultTicketingTimeout     = 10 // 单次请求超时（秒）
+	defaultTicketingMaxAttempts = 3
+	ticketingQueueSize          = 256 // 等待推送的消息数，满了以后丢弃新消息
+)
+
+// 推送事件类型
+const (
+	ticketingNew     = "new"     // 新对话，或已解决、等待用户回应的对话收到新消息
+	ticketingMessage = "message" // 进行中的对话收到新消息
+)
+
+// TicketingConfig 外部工单系统的推送配置
+type TicketingConfig struct {
+	URL         string `yaml:"url"`          // 接收推送的地址，留空表示不推送
+	Timeout     int    `yaml:"timeout"`      // 单次请求超时（秒），默认 10
+	MaxAttempts int    `yaml:"max_attempts"` // 失败时最多尝试次数，默认 3
+}
+
+// ticketingPayload 推送给外部工单系统的 JSON
+type ticketingPayload struct {
+	Event     string `json:"event"` // new 或 message
+	ChatID    int64  `json:"chat_id"`
+	Name      string `json:"name"`
+	UserName  string `json:"username,omitempty"`
+	Text      string `json:"text"` // 文本内容或媒体说明，其他类型为 [类型]
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ticketingQueue 等待推送的消息，由 ticketingLoop 按顺序发送，不阻塞消息转发
+var ticketingQueue = make(chan ticketingPayload, ticketingQueueSize)
+
+// pushTicketing 把用户消息加入推送队列，未配置地址时忽略，队列已满时丢弃并记录日志
+// prevState 为收到这条消息之前的工单状态
+func pushTicketing(msg SimpleMsg, prevState string) {
+	if getConfig().Ticketing.URL == "" {
+		return
+	}
+	event := ticketingMessage
+	if prevState != ticketOpen {
+		event = ticketingNew
+	}
+	text := msg.Text
+	if text == "" {
+		text = msg.Caption
+	}
+	if text == "" {
+		text = "[" + msg.ContentType + "]"
+	}
+	p := ticketingPayload{Event: event, ChatID: msg.ChatId, Name: msg.Name, UserName: msg.UserName, Text: text, Timestamp: time.Now().Unix()}
+	select {
+	case ticketingQueue <- p:
+	default:
+		logWarnf("工单推送队列已满, 丢弃 %d 的消息", msg.ChatId)
+	}
+}
+
+// postTicketing 推送一条消息，失败时按 1、2、4 秒的间隔重试，最多 max_attempts 次
+func postTicketing(client *http.Client, p ticketingPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	attempts := getConfig().Ticketing.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultTicketingMaxAttempts
+	}
+	wait := time.Second
+	for i := 1; ; i++ {
+		err = postJSON(client, getConfig().Ticketing.URL, body)
+		if err == nil || i >= attempts {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// postJSON 发送一次 POST 请求，非 2xx 状态视为失败
+func postJSON(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("返回 %s", resp.Status)
+	}
+	return nil
+}
+
+// ticketingLoop 按顺序推送队列中的消息
+func ticketingLoop() {
+	for p := range ticketingQueue {
+		timeout := getConfig().Ticketing.Timeout
+		if timeout <= 0 {
+			timeout = defaultTicketingTimeout
+		}
+		client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+		if err := postTicketing(client, p); err != nil {
+			logWith(p.ChatID, 0).Errorf("推送 %d 的消息到工单系统失败: %v", p.ChatID, err)
+		}
+	}
+}
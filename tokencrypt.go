@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// encryptedTokenPrefix 加密 token 的前缀，不带前缀的 token 视为明文
+const encryptedTokenPrefix = "enc:"
+
+// tokenKey 读取加密 token 使用的密钥：环境变量 BOT_TOKEN_KEY，或 BOT_TOKEN_KEYFILE 指定的文件内容
+// 密钥经 SHA-256 得到 AES-256 密钥，建议使用随机生成的长字符串（如 openssl rand -hex 32）
+func tokenKey() ([]byte, error) {
+	secret := os.Getenv("BOT_TOKEN_KEY")
+	if secret == "" {
+		if path := os.Getenv("BOT_TOKEN_KEYFILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("读取密钥文件失败: %v", err)
+			}
+			secret = strings.TrimSpace(string(data))
+		}
+	}
+	if secret == "" {
+		return nil, errors.New("没有设置密钥，请设置环境变量 BOT_TOKEN_KEY 或 BOT_TOKEN_KEYFILE")
+	}
+	key := sha256.Sum256([]byte(secret))
+	return key[:], nil
+}
+
+// tokenCipher 按密钥创建 AES-GCM
+func tokenCipher() (cipher.AEAD, error) {
+	key, err := tokenKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptToken 用 AES-GCM 加密 token，返回 enc:<base64(nonce+密文)>
+func encryptToken(token string) (string, error) {
+	gcm, err := tokenCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(token), nil)
+	return encryptedTokenPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptToken 解密 enc: 开头的 token，其他 token 按明文原样返回，兼容旧配置
+func decryptToken(token string) (string, error) {
+	if !strings.HasPrefix(token, encryptedTokenPrefix) {
+		return token, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, encryptedTokenPrefix))
+	if err != nil {
+		return "", fmt.Errorf("加密的 token 格式无效: %v", err)
+	}
+	gcm, err := tokenCipher()
+	if err != nil {
+		return "", fmt.Errorf("token 已加密, %v", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("加密的 token 格式无效")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("解密 token 失败，请检查密钥是否正确")
+	}
+	return string(plain), nil
+}
+
+// runEncryptToken 执行 encrypt-token 子命令：从标准输入读取 token，输出可以填入 account.token 的加密字符串
+func runEncryptToken() error {
+	fmt.Fprint(os.Stderr, "token: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	token := strings.TrimSpace(line)
+	if token == "" {
+		if err != nil && err != io.EOF {
+			return err
+		}
+		return errors.New("token 不能为空")
+	}
+	enc, err := encryptToken(token)
+	if err != nil {
+		return err
+	}
+	fmt.Println(enc)
+	return nil
+}
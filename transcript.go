@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// messagesBucket 存储每个用户的完整对话记录，每个用户一个子 bucket，按序号追加
+var messagesBucket = []byte("messages")
+
+const (
+	directionIn  = "in"  // 用户发来的消息
+	directionOut = "out" // 发给用户的消息
+)
+
+// TranscriptEntry 对话记录中的一条消息
+type TranscriptEntry struct {
+	Time      int64  `json:"time"`      // 时间（unix 秒）
+	Direction string `json:"direction"` // in 或 out
+	Type      string `json:"type"`      // text, photo, video, file
+	Text      string `json:"text"`      // 文本内容、说明文字或文件名
+}
+
+// newTranscriptEntry 根据消息生成一条对话记录
+func newTranscriptEntry(direction string, msg SimpleMsg) TranscriptEntry {
+	entry := TranscriptEntry{Time: time.Now().Unix(), Direction: direction, Text: msg.Text}
+	switch {
+	case msg.Text != "":
+		entry.Type = "text"
+	case msg.PhotoID != "":
+		entry.Type = "photo"
+		entry.Text = msg.Caption
+	case msg.VideoID != "":
+		entry.Type = "video"
+		entry.Text = msg.Caption
+	case msg.FileID != "":
+		entry.Type = "file"
+		entry.Text = msg.FileName
+	default:
+		entry.Type = "other"
+	}
+	return entry
+}
+
+// saveTranscript 通过当前存储追加一条对话记录，失败时只记录日志
+func saveTranscript(chatID int64, entry TranscriptEntry) {
+	if err := store.AppendTranscript(chatID, entry); err != nil {
+		logWith(chatID, 0).Errorf("记录 %d 的对话失败: %v", chatID, err)
+	}
+}
+
+// appendTranscript 在已有的写事务中追加一条对话记录
+func appendTranscript(tx *bolt.Tx, chatID int64, entry TranscriptEntry) error {
+	b, err := tx.Bucket(messagesBucket).CreateBucketIfNotExists([]byte(strconv.FormatInt(chatID, 10)))
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return b.Put(key, data)
+}
+
+// recordOutgoing 记录一条管理员发给用户的消息，并把工单状态改为等待用户回应
+func recordOutgoing(chatID int64, msg SimpleMsg) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := recordStats(tx, directionOut, chatID, time.Now()); err != nil {
+			return err
+		}
+		return putTicket(tx, chatID, ticketPending, time.Now())
+	})
+	if err != nil {
+		logWith(chatID, 0).Errorf("记录发给 %d 的消息失败: %v", chatID, err)
+	}
+	saveTranscript(chatID, newTranscriptEntry(directionOut, msg))
+}
+
+// loadTranscript 读取用户最近的 n 条对话记录，n <= 0 时读取全部，按时间正序返回
+func loadTranscript(chatID int64, n int) []TranscriptEntry {
+	entries, err := store.LoadTranscript(chatID, n)
+	if err != nil {
+		logWith(chatID, 0).Errorf("读取 %d 的对话记录失败: %v", chatID, err)
+	}
+	return entries
+}
+
+// formatTranscriptEntry 将对话记录格式化为一行文本
+func formatTranscriptEntry(entry TranscriptEntry) string {
+	arrow := "<-"
+	if entry.Direction == directionOut {
+		arrow = "->"
+	}
+	text := entry.Text
+	if entry.Type != "text" {
+		text = fmt.Sprintf("[%s] %s", entry.Type, entry.Text)
+	}
+	return fmt.Sprintf("%s %s %s", time.Unix(entry.Time, 0).Format("2006-01-02 15:04:05"), arrow, text)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Bot API 允许上传的最大文件大小
+const (
+	maxUploadPhotoSize    = 10 * 1024 * 1024 // 图片 10MB
+	maxUploadDocumentSize = 50 * 1024 * 1024 // 文件 50MB
+)
+
+// 媒体类型
+const (
+	mediaPhoto    = "photo"
+	mediaDocument = "doc"
+)
+
+// sendMedia 向用户发送图片或文件，成功后更新统计和对话记录，返回 Telegram 的 file ID 以便复用
+func sendMedia(chatID int64, kind string, file tgbotapi.RequestFileData) (string, error) {
+	var c tgbotapi.Chattable
+	if kind == mediaPhoto {
+		c = tgbotapi.NewPhoto(chatID, file)
+	} else {
+		c = tgbotapi.NewDocument(chatID, file)
+	}
+	sent, err := sendWithRetry(c)
+	if err != nil {
+		return "", err
+	}
+
+	record := SimpleMsg{}
+	switch {
+	case len(sent.Photo) > 0:
+		record.PhotoID = sent.Photo[len(sent.Photo)-1].FileID
+	case sent.Document != nil:
+		record.FileID = sent.Document.FileID
+		record.FileName = sent.Document.FileName
+	}
+	messagesSent.Inc()
+	touchConversation(chatID)
+	recordOutgoing(chatID, record)
+	if record.PhotoID != "" {
+		return record.PhotoID, nil
+	}
+	return record.FileID, nil
+}
+
+// sendLocalFile 上传服务器上的文件发送给用户，发送前检查文件是否存在以及大小限制
+func sendLocalFile(chatID int64, kind, path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("无法读取文件: %v", err)
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("%s 是目录", path)
+	}
+	limit := int64(maxUploadDocumentSize)
+	if kind == mediaPhoto {
+		limit = maxUploadPhotoSize
+	}
+	if fi.Size() > limit {
+		return "", fmt.Errorf("文件大小 %d 字节超过限制 %d 字节", fi.Size(), limit)
+	}
+	fileID, err := sendMedia(chatID, kind, tgbotapi.FilePath(path))
+	if err != nil {
+		return "", fmt.Errorf("发送 %s 给 %d 失败: %v", path, chatID, err)
+	}
+	logDebugf("发送本地文件 %s 给 %d, file id %s", path, chatID, fileID)
+	return fileID, nil
+}
+
+// sendURLFile 让 Telegram 从 URL 拉取图片或文件发送给用户，只接受 http(s) 地址
+func sendURLFile(chatID int64, kind, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("无效的地址，只支持 http(s): %s", rawURL)
+	}
+	fileID, err := sendMedia(chatID, kind, tgbotapi.FileURL(rawURL))
+	if err != nil {
+		// Telegram 无法获取该地址时（如需要登录、类型不符、超过大小限制）会返回错误
+		return "", fmt.Errorf("发送 %s 给 %d 失败: %v", rawURL, chatID, err)
+	}
+	logDebugf("发送 %s 给 %d, file id %s", rawURL, chatID, fileID)
+	return fileID, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// usersBucket 存储联系过机器人的用户信息
+var usersBucket = []byte("users")
+
+// optoutBucket 存储退订广播的用户
+var optoutBucket = []byte("optout")
+
+// subscribedBucket 存储通过 /subscribe 主动订阅广播的用户，broadcast.audience 为 subscribers 时只发给这些用户
+var subscribedBucket = []byte("subscribed")
+
+// inactiveBucket 存储已屏蔽机器人、无法再收到消息的用户
+var inactiveBucket = []byte("inactive")
+
+// languageBucket 存储用户通过 /lang 指定的语言，优先于自动检测的语言
+var languageBucket = []byte("language")
+
+// UserInfo 存储用户的基本信息
+type UserInfo struct {
+	ChatID    int64  `json:"chat_id"`
+	Name      string `json:"name"`
+	UserName  string `json:"username,omitempty"`
+	FirstSeen int64  `json:"first_seen"`         // 首次联系时间（unix 秒）
+	LastSeen  int64  `json:"last_seen"`          // 最后一次联系时间（unix 秒）
+	Language  string `json:"language,omitempty"` // 根据客户端检测到的语言代码
+
+	ProfileShown bool `json:"profile_shown,omitempty"` // 是否已向管理员发送过资料卡
+	NoMessage    bool `json:"no_message,omitempty"`    // 由导入或命令创建，用户还没有发来过需要转发的消息
+	Welcomed     bool `json:"welcomed,omitempty"`      // 是否已发送过欢迎消息（/start 或首次联系）
+}
+
+// touchUser 用户发来需要转发的消息时记录或更新用户信息，返回是否为首次联系
+// 由导入或只发过 /start 等命令的用户第一次发来消息时同样算作首次联系
+func touchUser(msg SimpleMsg) bool {
+	return saveUser(msg, true)
+}
+
+// recordUser 用户发送命令时记录或更新用户信息，不算作首次联系
+func recordUser(msg SimpleMsg) {
+	saveUser(msg, false)
+}
+
+// saveUser 记录或更新用户信息，message 表示这是一条需要转发的消息
+// 返回这是否为用户的第一条消息
+func saveUser(msg SimpleMsg, message bool) bool {
+	isNew, created := false, false
+	now := time.Now().Unix()
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		key := []byte(strconv.FormatInt(msg.ChatId, 10))
+		var info UserInfo
+		if v := b.Get(key); v != nil {
+			json.Unmarshal(v, &info)
+		} else {
+			created = true
+			info.ChatID = msg.ChatId
+			info.FirstSeen = now
+			info.NoMessage = true
+		}
+		if message && info.NoMessage {
+			isNew = true
+			info.NoMessage = false
+		}
+		oldName, oldUserName := info.Name, info.UserName
+		if msg.Name != "" {
+			info.Name = msg.Name
+		}
+		if msg.UserName != "" {
+			info.UserName = msg.UserName
+		}
+		if err := updateNameIndex(tx, msg.ChatId, oldName, info.Name); err != nil {
+			return err
+		}
+		if err := updateNameIndex(tx, msg.ChatId, oldUserName, info.UserName); err != nil {
+			return err
+		}
+		if lang := normalizeLanguage(msg.LanguageCode); lang != "" {
+			info.Language = lang
+		}
+		info.LastSeen = now
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+	if err != nil {
+		logErrorf("记录用户 %d 失败: %v", msg.ChatId, err)
+	} else {
+		syncUser(msg.ChatId)
+	}
+	if created {
+		refreshActiveUsers()
+	}
+	return isNew
+}
+
+// userLanguage 返回用户的语言代码，优先使用 /lang 指定的语言，其次为自动检测的语言，未知时返回空字符串
+func userLanguage(chatID int64) string {
+	var lang string
+	db.View(func(tx *bolt.Tx) error {
+		key := []byte(strconv.FormatInt(chatID, 10))
+		if v := tx.Bucket(languageBucket).Get(key); v != nil {
+			lang = string(v)
+			return nil
+		}
+		var info UserInfo
+		if v := tx.Bucket(usersBucket).Get(key); v != nil && json.Unmarshal(v, &info) == nil {
+			lang = info.Language
+		}
+		return nil
+	})
+	return lang
+}
+
+// setLanguageOverride 保存用户指定的语言，lang 为空时清除，恢复自动检测
+func setLanguageOverride(chatID int64, lang string) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(languageBucket)
+		key := []byte(strconv.FormatInt(chatID, 10))
+		if lang == "" {
+			return b.Delete(key)
+		}
+		return b.Put(key, []byte(lang))
+	})
+	if err == nil {
+		syncUser(chatID)
+	}
+	return err
+}
+
+// claimWelcome 记录已向用户发送欢迎消息，之前没有发送过时返回 true
+// 先发送 /start 的用户第一次发来消息时不再重复发送首次联系的欢迎消息
+func claimWelcome(chatID int64) bool {
+	claimed := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		key := []byte(strconv.FormatInt(chatID, 10))
+		v := b.Get(key)
+		if v == nil {
+			return nil
+		}
+		var info UserInfo
+		if err := json.Unmarshal(v, &info); err != nil || info.Welcomed {
+			return err
+		}
+		info.Welcomed, claimed = true, true
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+	if err != nil {
+		logErrorf("记录用户 %d 的欢迎消息状态失败: %v", chatID, err)
+		return false
+	}
+	return claimed
+}
+
+// syncUser 修改 BoltDB 中的用户后让存储后端同步该用户，失败时只记录警告
+func syncUser(chatID int64) {
+	if err := store.SyncUser(chatID); err != nil {
+		logWarnf("同步用户 %d 到存储后端失败: %v", chatID, err)
+	}
+}
+
+// recentUsers 返回最近联系过的 n 个用户，按最后联系时间倒序
+func recentUsers(n int) []UserInfo {
+	users := listUsers()
+	sort.Slice(users, func(i, j int) bool { return users[i].LastSeen > users[j].LastSeen })
+	if n > 0 && len(users) > n {
+		users = users[:n]
+	}
+	return users
+}
+
+// listUserIDs 返回所有已知用户的聊天ID
+func listUserIDs() []int64 {
+	var ids []int64
+	for _, info := range listUsers() {
+		ids = append(ids, info.ChatID)
+	}
+	return ids
+}
+
+// listUsers 返回所有已知用户的信息，读取失败时只记录日志
+func listUsers() []UserInfo {
+	users, err := store.ListUsers()
+	if err != nil {
+		logErrorf("读取用户列表失败: %v", err)
+	}
+	return users
+}
+
+// setUserFlag 在指定 bucket 中设置或清除用户标记
+func setUserFlag(bucket []byte, chatID int64, on bool) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		key := []byte(strconv.FormatInt(chatID, 10))
+		if !on {
+			return b.Delete(key)
+		}
+		return b.Put(key, []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+	})
+	if err == nil {
+		syncUser(chatID)
+	}
+	return err
+}
+
+// hasUserFlag 判断用户在指定 bucket 中是否有标记
+func hasUserFlag(bucket []byte, chatID int64) bool {
+	found := false
+	db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(bucket).Get([]byte(strconv.FormatInt(chatID, 10))) != nil
+		return nil
+	})
+	return found
+}
+
+// markUnreachable 将屏蔽了机器人的用户标记为不可达，并通知管理员一次
+func markUnreachable(chatID int64) {
+	if chatID == 0 || chatID == getConfig().Account.Owner || hasUserFlag(inactiveBucket, chatID) {
+		return
+	}
+	if err := setUserFlag(inactiveBucket, chatID, true); err != nil {
+		logErrorf("标记用户 %d 不可达失败: %v", chatID, err)
+		return
+	}
+	logInfof("用户 %d 已屏蔽机器人, 标记为不可达", chatID)
+	refreshActiveUsers()
+	SendPlain(getConfig().Account.Owner, fmt.Sprintf("用户 %d 已屏蔽机器人，之后的广播将跳过该用户", chatID))
+}
+
+// markReachable 用户重新发来消息时清除不可达标记
+func markReachable(chatID int64) {
+	if hasUserFlag(inactiveBucket, chatID) {
+		setUserFlag(inactiveBucket, chatID, false)
+		logInfof("用户 %d 重新可达", chatID)
+		refreshActiveUsers()
+	}
+}
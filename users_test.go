@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// firstContactSetups 用户发来第一条普通消息之前的几种状态
+var firstContactSetups = []struct {
+	name  string
+	setup func(t *testing.T, chatID int64)
+	first bool // 下一条消息是否算作首次联系
+}{
+	{"fresh", func(t *testing.T, chatID int64) {}, true},
+	{"imported", func(t *testing.T, chatID int64) {
+		if _, err := importUsers([]importEntry{{ChatID: chatID, Name: "Imported"}}); err != nil {
+			t.Fatal(err)
+		}
+	}, true},
+	{"start only", func(t *testing.T, chatID int64) {
+		commander(privateMsg(chatID, 1, "/start"))
+	}, true},
+	{"returning", func(t *testing.T, chatID int64) {
+		deliverIncomingMsg(privateMsg(chatID, 1, "earlier"))
+	}, false},
+}
+
+// countText 统计 texts 中等于 want 的条数
+func countText(texts []string, want string) int {
+	n := 0
+	for _, s := range texts {
+		if s == want {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWelcomeOnFirstContact(t *testing.T) {
+	welcome := messagesFor("").Welcome
+	for _, on := range []bool{false, true} {
+		for _, tt := range firstContactSetups {
+			name := tt.name + "/off"
+			if on {
+				name = tt.name + "/on"
+			}
+			t.Run(name, func(t *testing.T) {
+				setupTestDB(t)
+				tg := newFakeTelegram(t)
+				getConfig().Welcome.OnFirstContact = on
+				const chatID = 2790
+				tt.setup(t, chatID)
+				tg.reset()
+
+				deliverIncomingMsg(privateMsg(chatID, 2, "hello"))
+
+				// 发送过 /start 的用户已经收到过欢迎消息
+				want := 0
+				if on && tt.first && tt.name != "start only" {
+					want = 1
+				}
+				if got := countText(tg.sentTo(chatID), welcome); got != want {
+					t.Errorf("welcome sent %d times, want %d", got, want)
+				}
+				if got := len(tg.callsTo("forwardMessage")); got != 1 {
+					t.Errorf("forwardMessage called %d times, want 1", got)
+				}
+			})
+		}
+	}
+}
+
+func TestStartThenMessageWelcomesOnce(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	getConfig().Welcome.OnFirstContact = true
+	welcome := messagesFor("").Welcome
+
+	commander(privateMsg(2791, 1, "/start"))
+	deliverIncomingMsg(privateMsg(2791, 2, "hello"))
+	deliverIncomingMsg(privateMsg(2791, 3, "hello again"))
+
+	if got := countText(tg.sentTo(2791), welcome); got != 1 {
+		t.Errorf("welcome sent %d times after /start and two messages, want 1", got)
+	}
+	if got := len(tg.callsTo("forwardMessage")); got != 2 {
+		t.Errorf("forwardMessage called %d times, want 2", got)
+	}
+}
+
+func TestProfileCardOnFirstContact(t *testing.T) {
+	for _, tt := range firstContactSetups {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			tg := newFakeTelegram(t)
+			const chatID = 2791
+			tt.setup(t, chatID)
+			tg.reset()
+
+			deliverIncomingMsg(privateMsg(chatID, 2, "hello"))
+
+			cards := 0
+			for _, s := range tg.sentTo(testOwner) {
+				if strings.HasPrefix(s, "🆕") {
+					cards++
+				}
+			}
+			want := 0
+			if tt.first {
+				want = 1
+			}
+			if cards != want {
+				t.Errorf("profile card sent %d times, want %d", cards, want)
+			}
+		})
+	}
+}
+
+func TestCaptchaOnFirstContact(t *testing.T) {
+	for _, tt := range firstContactSetups {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			tg := newFakeTelegram(t)
+			const chatID = 2792
+			tt.setup(t, chatID)
+			getConfig().Captcha.Enabled = true
+			tg.reset()
+
+			deliverIncomingMsg(privateMsg(chatID, 2, "hello"))
+
+			forwarded := len(tg.callsTo("forwardMessage")) == 1
+			if forwarded == tt.first {
+				t.Errorf("message forwarded = %v, want held for captcha = %v", forwarded, tt.first)
+			}
+		})
+	}
+}
+
+func TestCommandsDoNotCountAsFirstContact(t *testing.T) {
+	setupTestDB(t)
+	newFakeTelegram(t)
+
+	for i, cmd := range []string{"/start", "/subscribe", "/stop", "/lang en"} {
+		commander(privateMsg(2793, i+1, cmd))
+	}
+	if !touchUser(privateMsg(2793, 10, "hello")) {
+		t.Error("first message after commands is not a first contact")
+	}
+	if touchUser(privateMsg(2793, 11, "again")) {
+		t.Error("second message is a first contact")
+	}
+}
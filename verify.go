@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// checkWebhookMode 对比配置的工作模式和机器人账号实际的 webhook 状态，返回发现的问题
+func checkWebhookMode(mode, endpoint string, info tgbotapi.WebhookInfo) []string {
+	var problems []string
+	switch mode {
+	case "webhook":
+		if !info.IsSet() {
+			problems = append(problems, "配置为 webhook 模式，但账号上没有设置 webhook，Telegram 不会推送更新")
+		} else if endpoint != "" && info.URL != endpoint {
+			problems = append(problems, fmt.Sprintf("webhook 地址 %s 与配置的 endpoint %s 不一致", info.URL, endpoint))
+		}
+		if info.LastErrorDate != 0 {
+			problems = append(problems, fmt.Sprintf("webhook 最近一次错误 (%s): %s",
+				time.Unix(int64(info.LastErrorDate), 0).Format("2006-01-02 15:04:05"), info.LastErrorMessage))
+		}
+	default:
+		if info.IsSet() {
+			problems = append(problems, fmt.Sprintf("配置为 polling 模式，但账号上设置了 webhook %s，getUpdates 会失败", info.URL))
+		}
+	}
+	return problems
+}
+
+// verifyConfig 获取实际的 webhook 状态并检查与配置是否一致
+func verifyConfig() ([]string, error) {
+	info, err := getBot().GetWebhookInfo()
+	if err != nil {
+		return nil, fmt.Errorf("获取 webhook 信息失败: %v", err)
+	}
+	return checkWebhookMode(getConfig().Account.Mode, getConfig().Account.Endpoint, info), nil
+}
+
+// clearWebhook 删除账号上的 webhook，用于 polling 模式
+func clearWebhook() error {
+	if _, err := getBot().Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return fmt.Errorf("删除 webhook 失败: %v", err)
+	}
+	logInfof("已删除 webhook")
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestCheckWebhookMode(t *testing.T) {
+	const endpoint = "https://example.com/bot"
+	tests := []struct {
+		name, mode string
+		info       tgbotapi.WebhookInfo
+		want       []string // 每个问题中应包含的内容，nil 表示没有问题
+	}{
+		{"webhook ok", "webhook", tgbotapi.WebhookInfo{URL: endpoint}, nil},
+		{"webhook not set", "webhook", tgbotapi.WebhookInfo{}, []string{"没有设置 webhook"}},
+		{"webhook other url", "webhook", tgbotapi.WebhookInfo{URL: "https://old.example.com/bot"}, []string{"https://old.example.com/bot 与配置的 endpoint " + endpoint}},
+		{"webhook last error", "webhook", tgbotapi.WebhookInfo{URL: endpoint, LastErrorDate: 1700000000, LastErrorMessage: "Connection refused"}, []string{"Connection refused"}},
+		{"webhook not set with error", "webhook", tgbotapi.WebhookInfo{LastErrorDate: 1700000000, LastErrorMessage: "timeout"}, []string{"没有设置 webhook", "timeout"}},
+		{"polling ok", "polling", tgbotapi.WebhookInfo{}, nil},
+		{"polling default mode", "", tgbotapi.WebhookInfo{URL: endpoint}, []string{"getUpdates 会失败"}},
+		{"polling with webhook", "polling", tgbotapi.WebhookInfo{URL: endpoint}, []string{"设置了 webhook " + endpoint}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkWebhookMode(tt.mode, endpoint, tt.info)
+			if len(got) != len(tt.want) {
+				t.Fatalf("problems = %q, want %d", got, len(tt.want))
+			}
+			for i, w := range tt.want {
+				if !strings.Contains(got[i], w) {
+					t.Errorf("problem %d = %q, want it to mention %q", i, got[i], w)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyConfig(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+	getConfig().Account.Mode = "webhook"
+	getConfig().Account.Endpoint = "https://example.com/bot"
+
+	tg.respond("getWebhookInfo", `{"url":"https://example.com/other","pending_update_count":3}`)
+	problems, err := verifyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "https://example.com/other") {
+		t.Errorf("problems = %q, want one endpoint mismatch", problems)
+	}
+
+	tg.respond("getWebhookInfo", `{"url":"https://example.com/bot","pending_update_count":0}`)
+	if problems, err := verifyConfig(); err != nil || len(problems) != 0 {
+		t.Errorf("verifyConfig = %q, %v, want no problems", problems, err)
+	}
+
+	tg.failMethod("getWebhookInfo", "Unauthorized")
+	if _, err := verifyConfig(); err == nil {
+		t.Error("verifyConfig returned no error when getWebhookInfo failed")
+	}
+}
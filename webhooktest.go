@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// webhookTestUpdateID 合成测试更新使用的 update_id，Telegram 不会下发负数ID
+const webhookTestUpdateID = -1
+
+// webhookTestTimeout 等待处理函数收到测试更新的最长时间
+const webhookTestTimeout = 5 * time.Second
+
+// webhookTestCh 处理函数收到测试更新时通知 webhookTest
+var webhookTestCh = make(chan struct{}, 1)
+
+// webhookTestHeader webhook-test 携带本进程密钥的请求头，带有正确密钥的请求不受 webhook_allowlist 限制
+const webhookTestHeader = "X-Webhook-Test-Key"
+
+// webhookTestKey 每次启动随机生成的密钥，只有本进程发出的 webhook-test 请求知道
+var webhookTestKey = newWebhookTestKey()
+
+// newWebhookTestKey 生成 webhook-test 使用的随机密钥
+func newWebhookTestKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("生成 webhook-test 密钥失败: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// isWebhookTestRequest 判断请求是否带有本进程的 webhook-test 密钥
+func isWebhookTestRequest(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookTestHeader)), []byte(webhookTestKey)) == 1
+}
+
+// webhookTest 向本地 webhook 地址 POST 一条合成更新，确认整个 webhook 处理链路正常
+func webhookTest() error {
+	if currentMode() != "webhook" {
+		return fmt.Errorf("webhook-test 仅在 webhook 模式下可用")
+	}
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", getConfig().Account.Port, webhookListenPath(getConfig().Account.Endpoint, getConfig().Account.WebhookPath))
+	return postWebhookTest(url)
+}
+
+// postWebhookTest 像 Telegram 一样带上 secret_token 把合成更新 POST 到 url，并等待处理函数收到它
+func postWebhookTest(url string) error {
+	body, err := json.Marshal(tgbotapi.Update{UpdateID: webhookTestUpdateID})
+	if err != nil {
+		return err
+	}
+	// 清掉之前残留的通知
+	select {
+	case <-webhookTestCh:
+	default:
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTestHeader, webhookTestKey)
+	if secret := getConfig().Account.SecretToken; secret != "" {
+		req.Header.Set(secretTokenHeader, secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("POST %s 失败: %v", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s 返回 %s", url, resp.Status)
+	}
+
+	select {
+	case <-webhookTestCh:
+		return nil
+	case <-time.After(webhookTestTimeout):
+		return fmt.Errorf("webhook 已接收请求，但 %v 内处理函数没有收到更新", webhookTestTimeout)
+	}
+}
+
+// isWebhookTestUpdate 判断是否为 webhookTest 发出的合成更新，是则通知等待方
+func isWebhookTestUpdate(update tgbotapi.Update) bool {
+	if update.UpdateID != webhookTestUpdateID {
+		return false
+	}
+	select {
+	case webhookTestCh <- struct{}{}:
+	default:
+	}
+	return true
+}
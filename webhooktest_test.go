@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// startTestWebhook 在 path 上启动与 InitBot 相同的 webhook 处理链路，返回完整地址
+func startTestWebhook(t *testing.T, path string) string {
+	t.Helper()
+	pool := newUpdatePool(1, handleUpdate)
+	srv := httptest.NewServer(telegramOnly(webhookHandler(pool, path)))
+	t.Cleanup(func() {
+		srv.Close()
+		pool.close()
+	})
+	return srv.URL + path
+}
+
+func TestWebhookTestUpdateHandled(t *testing.T) {
+	setConfig(&Config{})
+	getConfig().Account.SecretToken = "s3cret-token"
+	url := startTestWebhook(t, "/hook")
+
+	if err := postWebhookTest(url); err != nil {
+		t.Fatalf("postWebhookTest: %v", err)
+	}
+}
+
+func TestWebhookRejectsWrongSecret(t *testing.T) {
+	setConfig(&Config{})
+	getConfig().Account.SecretToken = "s3cret-token"
+	url := startTestWebhook(t, "/hook")
+
+	for _, secret := range []string{"", "wrong"} {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"update_id":-1}`))
+		if secret != "" {
+			req.Header.Set(secretTokenHeader, secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("secret %q: status %d, want 403", secret, resp.StatusCode)
+		}
+	}
+	select {
+	case <-webhookTestCh:
+		t.Error("update with a wrong secret reached the handler")
+	default:
+	}
+}
+
+func TestWebhookTestWrongPath(t *testing.T) {
+	setConfig(&Config{})
+	url := startTestWebhook(t, "/hook")
+
+	if err := postWebhookTest(strings.TrimSuffix(url, "/hook") + "/other"); err == nil {
+		t.Error("postWebhookTest to an unknown path succeeded")
+	}
+}
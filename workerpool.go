@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// defaultWorkers 未配置时处理更新的 worker 数量
+const defaultWorkers = 4
+
+// workerQueueSize 每个 worker 的缓冲队列长度
+const workerQueueSize = 64
+
+// updatePool 按聊天ID把更新分配给固定数量的 worker
+// 同一聊天的更新总是由同一个 worker 顺序处理，不同聊天之间并行，某个用户发送缓慢不会阻塞其他用户
+// 放行暂缓消息等不是由更新触发的操作也通过 run 交给该聊天的 worker，与用户的更新按顺序处理
+type updatePool struct {
+	queues  []chan func()
+	handler BotHandler
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closed  bool           // 已开始关闭，run 直接在调用方执行
+	pending sync.WaitGroup // run 中等待放入已满队列的任务
+}
+
+// newUpdatePool 创建并启动 n 个 worker
+func newUpdatePool(n int, handler BotHandler) *updatePool {
+	if n <= 0 {
+		n = defaultWorkers
+	}
+	p := &updatePool{queues: make([]chan func(), n), handler: handler}
+	for i := range p.queues {
+		q := make(chan func(), workerQueueSize)
+		p.queues[i] = q
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range q {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// queue 返回聊天对应的 worker 队列
+func (p *updatePool) queue(chatID int64) chan func() {
+	if chatID < 0 {
+		chatID = -chatID
+	}
+	return p.queues[chatID%int64(len(p.queues))]
+}
+
+// dispatch 把更新放入对应聊天的 worker 队列，队列已满时阻塞，最近处理过的更新直接丢弃
+func (p *updatePool) dispatch(update tgbotapi.Update) {
+	if update.UpdateID != webhookTestUpdateID && !seenUpdates.add(update.UpdateID) {
+		logWarnf("跳过重复的更新 %d", update.UpdateID)
+		return
+	}
+	p.queue(updateChatID(update)) <- func() { p.handler(update) }
+}
+
+// run 在聊天对应的 worker 中执行 fn，where 用于记录 fn 中发生的 panic
+// 调用方可能本身就是 worker，队列已满时在新的 goroutine 中等待放入队列而不阻塞调用方，此时不保证与之后的更新的顺序
+// 已开始关闭时直接在调用方执行
+func (p *updatePool) run(chatID int64, where string, fn func()) {
+	task := func() {
+		defer recoverPanic(where)
+		fn()
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		task()
+		return
+	}
+	q := p.queue(chatID)
+	select {
+	case q <- task:
+	default:
+		p.pending.Add(1)
+		go func() {
+			defer p.pending.Done()
+			q <- task
+		}()
+	}
+	p.mu.Unlock()
+}
+
+// close 停止接收新的更新，等待队列中的更新处理完毕
+func (p *updatePool) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.pending.Wait()
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+// currentPool 正在运行的 InitBot 使用的 worker pool，机器人未运行时为 nil
+var currentPool atomic.Pointer[updatePool]
+
+// runOnWorker 在聊天对应的 worker 中执行 fn，机器人未运行时（如测试）直接在调用方执行
+func runOnWorker(chatID int64, where string, fn func()) {
+	if p := currentPool.Load(); p != nil {
+		p.run(chatID, where, fn)
+		return
+	}
+	defer recoverPanic(where)
+	fn()
+}
+
+// recentUpdateCount 记住的最近更新数量，重连时重复下发的更新都在这个范围内
+const recentUpdateCount = 1024
+
+// seenUpdates 最近收到的更新ID，重启机器人后仍然保留
+var seenUpdates = newUpdateSet(recentUpdateCount)
+
+// updateSet 固定容量的更新ID集合，满了以后淘汰最早加入的ID
+// webhook 模式下多个请求会同时调用 dispatch，因此加锁
+type updateSet struct {
+	mu   sync.Mutex
+	ids  map[int]bool
+	ring []int
+	next int
+}
+
+// newUpdateSet 创建最多记住 n 个ID的集合
+func newUpdateSet(n int) *updateSet {
+	return &updateSet{ids: make(map[int]bool, n), ring: make([]int, 0, n)}
+}
+
+// add 记录更新ID，已经记录过时返回 false
+func (s *updateSet) add(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids[id] {
+		return false
+	}
+	if len(s.ring) < cap(s.ring) {
+		s.ring = append(s.ring, id)
+	} else {
+		delete(s.ids, s.ring[s.next])
+		s.ring[s.next] = id
+		s.next = (s.next + 1) % len(s.ring)
+	}
+	s.ids[id] = true
+	return true
+}
+
+// updateChatID 返回更新所属的聊天ID，用于保证同一聊天的更新按顺序处理
+func updateChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil && update.EditedMessage.Chat != nil:
+		return update.EditedMessage.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return update.CallbackQuery.From.ID
+	}
+	return 0
+}
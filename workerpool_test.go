@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestDuplicateUpdateForwardedOnce(t *testing.T) {
+	setupTestDB(t)
+	tg := newFakeTelegram(t)
+
+	pool := newUpdatePool(2, handleUpdate)
+	update := userUpdate(337000, 3370, 1, "hello")
+	pool.dispatch(update)
+	pool.dispatch(update)
+	pool.close()
+
+	if got := len(tg.callsTo("forwardMessage")); got != 1 {
+		t.Fatalf("forwardMessage called %d times, want 1", got)
+	}
+}
+
+func TestUpdateSetEvictsOldest(t *testing.T) {
+	s := newUpdateSet(3)
+	for _, id := range []int{1, 2, 3} {
+		if !s.add(id) {
+			t.Fatalf("add(%d) = false on first sight", id)
+		}
+	}
+	if s.add(2) {
+		t.Error("add(2) = true for an id still in the set")
+	}
+
+	// 第4个ID挤掉最早的1，2和3仍然记得
+	if !s.add(4) {
+		t.Fatal("add(4) = false on first sight")
+	}
+	if !s.add(1) {
+		t.Error("add(1) = false after it was evicted")
+	}
+	// 再加入1挤掉了2
+	if !s.add(2) {
+		t.Error("add(2) = false after it was evicted")
+	}
+	for _, id := range []int{4, 1, 2} {
+		if s.add(id) {
+			t.Errorf("add(%d) = true for an id still in the set", id)
+		}
+	}
+}
+
+func TestRunAfterChatUpdates(t *testing.T) {
+	seenUpdates = newUpdateSet(recentUpdateCount)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, s)
+	}
+	pool := newUpdatePool(2, func(update tgbotapi.Update) {
+		<-release
+		record(update.Message.Text)
+	})
+
+	// 放行操作在用户的 worker 中执行，排在用户已收到的更新之后
+	pool.dispatch(userUpdate(324000, 3240, 1, "update"))
+	pool.run(3240, "测试", func() { record("run") })
+	close(release)
+	pool.close()
+
+	if len(order) != 2 || order[0] != "update" || order[1] != "run" {
+		t.Errorf("order = %q, want [update run]", order)
+	}
+}
+
+func TestRunFromWorkerWithFullQueue(t *testing.T) {
+	seenUpdates = newUpdateSet(recentUpdateCount)
+	var pool *updatePool
+	var mu sync.Mutex
+	ran := 0
+	// worker 向自己已满的队列提交任务时不能阻塞
+	pool = newUpdatePool(1, func(update tgbotapi.Update) {
+		for i := 0; i < workerQueueSize*2; i++ {
+			pool.run(3241, "测试", func() {
+				mu.Lock()
+				defer mu.Unlock()
+				ran++
+			})
+		}
+	})
+	pool.dispatch(userUpdate(324100, 3241, 1, "update"))
+	pool.close()
+
+	if ran != workerQueueSize*2 {
+		t.Errorf("ran %d tasks, want %d", ran, workerQueueSize*2)
+	}
+}